@@ -81,23 +81,53 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
+	//超出配额时可能需要等待自动merge回收空间之后重试
+	err := wb.commit()
+	if wb.db.reclaimForQuota(err) {
+		err = wb.commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	//清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	return nil
+}
+
+// 将批次中的数据写入数据文件并更新内存索引
+func (wb *WriteBatch) commit() error {
 	//这里加锁保证事务提交的串行化(下面涉及到对db中全局递增变量seqNo进行加一的操作)
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	//整个批次写入之前检查配额，避免写入一半之后才发现超出
+	newKeys, size := wb.quotaUsage()
+	if err := wb.db.checkKeysQuota(newKeys); err != nil {
+		return err
+	}
+	if err := wb.db.checkDiskQuota(size); err != nil {
+		return err
+	}
+
 	//接下来就是实际的写入数据
 	//首先获取当前最新的事务序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1) //原子操作来递增一个无符号整数（uint64）变量，并将递增后的值赋给变量seqNo
 
 	positions := make(map[string]*data.LogRecordPos) //用来保存将事务的logrecord存放的位置   后续将用于内存索引更新
 
+	//同一个批次中的记录使用相同的提交时间
+	commitTs := wb.db.commitTimestamp()
+
 	//开始写数据到数据文件中
 	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{ //注意此前db.appendLogRecord函数内部已经上锁了，所以我们更改了一下db.go中的源码，添加了db.appendLogRecordWithLock的逻辑
+		logRecord := &data.LogRecord{
 			Key:   logRecordKeyWithSeq(record.Key, seqNo), //将序列号也编码到key中
 			Value: record.Value,
 			Type:  record.Type,
-		})
+		}
+		wb.db.setVersion(record.Key, logRecord, commitTs)
+		logRecordPos, err := wb.db.appendLogRecord(logRecord) //注意此前db.appendLogRecord函数内部已经上锁了，所以我们更改了一下db.go中的源码，添加了db.appendLogRecordWithLock的逻辑
 		if err != nil {
 			return err
 		}
@@ -106,8 +136,9 @@ func (wb *WriteBatch) Commit() error {
 
 	//前面的提交都已成功需要加上一条事务完成的标志
 	finishedRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
-		Type: data.LogRecordTxnFinished,
+		Key:       logRecordKeyWithSeq(txnFinKey, seqNo),
+		Type:      data.LogRecordTxnFinished,
+		Timestamp: commitTs,
 	}
 	_, err := wb.db.appendLogRecord(finishedRecord)
 	if err != nil {
@@ -123,21 +154,8 @@ func (wb *WriteBatch) Commit() error {
 
 	//更新对应的内存索引
 	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
-		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
-		}
+		wb.db.updateIndex(record.Key, record.Type, positions[string(record.Key)])
 	}
-
-	//清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
 	return nil
 }
 
@@ -0,0 +1,84 @@
+package benchmark
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/utils"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// 对比数据文件使用标准文件IO、可读写mmap以及direct io时的读写性能
+
+func openIOBenchDB(b *testing.B, ioType bitcask.IOType) *bitcask.DB {
+	options := bitcask.DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-bench-io")
+	options.DirPath = dir
+	options.DataFileSize = 64 * 1024 * 1024
+	options.ActiveIOType = ioType
+	options.OlderIOType = ioType
+	db, err := bitcask.OpenDB(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return db
+}
+
+func benchmarkIOPut(b *testing.B, ioType bitcask.IOType) {
+	db := openIOBenchDB(b, ioType)
+	value := utils.RandomValue(1024)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := db.Put(utils.GetTestKey(i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkIOGet(b *testing.B, ioType bitcask.IOType) {
+	db := openIOBenchDB(b, ioType)
+	const keyNum = 100000
+	for i := 0; i < keyNum; i++ {
+		if err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Get(utils.GetTestKey(rand.Intn(keyNum))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_Put_FileIO(b *testing.B) {
+	benchmarkIOPut(b, bitcask.StandardIO)
+}
+
+func Benchmark_Put_MMapIO(b *testing.B) {
+	benchmarkIOPut(b, bitcask.MMapIO)
+}
+
+func Benchmark_Put_DirectIO(b *testing.B) {
+	benchmarkIOPut(b, bitcask.DirectIO)
+}
+
+func Benchmark_Get_FileIO(b *testing.B) {
+	benchmarkIOGet(b, bitcask.StandardIO)
+}
+
+func Benchmark_Get_MMapIO(b *testing.B) {
+	benchmarkIOGet(b, bitcask.MMapIO)
+}
+
+func Benchmark_Get_DirectIO(b *testing.B) {
+	benchmarkIOGet(b, bitcask.DirectIO)
+}
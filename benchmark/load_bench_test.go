@@ -0,0 +1,89 @@
+package benchmark
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// 启动时加载索引的基准测试，对比不同的并发数以及IO类型
+
+const loadBenchKeyNum = 200000
+
+var (
+	loadBenchOnce sync.Once
+	loadBenchDir  string
+)
+
+// 准备一个包含大量数据文件的目录，只生成一次
+func prepareLoadBenchDir(b *testing.B) string {
+	loadBenchOnce.Do(func() {
+		options := bitcask.DefaultOptioins
+		loadBenchDir, _ = os.MkdirTemp("", "bitcask-go-bench-load")
+		options.DirPath = loadBenchDir
+		options.DataFileSize = 8 * 1024 * 1024
+		db, err := bitcask.OpenDB(options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < loadBenchKeyNum; i++ {
+			if err := db.Put(utils.GetTestKey(i), utils.RandomValue(512)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			b.Fatal(err)
+		}
+	})
+	return loadBenchDir
+}
+
+func benchmarkOpenDB(b *testing.B, concurrency int, mmapAtStartup bool) {
+	dir := prepareLoadBenchDir(b)
+	options := bitcask.DefaultOptioins
+	options.DirPath = dir
+	options.DataFileSize = 8 * 1024 * 1024
+	options.LoadConcurrency = concurrency
+	options.MMapAtStartup = mmapAtStartup
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		//删除hint文件，测试的是完整解码数据文件的时间
+		b.StopTimer()
+		hintFiles, _ := filepath.Glob(filepath.Join(dir, "*"+data.HintFileNameSuffix))
+		for _, hintFile := range hintFiles {
+			_ = os.Remove(hintFile)
+		}
+		b.StartTimer()
+
+		db, err := bitcask.OpenDB(options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		if err := db.Close(); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+func Benchmark_OpenDB_FileIO_Sequential(b *testing.B) {
+	benchmarkOpenDB(b, 1, false)
+}
+
+func Benchmark_OpenDB_FileIO_Parallel(b *testing.B) {
+	benchmarkOpenDB(b, 8, false)
+}
+
+func Benchmark_OpenDB_MMap_Sequential(b *testing.B) {
+	benchmarkOpenDB(b, 1, true)
+}
+
+func Benchmark_OpenDB_MMap_Parallel(b *testing.B) {
+	benchmarkOpenDB(b, 8, true)
+}
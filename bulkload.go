@@ -0,0 +1,337 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	bulkLoadDirName = "_bulkload" //批量导入生成的文件所在的临时目录
+)
+
+// 批量导入
+// 通过Put逐条写入大量数据很慢：每一次写入都要加锁、编码、更新内存索引
+// BulkLoader把导入的数据写到单独的临时目录中，使用大的缓冲区顺序写入数据文件，每个文件写满之后直接生成hint文件，整个过程不会修改内存索引
+// Commit的时候把生成的文件一次性挂到数据库上：文件id排在当前所有的数据文件之后，然后按照文件的顺序从hint文件中一次重建这部分索引
+// 同一个key以最后写入的为准：导入的数据覆盖数据库中已有的值，Commit之后的写入覆盖导入的数据，多个导入之间以Commit的先后为准
+//
+// 挂载的时候先把文件移动到数据目录中，再把它们记录到MANIFEST中，写入MANIFEST就是提交点
+// 在这之前崩溃的话，不在MANIFEST中的文件启动时会被删除(见manifest.go)，导入的数据全部丢失，不会只生效一部分
+//
+// 限制：
+// 导入不检查MaxKeys配额，MaxDiskSize配额在Commit时按照导入的数据量整体检查
+// 导入的记录没有指向数据库中已有版本的指针，History只能读到导入之后的版本
+//
+// 导入的记录在文件中带有写入BulkLoader时的时间，但是Commit之前它们并不可见
+// 挂载时所有的文件使用同一个提交时间，记录在MANIFEST中，GetAt、History读到这些文件中的记录时以这个时间为准
+// merge重写这些记录时直接写入挂载的时间，之后就不再需要单独记录
+
+// BulkLoader 批量导入
+type BulkLoader struct {
+	mu            *sync.Mutex
+	db            *DB
+	options       BulkLoaderOptions
+	dir           string             //导入生成的文件所在的临时目录
+	fileSize      int64              //单个数据文件的大小
+	dataFile      *data.DataFile     //正在写入的数据文件
+	buf           []byte             //还没有写入数据文件的记录
+	hints         []*data.HintRecord //正在写入的数据文件中的记录
+	fileNum       uint32             //已经写完的数据文件数量，临时目录中的文件id从0开始依次递增
+	lastTimestamp int64              //最近一条记录的时间
+	done          bool               //已经提交或者放弃
+}
+
+// NewBulkLoader 新建一个批量导入   导入的数据在Commit之前都读不到
+func (db *DB) NewBulkLoader(opts BulkLoaderOptions) (*BulkLoader, error) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBulkLoaderOptions.BufferSize
+	}
+	fileSize := opts.DataFileSize
+	if fileSize <= 0 {
+		fileSize = db.options.DataFileSize
+	}
+	//每个导入使用单独的临时目录，多个导入可以同时进行
+	seq := atomic.AddUint64(&db.bulkLoadSeq, 1)
+	dir := filepath.Join(db.getBulkLoadPath(), strconv.FormatUint(seq, 10))
+	if err := db.fs.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := db.fs.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &BulkLoader{
+		mu:       new(sync.Mutex),
+		db:       db,
+		options:  opts,
+		dir:      dir,
+		fileSize: fileSize,
+		buf:      make([]byte, 0, opts.BufferSize),
+	}, nil
+}
+
+// Put 写入一条数据   同一个key写入多次时以最后一次为准
+func (l *BulkLoader) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyisEmpty
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return ErrBulkLoaderClosed
+	}
+
+	if l.dataFile == nil {
+		if err := l.openDataFile(); err != nil {
+			return err
+		}
+	}
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Timestamp: l.timestamp(),
+	}
+	//所有的数据文件使用相同的格式和校验算法，换文件之后不需要重新编码
+	encRecord, size := data.EncodeLogRecordWithHeader(logRecord, &l.dataFile.Header)
+	offset := l.dataFile.WriteOff + int64(len(l.buf))
+	//当前的文件写满了，换一个新的文件   每个文件中至少有一条记录
+	if offset+size > l.fileSize && len(l.hints) > 0 {
+		if err := l.finishDataFile(); err != nil {
+			return err
+		}
+		if err := l.openDataFile(); err != nil {
+			return err
+		}
+		offset = l.dataFile.WriteOff
+	}
+
+	l.buf = append(l.buf, encRecord...)
+	l.hints = append(l.hints, &data.HintRecord{
+		Key:  logRecord.Key,
+		Type: logRecord.Type,
+		Pos:  &data.LogRecordPos{Fid: l.dataFile.FileId, Offset: offset, Size: uint32(size)},
+	})
+	if len(l.buf) >= l.options.BufferSize {
+		return l.flush()
+	}
+	return nil
+}
+
+// Commit 把导入的数据挂到数据库上，返回之后就可以读到导入的数据了   无论成功与否，之后BulkLoader都不能再使用
+func (l *BulkLoader) Commit() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return ErrBulkLoaderClosed
+	}
+	l.done = true
+	defer func() {
+		_ = l.db.fs.RemoveAll(l.dir)
+	}()
+
+	if l.dataFile != nil {
+		if err := l.finishDataFile(); err != nil {
+			_ = l.dataFile.Close()
+			return err
+		}
+	}
+	if l.fileNum == 0 {
+		return nil
+	}
+	return l.db.attachBulkFiles(l.dir, l.fileNum)
+}
+
+// Abort 放弃导入，删除已经生成的文件
+func (l *BulkLoader) Abort() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return ErrBulkLoaderClosed
+	}
+	l.done = true
+	if l.dataFile != nil {
+		_ = l.dataFile.Close()
+	}
+	return l.db.fs.RemoveAll(l.dir)
+}
+
+// 导入的记录的时间   同一个导入中保证递增
+func (l *BulkLoader) timestamp() int64 {
+	now := time.Now().UnixNano()
+	if now <= l.lastTimestamp {
+		now = l.lastTimestamp + 1
+	}
+	l.lastTimestamp = now
+	return now
+}
+
+// 在临时目录中新建下一个数据文件
+func (l *BulkLoader) openDataFile() error {
+	dataFile, err := data.OpenDataFile(l.db.fs, l.dir, l.fileNum, fio.StanderdFIO)
+	if err != nil {
+		return err
+	}
+	if err := dataFile.WriteHeader(l.db.newDataFileHeader()); err != nil {
+		_ = dataFile.Close()
+		return err
+	}
+	l.dataFile = dataFile
+	return nil
+}
+
+// 把缓冲区中的记录写入数据文件
+func (l *BulkLoader) flush() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	if err := l.dataFile.Write(l.buf); err != nil {
+		return err
+	}
+	l.buf = l.buf[:0]
+	return nil
+}
+
+// 当前的数据文件写完了，持久化之后生成对应的hint文件
+func (l *BulkLoader) finishDataFile() error {
+	if err := l.flush(); err != nil {
+		return err
+	}
+	if err := l.dataFile.Sync(); err != nil {
+		return err
+	}
+	if err := data.WriteHintFile(l.db.fs, l.dir, l.dataFile.FileId, l.dataFile.WriteOff, l.hints); err != nil {
+		return err
+	}
+	if err := l.dataFile.Close(); err != nil {
+		return err
+	}
+	l.dataFile = nil
+	l.hints = nil
+	l.fileNum++
+	return nil
+}
+
+// 批量导入的临时目录   和merge目录一样放在数据目录的父目录下
+func (db *DB) getBulkLoadPath() string {
+	return filepath.Clean(db.options.DirPath) + bulkLoadDirName
+}
+
+// 把临时目录dir中的fileNum个数据文件挂到数据库上，并从它们的hint文件中重建这部分索引
+func (db *DB) attachBulkFiles(dir string, fileNum uint32) error {
+	//冷数据文件的移动和merge都会改变数据文件，挂载期间不能进行
+	db.tierLock.Lock()
+	defer db.tierLock.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.isMerging {
+		return ErrMergeIsProcess
+	}
+
+	var totalSize int64
+	for i := uint32(0); i < fileNum; i++ {
+		stat, err := db.fs.Stat(data.GetDataFileName(dir, i))
+		if err != nil {
+			return err
+		}
+		totalSize += stat.Size()
+	}
+	if db.options.MaxDiskSize > 0 && db.dataSize+totalSize > db.options.MaxDiskSize {
+		return ErrMaxDiskSizeExceeded
+	}
+
+	//导入的文件排在当前所有的数据文件之后，重启时回放的顺序也就是覆盖的顺序
+	baseFileId := db.nextFileId()
+	var bulkFiles []*data.DataFile
+	var destDirs []string
+	//提交之前失败的话，删除已经移动到数据目录中的文件
+	rollback := func() {
+		for _, dataFile := range bulkFiles {
+			_ = dataFile.Close()
+			delete(db.olderFile, dataFile.FileId)
+			delete(db.fileDirs, dataFile.FileId)
+			delete(db.attachTimes, dataFile.FileId)
+		}
+		for i, destDir := range destDirs {
+			fileId := baseFileId + uint32(i)
+			_ = db.fs.Remove(data.GetDataFileName(destDir, fileId))
+			_ = data.RemoveHintFile(db.fs, db.options.DirPath, fileId)
+		}
+	}
+	for i := uint32(0); i < fileNum; i++ {
+		fileId := baseFileId + i
+		destDir := db.pickDataDir(fileId)
+		destDirs = append(destDirs, destDir)
+		//先移动hint文件，再移动数据文件
+		if err := db.moveMergeFile(data.GetHintFileName(dir, i), data.GetHintFileName(db.options.DirPath, fileId)); err != nil {
+			rollback()
+			return err
+		}
+		if err := db.moveMergeFile(data.GetDataFileName(dir, i), data.GetDataFileName(destDir, fileId)); err != nil {
+			rollback()
+			return err
+		}
+		dataFile, err := data.OpenDataFile(db.fs, destDir, fileId, fileIOType(db.options.OlderIOType))
+		if err != nil {
+			rollback()
+			return err
+		}
+		db.olderFile[fileId] = dataFile
+		db.fileDirs[fileId] = destDir
+		bulkFiles = append(bulkFiles, dataFile)
+	}
+	//移动到数据目录中的文件持久化之后才能记录到MANIFEST中
+	if err := db.syncDirs(append([]string{dir, db.options.DirPath}, destDirs...)...); err != nil {
+		rollback()
+		return err
+	}
+	//导入的记录都使用挂载时的提交时间，在这之前的时刻读不到
+	commitTs := db.commitTimestamp()
+	for _, dataFile := range bulkFiles {
+		db.attachTimes[dataFile.FileId] = commitTs
+	}
+
+	//当前的活跃文件转换为旧文件，在导入的文件之后再打开一个新的活跃文件，之后的写入覆盖导入的数据
+	//打开新的活跃文件时会把导入的文件一起记录到MANIFEST中，这一步就是提交点
+	var oldFile *data.DataFile
+	if db.activeFile != nil {
+		var err error
+		if oldFile, err = db.retireActiveFile(); err != nil {
+			delete(db.olderFile, db.activeFile.FileId)
+			rollback()
+			return err
+		}
+	}
+	if err := db.setActiveDataFile(); err != nil {
+		if oldFile != nil {
+			delete(db.olderFile, oldFile.FileId)
+		}
+		rollback()
+		return err
+	}
+	db.dataSize += totalSize
+
+	//按照文件的顺序一次重建导入的这部分索引，和启动时加载索引使用同样的方式
+	err := db.decodeDataFiles(bulkFiles, nil, func(dataFile *data.DataFile, result *loadResult) error {
+		for _, record := range result.records {
+			realKey, _ := parseLogRecordKey(record.Key)
+			db.updateIndex(realKey, record.Type, record.Pos)
+		}
+		if !result.fromHint {
+			db.writeHintFileAsync(dataFile, result.records, result.fileSize)
+		}
+		return nil
+	})
+
+	_ = db.writeFileStats()
+	if oldFile != nil {
+		db.writeHintFileAsync(oldFile, nil, 0)
+	}
+	db.moveColdFilesAsync()
+	return err
+}
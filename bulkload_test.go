@@ -0,0 +1,285 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBulkLoader_EmptyDB(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-bulkload")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	loader, err := db.NewBulkLoader(BulkLoaderOptions{BufferSize: 4 * 1024})
+	assert.Nil(t, err)
+	expected := make(map[int][]byte)
+	for i := 0; i < 5000; i++ {
+		value := utils.RandomValue(64)
+		assert.Nil(t, loader.Put(utils.GetTestKey(i), value))
+		expected[i] = value
+	}
+	//同一个key以最后写入的为准
+	for i := 0; i < 5000; i += 7 {
+		value := utils.RandomValue(64)
+		assert.Nil(t, loader.Put(utils.GetTestKey(i), value))
+		expected[i] = value
+	}
+	assert.Equal(t, ErrKeyisEmpty, loader.Put(nil, []byte("value")))
+
+	//提交之前读不到导入的数据
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, loader.Commit())
+	assert.Equal(t, ErrBulkLoaderClosed, loader.Put(utils.GetTestKey(1), []byte("value")))
+	assert.Equal(t, ErrBulkLoaderClosed, loader.Commit())
+
+	check := func(db *DB) {
+		assert.Equal(t, len(expected), db.index.Size())
+		for i, value := range expected {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check(db)
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Greater(t, len(stats), 5)
+
+	//提交之后的写入覆盖导入的数据
+	assert.Nil(t, db.Put(utils.GetTestKey(3), []byte("new-value")))
+	expected[3] = []byte("new-value")
+	check(db)
+	_, err = os.Stat(db.getBulkLoadPath() + "/1")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	check(db2)
+	assert.Nil(t, db2.Close())
+}
+
+// 导入的数据覆盖数据库中已有的值，多个导入之间以提交的先后为准
+func TestBulkLoader_ExistingDB(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-bulkload")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("db")))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	first, err := db.NewBulkLoader(DefaultBulkLoaderOptions)
+	assert.Nil(t, err)
+	second, err := db.NewBulkLoader(DefaultBulkLoaderOptions)
+	assert.Nil(t, err)
+	for i := 1000; i < 3000; i++ {
+		assert.Nil(t, first.Put(utils.GetTestKey(i), []byte("first")))
+	}
+	for i := 2000; i < 4000; i++ {
+		assert.Nil(t, second.Put(utils.GetTestKey(i), []byte("second")))
+	}
+	//导入期间数据库仍然可以正常写入
+	assert.Nil(t, db.Put(utils.GetTestKey(1500), []byte("db-during-load")))
+	assert.Nil(t, second.Commit())
+	assert.Nil(t, first.Commit())
+
+	check := func(db *DB) {
+		assert.Equal(t, 3999, db.index.Size())
+		_, err := db.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		for i := 1; i < 4000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			switch {
+			case i < 1000:
+				assert.Equal(t, []byte("db"), val)
+			case i < 3000:
+				assert.Equal(t, []byte("first"), val)
+			default:
+				assert.Equal(t, []byte("second"), val)
+			}
+		}
+	}
+	check(db)
+
+	//导入之后merge以及重启
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	check(db2)
+	db2.options.DataFileMergeRatio = 0
+	assert.Nil(t, db2.Merge())
+	check(db2)
+	assert.Nil(t, db2.Close())
+	db3, err := OpenDB(opts)
+	assert.Nil(t, err)
+	check(db3)
+	assert.Nil(t, db3.Close())
+}
+
+func TestBulkLoader_Abort(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-bulkload")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	loader, err := db.NewBulkLoader(DefaultBulkLoaderOptions)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, loader.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, loader.Abort())
+	assert.Equal(t, ErrBulkLoaderClosed, loader.Commit())
+	assert.Equal(t, 0, db.index.Size())
+	_, err = os.Stat(loader.dir)
+	assert.True(t, os.IsNotExist(err))
+
+	//没有提交的导入留下的临时文件在重新打开时删除
+	loader, err = db.NewBulkLoader(DefaultBulkLoaderOptions)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, loader.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(db2.getBulkLoadPath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 0, db2.index.Size())
+	assert.Nil(t, db2.Close())
+}
+
+// 导入的数据从Commit的时刻开始才可见，重启以及merge之后也一样
+func TestBulkLoader_GetAt(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-bulkload")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.VersionRetention = time.Hour
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("old")))
+	assert.Nil(t, db.Put([]byte("other"), []byte("old")))
+	loader, err := db.NewBulkLoader(DefaultBulkLoaderOptions)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, loader.Put(utils.GetTestKey(i), []byte("imported")))
+	}
+	beforeCommit := time.Now()
+	assert.Nil(t, loader.Commit())
+	afterCommit := time.Now()
+
+	check := func(db *DB) {
+		//导入的记录没有指向已有版本的指针，读不到导入之前的版本，但是也不会读到导入的数据
+		for _, i := range []int{0, 1, 1999} {
+			_, err := db.GetAt(utils.GetTestKey(i), beforeCommit)
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		val, err := db.GetAt([]byte("other"), beforeCommit)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+		for _, i := range []int{0, 1, 1999} {
+			val, err := db.GetAt(utils.GetTestKey(i), afterCommit)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("imported"), val)
+			versions, err := db.History(utils.GetTestKey(i), 1)
+			assert.Nil(t, err)
+			assert.True(t, versions[0].Timestamp.After(beforeCommit))
+			assert.False(t, versions[0].Timestamp.After(afterCommit))
+		}
+	}
+	check(db)
+
+	//挂载时间记录在MANIFEST中
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.True(t, len(db2.attachTimes) > 0)
+	check(db2)
+
+	//merge之后记录中写入的就是挂载的时间
+	assert.Nil(t, db2.Merge())
+	assert.Equal(t, 0, len(db2.attachTimes))
+	check(db2)
+	assert.Nil(t, db2.Close())
+	db3, err := OpenDB(opts)
+	assert.Nil(t, err)
+	check(db3)
+	assert.Nil(t, db3.Close())
+}
+
+// 挂载导入的文件时任意一次重命名失败然后崩溃，导入的数据要么全部可见，要么全部不可见
+func TestCrash_BulkLoad(t *testing.T) {
+	for n := 1; n <= 30; n++ {
+		t.Run(fmt.Sprintf("fail-rename-%d", n), func(t *testing.T) {
+			fs := vfs.NewFaultFS(vfs.NewMemFS())
+			opts := crashTestOptions(fs)
+			if n%2 == 0 {
+				opts.DataDirs = []string{"bitcask-go-crash-1"}
+			}
+			db, err := OpenDB(opts)
+			assert.Nil(t, err)
+			for i := 0; i < 200; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i, 0)))
+			}
+			assert.Nil(t, db.Sync())
+
+			loader, err := db.NewBulkLoader(DefaultBulkLoaderOptions)
+			assert.Nil(t, err)
+			for i := 100; i < 300; i++ {
+				assert.Nil(t, loader.Put(utils.GetTestKey(i), crashTestValue(i, 1)))
+			}
+			fs.FailRename(n)
+			committed := loader.Commit()
+			if committed != nil {
+				assert.True(t, errors.Is(committed, vfs.ErrInjected), "%v", committed)
+			}
+			assert.Nil(t, fs.Crash(0))
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			defer db.Close()
+			_, err = db.Get(utils.GetTestKey(250))
+			loaded := err == nil
+			if committed == nil {
+				assert.True(t, loaded)
+			}
+			for i := 0; i < 300; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				switch {
+				case i >= 100 && loaded:
+					assert.Nil(t, err)
+					assert.Equal(t, crashTestValue(i, 1), val)
+				case i < 200:
+					assert.Nil(t, err)
+					assert.Equal(t, crashTestValue(i, 0), val)
+				default:
+					assert.Equal(t, ErrKeyNotFound, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/vfs"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// 索引检查点
+// 在没有merge的情况下，启动时需要把所有数据文件重新读一遍才能构建出内存索引，数据量大的时候非常慢
+// 检查点会把当前内存索引中的所有key以及位置信息保存到磁盘上，并记录下检查点覆盖到的文件id和偏移
+// 启动时先加载检查点，然后只需要从记录的位置开始回放剩余的数据即可
+
+const checkpointMetaKey = "checkpoint.meta"
+
+var ErrInvalidCheckpoint = errors.New("the index checkpoint is invalid")
+
+// 检查点的元数据，保存在检查点文件的第一条记录中
+type checkpointMeta struct {
+	fid    uint32 //检查点覆盖到的数据文件id
+	offset int64  //检查点覆盖到的该文件中的偏移，在此之前的数据都已经反映在检查点中
+	seqNo  uint64 //当时的事务序列号
+	count  int64  //检查点中索引条目以及墓碑值的数量，用于判断文件是否完整
+}
+
+// 在内存中拍下的索引快照
+type checkpoint struct {
+	meta       checkpointMeta
+	keys       [][]byte
+	entries    []*data.LogRecordPos
+	tombstones map[string]*data.LogRecordPos //被删除的key最新的墓碑值，见version.go
+	generation uint64                        //拍下快照时merge生效的次数
+}
+
+// 手动保存一次索引检查点
+func (db *DB) Checkpoint() error {
+	if db.options.IndexType == BPLusTree { //b+树的索引本身就在磁盘上，不需要检查点
+		return nil
+	}
+	db.mu.Lock()
+	cp, err := db.snapshotIndex()
+	db.mu.Unlock()
+	if err != nil || cp == nil {
+		return err
+	}
+	return db.writeCheckpoint(cp)
+}
+
+// 拍下当前内存索引的快照   调用时必须持有db.mu
+func (db *DB) snapshotIndex() (*checkpoint, error) {
+	if db.activeFile == nil { //数据库为空
+		return nil, nil
+	}
+	//检查点记录的位置必须已经持久化了，否则崩溃后检查点会指向不存在的数据
+	if err := db.activeFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	iterator := db.index.Iterator(false)
+	if iterator == nil { //不支持遍历的索引无法保存检查点
+		return nil, ErrIteratorUnsupported
+	}
+	defer iterator.Close()
+
+	cp := &checkpoint{
+		meta: checkpointMeta{
+			fid:    db.activeFile.FileId,
+			offset: db.activeFile.WriteOff,
+			seqNo:  db.seqNo,
+		},
+		keys:       make([][]byte, 0, db.index.Size()),
+		entries:    make([]*data.LogRecordPos, 0, db.index.Size()),
+		generation: db.mergeGeneration,
+	}
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		cp.keys = append(cp.keys, iterator.Key())
+		cp.entries = append(cp.entries, iterator.Value())
+	}
+	cp.tombstones = make(map[string]*data.LogRecordPos, len(db.tombstones))
+	for key, pos := range db.tombstones {
+		cp.tombstones[key] = pos
+	}
+	cp.meta.count = int64(len(cp.keys) + len(cp.tombstones))
+	return cp, nil
+}
+
+// 将快照写到检查点文件中   先写临时文件，sync之后再重命名，保证检查点文件要么是旧的，要么是完整的新文件
+func (db *DB) writeCheckpoint(cp *checkpoint) error {
+	db.checkpointLock.Lock()
+	defer db.checkpointLock.Unlock()
+
+	//拍下快照之后merge已经生效了，快照中的位置信息都已经失效
+	if atomic.LoadUint64(&db.mergeGeneration) != cp.generation {
+		return nil
+	}
+
+	fileName := filepath.Join(db.options.DirPath, data.CheckpointFileName)
+	tmpFileName := fileName + ".tmp"
+	_ = db.fs.Remove(tmpFileName)
+
+	cpFile, err := data.OpenCheckpointFile(db.fs, tmpFileName)
+	if err != nil {
+		return err
+	}
+	writeErr := func() error {
+		metaRecord := &data.LogRecord{
+			Key:   []byte(checkpointMetaKey),
+			Value: encodeCheckpointMeta(&cp.meta),
+		}
+		encRecord, _ := data.EncodeLogRecord(metaRecord)
+		if err := cpFile.Write(encRecord); err != nil {
+			return err
+		}
+		//每一条索引都和hint文件一样编码成LogRecord，自带crc校验值
+		for i, key := range cp.keys {
+			if err := cpFile.WriteHintRecord(key, cp.entries[i]); err != nil {
+				return err
+			}
+		}
+		//墓碑值写成删除类型的记录
+		for key, pos := range cp.tombstones {
+			encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+				Key:   []byte(key),
+				Value: data.EncodeLogRecordPos(pos),
+				Type:  data.LogRecordDeleted,
+			})
+			if err := cpFile.Write(encRecord); err != nil {
+				return err
+			}
+		}
+		return cpFile.Sync()
+	}()
+	if err := cpFile.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = db.fs.Remove(tmpFileName)
+		return writeErr
+	}
+	return db.fs.Rename(tmpFileName, fileName)
+}
+
+// 从检查点文件中加载索引   返回检查点覆盖到的位置，检查点不存在时返回nil
+// 检查点无效(crc校验失败、文件不完整、指向的数据文件不存在等)时返回ErrInvalidCheckpoint，由调用方回退到全量回放
+func (db *DB) loadIndexFromCheckpoint() (*checkpointMeta, error) {
+	fileName := filepath.Join(db.options.DirPath, data.CheckpointFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	cpFile, err := data.OpenCheckpointFile(db.fs, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer cpFile.Close()
+
+	metaRecord, size, err := cpFile.ReadLogRecord(0)
+	if err != nil || string(metaRecord.Key) != checkpointMetaKey {
+		return nil, ErrInvalidCheckpoint
+	}
+	meta, err := decodeCheckpointMeta(metaRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	//检查点指向的数据文件必须存在，并且其中的数据没有丢失
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == meta.fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFile[meta.fid]
+	}
+	if dataFile == nil {
+		return nil, ErrInvalidCheckpoint
+	}
+	if fileSize, err := dataFile.IoManager.Size(); err != nil || fileSize < meta.offset {
+		return nil, ErrInvalidCheckpoint
+	}
+
+	var offset = size
+	var count int64
+	for {
+		logRecord, size, err := cpFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, ErrInvalidCheckpoint
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if logRecord.Type == data.LogRecordDeleted {
+			if db.options.VersionRetention > 0 { //没有配置VersionRetention时不记录墓碑值
+				db.tombstones[string(logRecord.Key)] = pos
+			}
+		} else {
+			db.index.Put(logRecord.Key, pos)
+			db.liveSize[pos.Fid] += int64(pos.Size)
+		}
+		count++
+		offset += size
+	}
+	if count != meta.count {
+		return nil, ErrInvalidCheckpoint
+	}
+	db.seqNo = meta.seqNo
+	return meta, nil
+}
+
+// 检查点无效的时候，丢弃已经加载了一部分的索引，重新初始化一个空的索引
+func (db *DB) resetIndex() {
+	_ = db.index.Close()
+	db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites)
+	db.seqNo = nonTransactionSeqNo
+	db.liveSize = make(map[uint32]int64)
+	db.tombstones = make(map[string]*data.LogRecordPos)
+}
+
+// 删除检查点文件   merge生效之后，检查点中的位置信息就都失效了
+func removeCheckpoint(fs vfs.FS, dirPath string) error {
+	fileName := filepath.Join(dirPath, data.CheckpointFileName)
+	if err := fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 后台定期保存检查点，直到数据库关闭
+func (db *DB) startCheckpointLoop() {
+	db.checkpointStop = make(chan struct{})
+	db.checkpointDone = make(chan struct{})
+	go func() {
+		defer close(db.checkpointDone)
+		ticker := time.NewTicker(db.options.CheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = db.Checkpoint() //后台保存失败不影响正常读写，下一次再试，关闭时还会再保存一次
+			case <-db.checkpointStop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止后台的检查点任务
+func (db *DB) stopCheckpointLoop() {
+	if db.checkpointStop == nil {
+		return
+	}
+	close(db.checkpointStop)
+	<-db.checkpointDone
+	db.checkpointStop = nil
+}
+
+// 对检查点元数据进行编码
+func encodeCheckpointMeta(meta *checkpointMeta) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(meta.fid))
+	index += binary.PutVarint(buf[index:], meta.offset)
+	index += binary.PutUvarint(buf[index:], meta.seqNo)
+	index += binary.PutVarint(buf[index:], meta.count)
+	return buf[:index]
+}
+
+// 对检查点元数据进行解码
+func decodeCheckpointMeta(buf []byte) (*checkpointMeta, error) {
+	var index = 0
+	readVarint := func() int64 {
+		if index >= len(buf) {
+			index = -1
+			return 0
+		}
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			index = -1
+			return 0
+		}
+		index += n
+		return v
+	}
+	meta := &checkpointMeta{}
+	meta.fid = uint32(readVarint())
+	meta.offset = readVarint()
+	if index < 0 || index >= len(buf) {
+		return nil, ErrInvalidCheckpoint
+	}
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, ErrInvalidCheckpoint
+	}
+	index += n
+	meta.seqNo = seqNo
+	meta.count = readVarint()
+	if index < 0 {
+		return nil, ErrInvalidCheckpoint
+	}
+	return meta, nil
+}
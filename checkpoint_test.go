@@ -0,0 +1,141 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Checkpoint_OnClose(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-close")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexCheckpoint = true
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.CheckpointFileName))
+	assert.Nil(t, err)
+
+	//重启之后从检查点中加载索引
+	db2, err := OpenDB(opts)
+	defer Destroy_DB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, db2.index.Size())
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+// 不支持遍历的索引不能开启检查点
+func TestDB_Checkpoint_UnorderedHash(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-hash")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.IndexType = UnorderedHash
+	opts.IndexCheckpoint = true
+	_, err := OpenDB(opts)
+	assert.NotNil(t, err)
+
+	opts.IndexCheckpoint = false
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_Checkpoint_ReplayTail(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-tail")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexCheckpoint = true
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Checkpoint()
+	assert.Nil(t, err)
+
+	//检查点之后的数据，包括事务
+	for i := 500; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(2), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+
+	//模拟关闭时没有写检查点，重启时需要回放检查点之后的数据
+	db.options.IndexCheckpoint = false
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := OpenDB(opts)
+	defer Destroy_DB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, db2.index.Size())
+	assert.Equal(t, seqNo, db2.seqNo)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), val)
+	val, err = db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_Checkpoint_Corrupted(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-corrupted")
+	opts.DirPath = dir
+	opts.IndexCheckpoint = true
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	//破坏检查点文件中间的内容，重启时回退到全量回放
+	cpFileName := filepath.Join(dir, data.CheckpointFileName)
+	buf, err := os.ReadFile(cpFileName)
+	assert.Nil(t, err)
+	buf[len(buf)/2] ^= 0xff
+	err = os.WriteFile(cpFileName, buf, 0644)
+	assert.Nil(t, err)
+
+	db2, err := OpenDB(opts)
+	defer Destroy_DB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, db2.index.Size())
+	val, err := db2.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
@@ -0,0 +1,112 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Checksum(t *testing.T) {
+	for _, typ := range []ChecksumType{ChecksumCRC32C, ChecksumIEEE, ChecksumXXHash64} {
+		opts := DefaultOptioins
+		dir, _ := os.MkdirTemp("", "bitcask-go-checksum")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.Checksum = typ
+		db, err := OpenDB(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		}
+		assert.True(t, len(db.olderFile) > 0)
+		checkFileChecksum(t, db, typ)
+		assert.Nil(t, db.Close())
+
+		db, err = OpenDB(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 1000, db.index.Size())
+		for i := 0; i < 1000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+
+		//数据被破坏之后能够检测出来
+		pos := db.index.Get(utils.GetTestKey(0))
+		assert.Nil(t, db.Close())
+		fileName := data.GetDataFileName(dir, pos.Fid)
+		buf, err := os.ReadFile(fileName)
+		assert.Nil(t, err)
+		buf[pos.Offset+int64(pos.Size)-1] ^= 0xff
+		assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+		assert.Nil(t, os.Remove(data.GetHintFileName(dir, pos.Fid))) //没有hint文件时启动会读取整个数据文件
+		_, err = OpenDB(opts)
+		assert.Equal(t, data.ErrInvalidCRC, err)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func checkFileChecksum(t *testing.T, db *DB, typ ChecksumType) {
+	assert.Equal(t, typ, db.activeFile.Header.Checksum)
+	for _, dataFile := range db.olderFile {
+		assert.Equal(t, typ, dataFile.Header.Checksum, "file %d", dataFile.FileId)
+	}
+}
+
+func TestDB_Checksum_Change(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-checksum")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.Checksum = ChecksumIEEE
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	values := make(map[int][]byte)
+	put := func(db *DB, start, end int) {
+		for i := start; i < end; i++ {
+			values[i] = utils.RandomValue(64)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+	}
+	check := func(db *DB) {
+		assert.Equal(t, len(values), db.index.Size())
+		for i, value := range values {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	put(db, 0, 1000)
+	assert.Nil(t, db.Close())
+
+	//修改校验算法之后，已有的文件仍然使用原来的算法，新建的文件使用新的算法
+	opts.Checksum = ChecksumXXHash64
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	activeFileId := db.activeFile.FileId
+	put(db, 500, 2000)
+	for fid, dataFile := range db.olderFile {
+		if fid <= activeFileId {
+			assert.Equal(t, ChecksumIEEE, dataFile.Header.Checksum)
+		} else {
+			assert.Equal(t, ChecksumXXHash64, dataFile.Header.Checksum)
+		}
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	//升级之后全部使用新的算法
+	assert.Nil(t, db.Upgrade())
+	checkFileChecksum(t, db, ChecksumXXHash64)
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkFileChecksum(t, db, ChecksumXXHash64)
+	check(db)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// bitcask数据目录的管理工具
+//
+//	bitcask upgrade [flags] <dir>          把数据目录中的文件重写为最新的格式，数据目录的布局需要和打开数据库时的配置一致
+//	bitcask export [flags] <dir> <file>    把数据库中的数据导出到文件中，file为-时写到标准输出
+//	bitcask import [flags] <dir> <file>    把导出的文件导入到数据库中，file为-时从标准输入读取
+const usage = `usage: bitcask <command> [arguments]
+
+commands:
+  upgrade [flags] <dir>          rewrite all data files in dir to the newest format
+  export [flags] <dir> <file>    dump the database in dir to file ("-" for stdout)
+  import [flags] <dir> <file>    load a dump from file ("-" for stdin) into the database in dir
+
+export and import flags:
+  -format jsonl|csv|binary       dump format (default jsonl)
+
+import flags:
+  -batch n                       records per atomic commit (default 1000)
+  -resume                        continue an interrupted import of the same file
+
+upgrade flags (must match the options the database is opened with):
+  -data-file-size n              size of the rewritten data files in bytes (default 256MB)
+  -data-dirs dir1,dir2           extra data directories
+  -cold-dir dir                  cold data directory
+  -merge-dir dir                 directory for the temporary merge files
+`
+
+// 命令行中的格式名称
+var exportFormats = map[string]bitcask.ExportFormat{
+	"jsonl":  bitcask.FormatJSONLines,
+	"csv":    bitcask.FormatCSV,
+	"binary": bitcask.FormatBinary,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "upgrade":
+		err = upgrade(os.Args[2:])
+	case "export":
+		err = export(os.Args[2:])
+	case "import":
+		err = importDump(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func upgrade(args []string) error {
+	flags := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	dataFileSize := flags.Int64("data-file-size", bitcask.DefaultOptioins.DataFileSize, "size of the rewritten data files in bytes")
+	dataDirs := flags.String("data-dirs", "", "comma separated extra data directories")
+	coldDir := flags.String("cold-dir", "", "cold data directory")
+	mergeDir := flags.String("merge-dir", "", "directory for the temporary merge files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one data directory")
+	}
+	options := bitcask.DefaultOptioins
+	options.DirPath = flags.Arg(0)
+	options.DataFileSize = *dataFileSize
+	if *dataDirs != "" {
+		options.DataDirs = strings.Split(*dataDirs, ",")
+	}
+	options.ColdDir = *coldDir
+	options.MergeDir = *mergeDir
+	//只升级已经存在的数据目录，不新建
+	for _, dir := range append([]string{options.DirPath}, options.DataDirs...) {
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+	}
+	if options.ColdDir != "" {
+		if _, err := os.Stat(options.ColdDir); err != nil {
+			return err
+		}
+	}
+	//升级会重写所有能找到的数据文件，MANIFEST中记录的文件在配置的目录中找不到时(没有指定-data-dirs或者-cold-dir)拒绝升级
+	if err := bitcask.Upgrade(options); err != nil {
+		if errors.Is(err, bitcask.ErrDatatDirectoryCorrupted) {
+			return fmt.Errorf("%w: some data files are not in the configured directories, check -data-dirs and -cold-dir", err)
+		}
+		return err
+	}
+	fmt.Printf("upgraded %s to the newest format\n", options.DirPath)
+	return nil
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	formatName := flags.String("format", "jsonl", "dump format: jsonl, csv or binary")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, ok := exportFormats[*formatName]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *formatName)
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected a data directory and an output file")
+	}
+	options := bitcask.DefaultOptioins
+	options.DirPath = flags.Arg(0)
+	//只导出已经存在的数据目录，不新建
+	if _, err := os.Stat(options.DirPath); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if name := flags.Arg(1); name != "-" {
+		file, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	db, err := bitcask.OpenDB(options)
+	if err != nil {
+		return err
+	}
+	if err := db.Export(w, format); err != nil {
+		_ = db.Close()
+		return err
+	}
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		if err := file.Sync(); err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+	return db.Close()
+}
+
+func importDump(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	formatName := flags.String("format", "jsonl", "dump format: jsonl, csv or binary")
+	batchSize := flags.Uint("batch", bitcask.DefaultImportOptions.BatchSize, "records per atomic commit")
+	resume := flags.Bool("resume", false, "continue an interrupted import of the same file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, ok := exportFormats[*formatName]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *formatName)
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected a data directory and an input file")
+	}
+	options := bitcask.DefaultOptioins
+	options.DirPath = flags.Arg(0)
+
+	var r io.Reader = os.Stdin
+	if name := flags.Arg(1); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	db, err := bitcask.OpenDB(options)
+	if err != nil {
+		return err
+	}
+	opts := bitcask.DefaultImportOptions
+	opts.BatchSize = *batchSize
+	opts.Resume = *resume
+	if err := db.ImportWithOptions(r, format, opts); err != nil {
+		_ = db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("imported %s into %s\n", flags.Arg(1), options.DirPath)
+	return nil
+}
@@ -0,0 +1,332 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 选择性merge
+// Merge会把所有的旧数据文件重写一遍，即使其中只有少数文件的无效数据比较多
+// 这里按照MergeOptions只选择部分旧数据文件，每个文件单独压缩：只保留其中有效的记录，压缩之后的文件仍然使用原来的文件id和目录
+// 文件id不变，重启时回放数据文件的先后顺序也就不变，没有选中的文件保持不变
+// 被删除的key的墓碑值需要保留，否则没有参与merge的更早的文件中的旧数据会在重启之后重新生效；事务完成的标记也需要保留
+// 配置了压缩过滤器时同样对每一条有效记录调用，见compaction_filter.go
+// 配置了Option.VersionRetention时，保留时长之内的历史版本也会保留，见version.go
+
+// merge的过程可以通过ctx取消，取消时正在压缩的文件还没有替换原来的文件，merge目录中的内容直接丢弃；已经替换完成的文件保持压缩之后的状态
+
+// MergeWithOptions 按照opts选择部分旧数据文件进行merge
+func (db *DB) MergeWithOptions(ctx context.Context, opts MergeOptions) (err error) {
+	if db.activeFile == nil {
+		return nil
+	}
+	db.tierLock.Lock()
+	defer db.tierLock.Unlock()
+
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProcess
+	}
+	if atomic.LoadInt32(&db.exportPins) > 0 {
+		db.mu.Unlock()
+		return ErrExportIsProcess
+	}
+	mergeFiles, err := db.selectMergeFiles(opts)
+	if err != nil || len(mergeFiles) == 0 {
+		db.mu.Unlock()
+		return err
+	}
+	fileDirs := make(map[uint32]string)
+	for _, dataFile := range mergeFiles {
+		fileDirs[dataFile.FileId] = db.dataFileDir(dataFile.FileId)
+	}
+	db.isMerging = true
+	defer func() {
+		db.isMerging = false
+	}()
+	db.mu.Unlock()
+
+	run := db.startMergeRun(ctx, opts, len(mergeFiles))
+	defer func() {
+		run.finish(err)
+	}()
+
+	//压缩之后的文件先写到merge目录中，完成之后再替换原来的文件
+	mergePath := db.getMergePath()
+	if err := db.removeMergeDir(mergePath); err != nil {
+		return err
+	}
+	if err := db.fs.MkdirAll(mergePath, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.removeMergeDir(mergePath)
+	}()
+
+	for _, dataFile := range mergeFiles {
+		if err := db.compactDataFile(run, dataFile, fileDirs[dataFile.FileId], mergePath); err != nil {
+			return err
+		}
+		run.fileDone()
+	}
+	return nil
+}
+
+// 按照配置选择需要merge的旧数据文件，返回的文件按照id从小到大排序   在访问此方法前必须持有互斥锁
+func (db *DB) selectMergeFiles(opts MergeOptions) ([]*data.DataFile, error) {
+	var candidates []*data.DataFile
+	if len(opts.FileIds) > 0 {
+		for _, fid := range opts.FileIds {
+			dataFile, ok := db.olderFile[fid]
+			if !ok { //活跃文件也不能参与merge
+				return nil, ErrDataFileNotFound
+			}
+			candidates = append(candidates, dataFile)
+		}
+	} else {
+		for _, dataFile := range db.olderFile {
+			candidates = append(candidates, dataFile)
+		}
+	}
+
+	var stats []DataFileStat
+	for _, dataFile := range candidates {
+		//冷数据目录中压缩过的文件是只读的，不参与选择性merge
+		if db.compressedFiles[dataFile.FileId] {
+			continue
+		}
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		stat := db.dataFileStat(dataFile, size)
+		if stat.DeadSize <= 0 || stat.garbageRatio() < opts.GarbageRatio {
+			continue
+		}
+		stats = append(stats, stat)
+	}
+
+	//无效数据占比高的文件优先，重写的有效数据量不能超过MaxBytes
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].garbageRatio() != stats[j].garbageRatio() {
+			return stats[i].garbageRatio() > stats[j].garbageRatio()
+		}
+		return stats[i].FileId < stats[j].FileId
+	})
+	var mergeFiles []*data.DataFile
+	var totalBytes int64
+	for _, stat := range stats {
+		if opts.MaxBytes > 0 && totalBytes+stat.LiveSize > opts.MaxBytes {
+			continue
+		}
+		totalBytes += stat.LiveSize
+		mergeFiles = append(mergeFiles, db.olderFile[stat.FileId])
+	}
+	sort.Slice(mergeFiles, func(i, j int) bool {
+		return mergeFiles[i].FileId < mergeFiles[j].FileId
+	})
+	return mergeFiles, nil
+}
+
+// merge之后有效记录在新文件中的位置
+type compactedRecord struct {
+	key       []byte
+	typ       data.LogRecordType
+	oldOffset int64
+	pos       *data.LogRecordPos
+	moved     *movedVersion
+}
+
+// 压缩单个旧数据文件，只保留有效的记录，然后替换掉原来的文件
+func (db *DB) compactDataFile(run *mergeRun, dataFile *data.DataFile, dir, mergePath string) error {
+	fileId := dataFile.FileId
+	mergeFile, err := data.OpenDataFile(db.fs, mergePath, fileId, fio.StanderdFIO)
+	if err != nil {
+		return err
+	}
+	//压缩之后的文件使用新的格式   文件的序号改变，指向原来的文件的版本指针都会失效
+	if err := mergeFile.WriteHeader(db.newDataFileHeader()); err != nil {
+		_ = mergeFile.Close()
+		return err
+	}
+	//需要保留的历史版本
+	var retained map[versionPos]bool
+	if db.options.VersionRetention > 0 {
+		cutoff := time.Now().Add(-db.options.VersionRetention).UnixNano()
+		inFile := func(fid uint32) bool {
+			return fid == fileId
+		}
+		if retained, err = db.retainedVersions(run, []*data.DataFile{dataFile}, inFile, cutoff); err != nil {
+			_ = mergeFile.Close()
+			return err
+		}
+	}
+	var records []*compactedRecord
+	moved := make(map[versionPos]*movedVersion)
+	var filtered []*filteredRecord
+	var offset = dataFile.HeaderSize
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			_ = mergeFile.Close()
+			return err
+		}
+		if err := run.read(size); err != nil {
+			_ = mergeFile.Close()
+			return err
+		}
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		oldPos := versionPos{fid: fileId, offset: offset}
+		var keep bool
+		switch logRecord.Type {
+		case data.LogRecordNormal:
+			pos := db.index.Get(realKey)
+			keep = pos != nil && pos.Fid == fileId && pos.Offset == offset
+			if !keep && retained[oldPos] {
+				keep = true
+			} else if keep && !db.filterRecord(realKey, logRecord) {
+				//被压缩过滤器丢弃的key换成墓碑值
+				filtered = append(filtered, &filteredRecord{key: realKey, pos: pos})
+				logRecord.Type = data.LogRecordDeleted
+				logRecord.Value = nil
+			}
+		case data.LogRecordDeleted:
+			//key仍然是删除的状态，墓碑值需要保留
+			keep = db.index.Get(realKey) == nil || retained[oldPos]
+		case data.LogRecordTxnFinished:
+			//事务中的记录可能在更早的文件中，事务完成的标记需要原样保留
+			keep = true
+		}
+		if keep {
+			if logRecord.Type != data.LogRecordTxnFinished {
+				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				db.applyAttachTime(fileId, logRecord)
+				db.mu.RLock()
+				db.relinkVersion(logRecord, moved)
+				db.mu.RUnlock()
+			}
+			encRecord, n := data.EncodeLogRecordWithHeader(logRecord, &mergeFile.Header)
+			newOffset := mergeFile.WriteOff
+			if err := mergeFile.Write(encRecord); err != nil {
+				_ = mergeFile.Close()
+				return err
+			}
+			if err := run.write(n); err != nil {
+				_ = mergeFile.Close()
+				return err
+			}
+			if logRecord.Type != data.LogRecordTxnFinished {
+				pos := &data.LogRecordPos{Fid: fileId, Offset: newOffset, Size: uint32(n)}
+				record := &compactedRecord{
+					key:       realKey,
+					typ:       logRecord.Type,
+					oldOffset: offset,
+					pos:       pos,
+					moved:     &movedVersion{key: realKey, ptr: &data.VersionPtr{Pos: *pos, Serial: mergeFile.Header.Serial}},
+				}
+				moved[oldPos] = record.moved
+				records = append(records, record)
+			}
+		}
+		run.record(keep && logRecord.Type != data.LogRecordDeleted)
+		offset += size
+	}
+	newSize := mergeFile.WriteOff
+	if err := mergeFile.Sync(); err != nil {
+		_ = mergeFile.Close()
+		return err
+	}
+	if err := mergeFile.Close(); err != nil {
+		return err
+	}
+	//替换之前最后检查一次是否已经取消，取消之后原来的文件保持不变
+	if err := run.ctx.Err(); err != nil {
+		return err
+	}
+
+	//替换原来的文件，并把索引指向新文件中的位置
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	//后台生成hint文件的任务可能还在读取这个文件
+	db.hintWg.Wait()
+
+	//检查点和以前的merge生成的hint-index中的位置信息都会失效
+	db.checkpointLock.Lock()
+	err = removeCheckpoint(db.fs, db.options.DirPath)
+	if err == nil {
+		err = db.fs.Remove(filepath.Join(db.options.DirPath, data.HintFileName))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	atomic.AddUint64(&db.mergeGeneration, 1)
+	db.checkpointLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	oldSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	oldSerial := dataFile.Header.Serial
+	if err := dataFile.IoManager.Close(); err != nil {
+		return err
+	}
+	if err := data.RemoveHintFile(db.fs, db.options.DirPath, fileId); err != nil {
+		return err
+	}
+	fileName := data.GetDataFileName(dir, fileId)
+	if err := db.moveMergeFile(data.GetDataFileName(mergePath, fileId), fileName); err != nil {
+		//替换失败的时候原来的文件仍然可用，重新打开
+		if ioManager, openErr := fio.NewIOManager(db.fs, fileName, fileIOType(db.options.OlderIOType)); openErr == nil {
+			dataFile.IoManager = ioManager
+		}
+		return err
+	}
+	if dataFile.IoManager, err = fio.NewIOManager(db.fs, fileName, fileIOType(db.options.OlderIOType)); err != nil {
+		return err
+	}
+	dataFile.Header, dataFile.HeaderSize = mergeFile.Header, mergeFile.HeaderSize
+	//压缩之后的记录已经使用了批量导入挂载时的提交时间
+	delete(db.attachTimes, fileId)
+
+	//压缩期间被覆盖或者删除的key已经指向了更新的位置，不需要修改
+	db.removeFilteredKeys(filtered)
+	for _, record := range filtered {
+		//被丢弃的key最新的版本是替换成的墓碑值
+		mv, ok := moved[versionPos{fid: fileId, offset: record.pos.Offset}]
+		if ok && db.options.VersionRetention > 0 && db.index.Get(record.key) == nil && db.tombstones[string(record.key)] == nil {
+			newPos := mv.ptr.Pos
+			db.tombstones[string(record.key)] = &newPos
+		}
+	}
+	for _, record := range records {
+		if record.typ != data.LogRecordNormal {
+			continue
+		}
+		if pos := db.index.Get(record.key); pos != nil && pos.Fid == fileId && pos.Offset == record.oldOffset {
+			db.index.Put(record.key, record.pos)
+			db.liveSize[fileId] += int64(record.pos.Size) - int64(pos.Size)
+		}
+	}
+	db.applyCompactedVersions(fileId, oldSerial, moved)
+	db.dataSize += newSize - oldSize
+	//压缩之后文件的大小变了，更新保存的统计信息
+	_ = db.writeFileStats()
+
+	//为压缩之后的文件重新生成hint文件
+	db.writeHintFileAsync(dataFile, nil, 0)
+	return nil
+}
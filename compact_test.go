@@ -0,0 +1,411 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 根据内存索引重新统计每个数据文件中的有效数据量
+func scanLiveSize(db *DB) map[uint32]int64 {
+	liveSize := make(map[uint32]int64)
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		liveSize[iter.Value().Fid] += int64(iter.Value().Size)
+	}
+	return liveSize
+}
+
+func rotateActiveFile(db *DB) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.rotateActiveDataFile()
+}
+
+func checkDataFileStats(t *testing.T, db *DB) {
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	liveSize := scanLiveSize(db)
+	for _, stat := range stats {
+		assert.Equal(t, liveSize[stat.FileId], stat.LiveSize, "file %d", stat.FileId)
+		assert.Equal(t, stat.Size-data.FileHeaderSize-stat.LiveSize, stat.DeadSize)
+		assert.True(t, stat.DeadSize >= 0)
+	}
+}
+
+func TestDB_DataFileStats(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-stats")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 1000; i += 3 {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 1; i < 1000; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Delete(utils.GetTestKey(200)))
+	assert.Nil(t, wb.Commit())
+	checkDataFileStats(t, db)
+
+	//重新打开之后(旧数据文件从hint文件加载)统计信息不变
+	before, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	after, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, before, after)
+
+	//merge之后统计信息仍然和索引一致
+	opts.DataFileMergeRatio = 0
+	db.options.DataFileMergeRatio = 0
+	assert.Nil(t, db.Merge())
+	checkDataFileStats(t, db)
+}
+
+func TestDB_MergeWithOptions(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-options")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	//只有最前面的几个文件中的数据被大量覆盖
+	for i := 0; i < 400; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	//删除之后墓碑值所在的文件被单独merge，旧数据不能重新生效
+	for i := 1000; i < 1010; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(values, i)
+	}
+	db.hintWg.Wait()
+
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	sizes := make(map[uint32]int64)
+	for _, stat := range stats {
+		sizes[stat.FileId] = stat.Size
+	}
+
+	//活跃文件不能参与merge
+	assert.Equal(t, ErrDataFileNotFound, db.MergeWithOptions(context.Background(), MergeOptions{FileIds: []uint32{db.activeFile.FileId}}))
+
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{GarbageRatio: 0.8}))
+	after, err := db.DataFileStats()
+	assert.Nil(t, err)
+	var merged int
+	for _, stat := range after {
+		if stat.Size < sizes[stat.FileId] {
+			merged++
+			assert.Equal(t, stat.LiveSize+data.FileHeaderSize, stat.Size) //只剩下文件头和有效的数据
+		} else {
+			assert.Equal(t, sizes[stat.FileId], stat.Size) //没有选中的文件保持不变
+		}
+	}
+	assert.True(t, merged > 0)
+	assert.True(t, merged < len(after)-1)
+	checkDataFileStats(t, db)
+
+	//指定文件merge，墓碑值需要保留
+	tombstoneFile := db.activeFile.FileId
+	assert.Nil(t, rotateActiveFile(db))
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{FileIds: []uint32{tombstoneFile}}))
+	checkDataFileStats(t, db)
+
+	check := func(db *DB) {
+		assert.Equal(t, len(values), db.index.Size())
+		for i, value := range values {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+
+	//不使用hint文件重新加载，结果也一样
+	for _, stat := range after {
+		assert.Nil(t, data.RemoveHintFile(db.fs, dir, stat.FileId))
+	}
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	check(db)
+	checkDataFileStats(t, db)
+	//启动时会在后台为没有hint文件的数据文件生成hint文件，删除目录之前先关闭
+	assert.Nil(t, db.Close())
+}
+
+// 跨越多个数据文件的事务，只merge包含事务完成标记的文件
+func TestDB_MergeWithOptions_Transaction(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-options")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Commit())
+	lastFile := db.activeFile.FileId
+	assert.True(t, lastFile > 0)
+	//事务最后一个文件中的数据都被覆盖掉
+	for i := 0; i < 1000; i++ {
+		if pos := db.index.Get(utils.GetTestKey(i)); pos.Fid == lastFile {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+		}
+	}
+	assert.Nil(t, rotateActiveFile(db))
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{FileIds: []uint32{lastFile}}))
+	assert.Nil(t, db.Close())
+
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, db.index.Size())
+}
+
+// 准备一个前面几个文件中的数据大部分都被覆盖的数据库
+func prepareMergeControlDB(t *testing.T, opts Option) (*DB, map[int][]byte) {
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	assert.Nil(t, rotateActiveFile(db))
+	db.hintWg.Wait()
+	return db, values
+}
+
+func TestDB_MergeWithOptions_Progress(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-progress")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, _ := prepareMergeControlDB(t, opts)
+	defer Destroy_DB(db)
+
+	var reports []MergeProgress
+	mergeOpts := DefaultMergeOptions
+	mergeOpts.GarbageRatio = 0.3
+	mergeOpts.Progress = func(progress MergeProgress) {
+		reports = append(reports, progress)
+	}
+	assert.Nil(t, db.MergeWithOptions(context.Background(), mergeOpts))
+	assert.True(t, len(reports) > 0)
+
+	last := reports[len(reports)-1]
+	assert.True(t, last.TotalFiles > 0)
+	assert.Equal(t, last.TotalFiles, last.FilesDone)
+	assert.True(t, last.RecordsKept > 0)
+	assert.True(t, last.RecordsDropped > 0)
+	assert.True(t, last.BytesWritten > 0)
+	assert.True(t, last.BytesWritten < last.BytesRead)
+	for i := 1; i < len(reports); i++ {
+		assert.True(t, reports[i].BytesRead >= reports[i-1].BytesRead)
+		assert.True(t, reports[i].FilesDone >= reports[i-1].FilesDone)
+	}
+
+	stat := db.Stat()
+	assert.False(t, stat.Merge.Running)
+	assert.Nil(t, stat.Merge.Err)
+	assert.Equal(t, last, stat.Merge.MergeProgress)
+	assert.False(t, stat.Merge.EndTime.Before(stat.Merge.StartTime))
+
+	//全量merge同样记录进度
+	db.options.DataFileMergeRatio = 0
+	assert.Nil(t, db.Merge())
+	stat = db.Stat()
+	assert.Nil(t, stat.Merge.Err)
+	assert.True(t, stat.Merge.TotalFiles > 0)
+	assert.Equal(t, stat.Merge.TotalFiles, stat.Merge.FilesDone)
+	assert.Equal(t, int64(2000), stat.Merge.RecordsKept)
+}
+
+func TestDB_MergeWithOptions_Throttle(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-throttle")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, values := prepareMergeControlDB(t, opts)
+	defer Destroy_DB(db)
+
+	mergeOpts := DefaultMergeOptions
+	mergeOpts.GarbageRatio = 0.3
+	mergeOpts.BytesPerSecond = 512 * 1024
+	start := time.Now()
+	assert.Nil(t, db.MergeWithOptions(context.Background(), mergeOpts))
+	elapsed := time.Since(start)
+
+	stat := db.Stat().Merge
+	total := stat.BytesRead + stat.BytesWritten
+	expected := time.Duration(float64(total) / float64(mergeOpts.BytesPerSecond) * float64(time.Second))
+	assert.True(t, elapsed >= expected-mergeThrottleMinSleep, "elapsed %v, expected %v", elapsed, expected)
+
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_MergeWithOptions_Cancel(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-cancel")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, values := prepareMergeControlDB(t, opts)
+	defer Destroy_DB(db)
+
+	before, err := db.DataFileStats()
+	assert.Nil(t, err)
+
+	//已经取消的ctx，数据文件保持不变
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mergeOpts := DefaultMergeOptions
+	mergeOpts.GarbageRatio = 0.3
+	assert.Equal(t, context.Canceled, db.MergeWithOptions(ctx, mergeOpts))
+	after, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, before, after)
+
+	//第一个文件处理完之后取消，后面的文件保持不变
+	ctx, cancel = context.WithCancel(context.Background())
+	mergeOpts.Progress = func(progress MergeProgress) {
+		if progress.FilesDone > 0 {
+			cancel()
+		}
+	}
+	err = db.MergeWithOptions(ctx, mergeOpts)
+	assert.Equal(t, context.Canceled, err)
+	stat := db.Stat().Merge
+	assert.Equal(t, context.Canceled, stat.Err)
+	assert.False(t, stat.Running)
+	assert.Equal(t, 1, stat.FilesDone)
+	assert.True(t, stat.TotalFiles > 1)
+
+	//merge目录已经清理
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	after, err = db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, len(before), len(after))
+	var changed int
+	for i := range after {
+		if after[i] != before[i] {
+			changed++
+			assert.Equal(t, after[i].LiveSize+data.FileHeaderSize, after[i].Size)
+		}
+	}
+	assert.Equal(t, 1, changed)
+	checkDataFileStats(t, db)
+
+	//取消之后可以重新merge
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{GarbageRatio: 0.3}))
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), db.index.Size())
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	checkDataFileStats(t, db)
+}
+
+// 全量merge同样可以取消、限速和报告进度
+func TestDB_MergeContext(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, values := prepareMergeControlDB(t, opts)
+	defer Destroy_DB(db)
+
+	before, err := db.DataFileStats()
+	assert.Nil(t, err)
+
+	//第一个文件处理完之后取消，数据库保持merge之前的状态
+	ctx, cancel := context.WithCancel(context.Background())
+	mergeOpts := MergeOptions{Progress: func(progress MergeProgress) {
+		if progress.FilesDone > 0 {
+			cancel()
+		}
+	}}
+	assert.Equal(t, context.Canceled, db.MergeContext(ctx, mergeOpts))
+	stat := db.Stat().Merge
+	assert.Equal(t, context.Canceled, stat.Err)
+	assert.Equal(t, 1, stat.FilesDone)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	after, err := db.DataFileStats()
+	assert.Nil(t, err)
+	//merge开始时活跃文件转换为了旧文件，其他的文件保持不变
+	assert.Equal(t, before, after[:len(before)])
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	//取消之后可以重新merge，限速对全量merge同样生效
+	var reports int
+	mergeOpts = MergeOptions{BytesPerSecond: 512 * 1024, Progress: func(MergeProgress) { reports++ }}
+	start := time.Now()
+	assert.Nil(t, db.MergeContext(context.Background(), mergeOpts))
+	elapsed := time.Since(start)
+	stat = db.Stat().Merge
+	assert.Nil(t, stat.Err)
+	assert.True(t, reports > 0)
+	expected := time.Duration(float64(stat.BytesRead+stat.BytesWritten) / float64(mergeOpts.BytesPerSecond) * float64(time.Second))
+	assert.True(t, elapsed >= expected-mergeThrottleMinSleep, "elapsed %v, expected %v", elapsed, expected)
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), db.index.Size())
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
@@ -0,0 +1,62 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// merge时的压缩过滤器
+// merge复制每一条有效记录之前调用Option.CompactionFilter，由应用决定保留、丢弃或者替换value，例如删除已经注销的账户的数据、迁移value的格式
+// 被丢弃的key同时从内存索引中删除；merge期间被覆盖或者删除的key不受影响
+// 全量merge会重写所有的旧数据文件，被丢弃的记录直接不写入；选择性merge没有重写更早的文件，被丢弃的记录需要换成墓碑值，避免更早的旧数据在重启之后重新生效
+
+// CompactionDecision 压缩过滤器对一条记录的处理方式
+type CompactionDecision = byte
+
+const (
+	// CompactionKeep 原样保留
+	CompactionKeep CompactionDecision = iota
+
+	// CompactionDrop 丢弃这条记录，key被删除
+	CompactionDrop
+
+	// CompactionReplace 使用过滤器返回的value替换原来的value
+	CompactionReplace
+)
+
+// CompactionFilter merge时对每一条有效记录调用，返回CompactionReplace时第二个返回值是新的value
+// 过滤器在merge的goroutine中调用，不能在其中访问同一个数据库
+type CompactionFilter interface {
+	Filter(key, value []byte) (CompactionDecision, []byte)
+}
+
+// merge时被过滤器丢弃的记录，以及它原来在索引中的位置
+type filteredRecord struct {
+	key       []byte
+	pos       *data.LogRecordPos
+	tombstone *data.LogRecordPos //全量merge时为了保留的历史版本写入的墓碑值在新文件中的位置
+}
+
+// 对一条即将被merge复制的有效记录调用压缩过滤器，需要替换value时直接修改logRecord   返回false表示丢弃这条记录
+func (db *DB) filterRecord(key []byte, logRecord *data.LogRecord) bool {
+	if db.options.CompactionFilter == nil {
+		return true
+	}
+	decision, value := db.options.CompactionFilter.Filter(key, logRecord.Value)
+	switch decision {
+	case CompactionDrop:
+		return false
+	case CompactionReplace:
+		logRecord.Value = value
+	}
+	return true
+}
+
+// 从内存索引中删除被过滤器丢弃的key   merge期间被覆盖或者删除的key已经指向了其他位置，不需要处理   在访问此方法前必须持有互斥锁
+func (db *DB) removeFilteredKeys(records []*filteredRecord) {
+	for _, record := range records {
+		pos := db.index.Get(record.key)
+		if pos == nil || pos.Fid != record.pos.Fid || pos.Offset != record.pos.Offset {
+			continue
+		}
+		db.index.Delete(record.key)
+		db.liveSize[pos.Fid] -= int64(pos.Size)
+	}
+}
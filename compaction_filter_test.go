@@ -0,0 +1,215 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 丢弃前缀为drop的key，把前缀为old的key的value替换为new
+type prefixFilter struct {
+	calls int
+}
+
+func (f *prefixFilter) Filter(key, value []byte) (CompactionDecision, []byte) {
+	f.calls++
+	switch {
+	case bytes.HasPrefix(key, []byte("drop")):
+		return CompactionDrop, nil
+	case bytes.HasPrefix(key, []byte("old")):
+		return CompactionReplace, append([]byte("new-"), value...)
+	}
+	return CompactionKeep, nil
+}
+
+func prepareFilterDB(t *testing.T, opts Option) (*DB, map[string][]byte) {
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	expected := make(map[string][]byte)
+	for i := 0; i < 1500; i++ {
+		var key []byte
+		switch i % 3 {
+		case 0:
+			key = append([]byte("drop-"), utils.GetTestKey(i)...)
+		case 1:
+			key = append([]byte("old-"), utils.GetTestKey(i)...)
+		default:
+			key = append([]byte("keep-"), utils.GetTestKey(i)...)
+		}
+		value := utils.RandomValue(64)
+		assert.Nil(t, db.Put(key, value))
+		switch i % 3 {
+		case 0:
+		case 1:
+			expected[string(key)] = append([]byte("new-"), value...)
+		default:
+			expected[string(key)] = value
+		}
+	}
+	//制造一些无效数据，保证选择性merge会选中这些文件
+	for i := 2; i < 1500; i += 6 {
+		key := append([]byte("keep-"), utils.GetTestKey(i)...)
+		value := utils.RandomValue(64)
+		assert.Nil(t, db.Put(key, value))
+		expected[string(key)] = value
+	}
+	assert.Nil(t, rotateActiveFile(db))
+	db.hintWg.Wait()
+	return db, expected
+}
+
+func checkFilteredDB(t *testing.T, db *DB, expected map[string][]byte) {
+	assert.Equal(t, len(expected), db.index.Size())
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	for i := 0; i < 1500; i += 3 {
+		_, err := db.Get(append([]byte("drop-"), utils.GetTestKey(i)...))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	checkDataFileStats(t, db)
+}
+
+// 使用hint文件和不使用hint文件重新打开，结果都一样
+func reopenFilteredDB(t *testing.T, db *DB, opts Option, expected map[string][]byte) *DB {
+	assert.Nil(t, db.Close())
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	checkFilteredDB(t, db, expected)
+
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	for _, stat := range stats {
+		assert.Nil(t, data.RemoveHintFile(db.fs, opts.DirPath, stat.FileId))
+	}
+	_ = os.Remove(filepath.Join(opts.DirPath, data.HintFileName))
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkFilteredDB(t, db, expected)
+	return db
+}
+
+func TestDB_CompactionFilter_Merge(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-compaction-filter")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	filter := &prefixFilter{}
+	opts.CompactionFilter = filter
+	db, expected := prepareFilterDB(t, opts)
+	defer func() { Destroy_DB(db) }() //重新打开之后db指向新的实例
+
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, 1500, filter.calls)                             //每一条有效记录调用一次
+	assert.Equal(t, int64(500+250), db.Stat().Merge.RecordsDropped) //被丢弃的和被覆盖的记录
+	checkFilteredDB(t, db, expected)
+	db = reopenFilteredDB(t, db, opts, expected)
+}
+
+func TestDB_CompactionFilter_MergeWithOptions(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-compaction-filter")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	filter := &prefixFilter{}
+	opts.CompactionFilter = filter
+	db, expected := prepareFilterDB(t, opts)
+	defer func() { Destroy_DB(db) }() //重新打开之后db指向新的实例
+
+	//所有的旧数据文件都可以参与merge，只有包含无效数据的文件会被选中
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	var fileIds []uint32
+	for _, stat := range stats {
+		if stat.FileId != db.activeFile.FileId {
+			fileIds = append(fileIds, stat.FileId)
+		}
+	}
+	calls := liveRecordsInDirtyFiles(t, db)
+	assert.True(t, calls > 1000)
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{FileIds: fileIds}))
+	assert.Equal(t, calls, filter.calls)
+	checkFilteredDB(t, db, expected)
+	db = reopenFilteredDB(t, db, opts, expected)
+
+	//被丢弃的key写入了墓碑值，再次merge时不会重新出现
+	filter.calls = 0
+	calls = liveRecordsInDirtyFiles(t, db)
+	assert.True(t, calls > 0)
+	dirty := make(map[uint32]bool)
+	stats, err = db.DataFileStats()
+	assert.Nil(t, err)
+	for _, stat := range stats {
+		dirty[stat.FileId] = stat.DeadSize > 0
+	}
+	for key, value := range expected {
+		if bytes.HasPrefix([]byte(key), []byte("old")) && dirty[db.index.Get([]byte(key)).Fid] {
+			expected[key] = append([]byte("new-"), value...)
+		}
+	}
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{FileIds: fileIds}))
+	assert.Equal(t, calls, filter.calls)
+	checkFilteredDB(t, db, expected)
+}
+
+// merge期间被覆盖的key不受过滤器的影响
+func TestDB_CompactionFilter_Concurrent(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-compaction-filter")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, expected := prepareFilterDB(t, opts)
+	defer Destroy_DB(db)
+
+	overwrite := append([]byte("drop-"), utils.GetTestKey(0)...)
+	db.options.CompactionFilter = compactionFilterFunc(func(key, value []byte) (CompactionDecision, []byte) {
+		if bytes.Equal(key, overwrite) {
+			assert.Nil(t, db.Put(overwrite, []byte("value")))
+		}
+		return (&prefixFilter{}).Filter(key, value)
+	})
+	assert.Nil(t, db.Merge())
+	expected[string(overwrite)] = []byte("value")
+	assert.Equal(t, len(expected), db.index.Size())
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	checkDataFileStats(t, db)
+}
+
+// 包含无效数据的旧文件中的有效记录数，也就是选择性merge会调用过滤器的次数
+func liveRecordsInDirtyFiles(t *testing.T, db *DB) int {
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	dirty := make(map[uint32]bool)
+	for _, stat := range stats {
+		dirty[stat.FileId] = stat.DeadSize > 0 && stat.FileId != db.activeFile.FileId
+	}
+	var count int
+	iter := db.index.Iterator(false)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if dirty[iter.Value().Fid] {
+			count++
+		}
+	}
+	return count
+}
+
+type compactionFilterFunc func(key, value []byte) (CompactionDecision, []byte)
+
+func (f compactionFilterFunc) Filter(key, value []byte) (CompactionDecision, []byte) {
+	return f(key, value)
+}
@@ -0,0 +1,293 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// 崩溃一致性测试
+// 在内存文件系统上包装一层故障注入，让第n次写入失败(或者只写入一半)，然后模拟崩溃丢掉所有没有sync的数据，
+// 重新打开数据库之后检查：
+//   - SyncWrites为true时，返回成功的Put在崩溃之后都还在，失败的Put不会留下任何数据
+//   - WriteBatch.Commit是原子的，一个批次中的数据要么全部可见，要么全部不可见；开启了SyncWrites的批次提交成功之后是持久的
+//   - Merge在任何位置失败或者崩溃都不会丢失数据，包括应用merge结果时的任意一次重命名
+
+func crashTestOptions(fs vfs.FS) Option {
+	opts := DefaultOptioins
+	opts.DirPath = "bitcask-go-crash"
+	opts.FS = fs
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	return opts
+}
+
+func crashTestValue(i, version int) []byte {
+	return []byte(fmt.Sprintf("bitcask-value-%09d-%d", i, version))
+}
+
+func TestCrash_Put(t *testing.T) {
+	for n := 1; n <= 80; n++ {
+		t.Run(fmt.Sprintf("fail-write-%d", n), func(t *testing.T) {
+			fs := vfs.NewFaultFS(vfs.NewMemFS())
+			opts := crashTestOptions(fs)
+			opts.SyncWrites = true
+			db, err := OpenDB(opts)
+			assert.Nil(t, err)
+
+			fs.FailWrite(n, n%2 == 0)
+			acked := make(map[int]bool)
+			failed := -1
+			for i := 0; i < 100; i++ {
+				if err := db.Put(utils.GetTestKey(i), crashTestValue(i, 0)); err != nil {
+					assert.Equal(t, vfs.ErrInjected, err)
+					failed = i
+					continue //写入失败之后数据库仍然可以继续使用
+				}
+				acked[i] = true
+			}
+			assert.Nil(t, fs.Crash(int64(n%3)*7))
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			defer db.Close()
+			for i := 0; i < 100; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				if acked[i] {
+					assert.Nil(t, err)
+					assert.Equal(t, crashTestValue(i, 0), val)
+				} else if i == failed {
+					assert.Equal(t, ErrKeyNotFound, err)
+				}
+			}
+			assert.Equal(t, len(acked), db.index.Size())
+		})
+	}
+}
+
+func TestCrash_WriteBatch(t *testing.T) {
+	const batchSize = 20
+	for n := 1; n <= 120; n += 3 {
+		t.Run(fmt.Sprintf("fail-write-%d", n), func(t *testing.T) {
+			fs := vfs.NewFaultFS(vfs.NewMemFS())
+			opts := crashTestOptions(fs)
+			db, err := OpenDB(opts)
+			assert.Nil(t, err)
+
+			fs.FailWrite(n, n%2 == 0)
+			committed := make(map[int]bool)
+			for b := 0; b < 10; b++ {
+				wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+				for i := b * batchSize; i < (b+1)*batchSize; i++ {
+					assert.Nil(t, wb.Put(utils.GetTestKey(i), crashTestValue(i, 0)))
+				}
+				if err := wb.Commit(); err == nil {
+					committed[b] = true
+				}
+			}
+			assert.Nil(t, fs.Crash(int64(n%5)*11))
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			defer db.Close()
+			var keyNum int
+			for b := 0; b < 10; b++ {
+				//同一个批次中的数据要么全部存在，要么全部不存在
+				var found int
+				for i := b * batchSize; i < (b+1)*batchSize; i++ {
+					val, err := db.Get(utils.GetTestKey(i))
+					if err == nil {
+						assert.Equal(t, crashTestValue(i, 0), val)
+						found++
+					}
+				}
+				if committed[b] {
+					assert.Equal(t, batchSize, found)
+				} else {
+					assert.Equal(t, 0, found)
+				}
+				keyNum += found
+			}
+			assert.Equal(t, keyNum, db.index.Size())
+		})
+	}
+}
+
+func TestCrash_Merge(t *testing.T) {
+	//准备数据：多次覆盖写入以及删除，产生大量可以回收的数据
+	prepare := func(t *testing.T) (*vfs.FaultFS, map[int][]byte) {
+		fs := vfs.NewFaultFS(vfs.NewMemFS())
+		db, err := OpenDB(crashTestOptions(fs))
+		assert.Nil(t, err)
+		expected := make(map[int][]byte)
+		for version := 0; version < 3; version++ {
+			for i := 0; i < 200; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i, version)))
+				expected[i] = crashTestValue(i, version)
+			}
+		}
+		for i := 0; i < 200; i += 4 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(expected, i)
+		}
+		assert.Nil(t, db.Close())
+		return fs, expected
+	}
+	check := func(t *testing.T, db *DB, expected map[int][]byte) {
+		assert.Equal(t, len(expected), db.index.Size())
+		for i := 0; i < 200; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if value, ok := expected[i]; ok {
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			} else {
+				assert.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+	}
+
+	for n := 1; n <= 40; n += 2 {
+		t.Run(fmt.Sprintf("fail-write-%d", n), func(t *testing.T) {
+			fs, expected := prepare(t)
+			opts := crashTestOptions(fs)
+			db, err := OpenDB(opts)
+			assert.Nil(t, err)
+
+			//merge过程中写入失败，然后崩溃
+			fs.FailWrite(n, n%4 == 1)
+			_ = db.Merge()
+			assert.Nil(t, fs.Crash(int64(n%3)*13))
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			check(t, db, expected)
+
+			//再完整地merge一次，崩溃之后重新打开，merge的结果生效
+			assert.Nil(t, db.Merge())
+			assert.Nil(t, fs.Crash(0))
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			check(t, db, expected)
+			assert.Nil(t, db.Close())
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			check(t, db, expected)
+			assert.Nil(t, db.Close())
+		})
+	}
+}
+
+func TestFaultFS_Corrupt(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	opts := crashTestOptions(fs)
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i, 0)))
+	}
+	pos := db.index.Get(utils.GetTestKey(5))
+	assert.NotNil(t, pos)
+	assert.Nil(t, db.Close())
+
+	//篡改活跃文件中间的一条记录，之后还有有效的记录，不能当作没有完整写入的数据丢弃，打开数据库失败
+	fileName := fmt.Sprintf("%s/%09d.data", opts.DirPath, 0)
+	before, err := fs.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, fs.Corrupt(fileName, pos.Offset+int64(pos.Size)-1))
+	_, err = OpenDB(opts)
+	assert.NotNil(t, err)
+	//文件没有被截断
+	after, err := fs.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+}
+
+func TestFaultFS_TornTail(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	opts := crashTestOptions(fs)
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i, 0)))
+	}
+	pos := db.index.Get(utils.GetTestKey(9))
+	assert.NotNil(t, pos)
+	assert.Nil(t, db.Close())
+
+	//最后一条记录只写入了一部分，丢弃它之后可以正常打开
+	fileName := fmt.Sprintf("%s/%09d.data", opts.DirPath, 0)
+	file, err := fs.OpenFile(fileName, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Truncate(pos.Offset+int64(pos.Size)/2))
+	assert.Nil(t, file.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, db.index.Size())
+	assert.Nil(t, db.Close())
+
+	//最后一条记录的crc校验失败，同样丢弃
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(9), crashTestValue(9, 1)))
+	pos = db.index.Get(utils.GetTestKey(9))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, fs.Corrupt(fileName, pos.Offset+int64(pos.Size)-1))
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, db.index.Size())
+	assert.Nil(t, db.Close())
+}
+
+// 应用merge的过程中(删除旧文件、移动merge生成的文件)的任意一次重命名失败之后崩溃，重启时都会继续把merge应用完成
+func TestCrash_MergeApply(t *testing.T) {
+	for n := 1; n <= 100; n++ {
+		fs := vfs.NewFaultFS(vfs.NewMemFS())
+		opts := crashTestOptions(fs)
+		if n%2 == 0 {
+			opts.DataDirs = []string{"bitcask-go-crash-1"}
+		}
+		db, err := OpenDB(opts)
+		assert.Nil(t, err)
+		expected := make(map[int][]byte)
+		for version := 0; version < 3; version++ {
+			for i := 0; i < 200; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i, version)))
+				expected[i] = crashTestValue(i, version)
+			}
+		}
+		for i := 0; i < 200; i += 4 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(expected, i)
+		}
+
+		//有些重命名失败不影响merge(例如保存统计信息)，merge成功之后同样崩溃
+		fs.FailRename(n)
+		if err := db.Merge(); err != nil {
+			assert.True(t, errors.Is(err, vfs.ErrInjected), "%v", err)
+		}
+		assert.Nil(t, fs.Crash(0))
+
+		db, err = OpenDB(opts)
+		if !assert.Nil(t, err, "fail-rename-%d", n) {
+			return
+		}
+		assert.Equal(t, len(expected), db.index.Size(), "fail-rename-%d", n)
+		for i := 0; i < 200; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if value, ok := expected[i]; ok {
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			} else {
+				assert.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+		assert.Nil(t, db.Close())
+	}
+}
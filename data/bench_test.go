@@ -0,0 +1,94 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/vfs"
+	"testing"
+)
+
+// 对比不同校验算法下记录的编码、解码性能
+
+func benchmarkEncode(b *testing.B, typ ChecksumType, valueSize int) {
+	record := &LogRecord{Key: []byte("bitcask-go-bench-key"), Value: make([]byte, valueSize)}
+	_, size := EncodeLogRecordWithChecksum(record, typ)
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		EncodeLogRecordWithChecksum(record, typ)
+	}
+}
+
+func benchmarkReadLogRecord(b *testing.B, typ ChecksumType, valueSize int) {
+	dataFile, err := OpenDataFile(vfs.OS, b.TempDir(), 0, fio.StanderdFIO)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer dataFile.Close()
+	if err := dataFile.WriteHeader(NewFileHeader(typ)); err != nil {
+		b.Fatal(err)
+	}
+	record := &LogRecord{Key: []byte("bitcask-go-bench-key"), Value: make([]byte, valueSize)}
+	encRecord, size := EncodeLogRecordWithChecksum(record, typ)
+	if err := dataFile.Write(encRecord); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := dataFile.ReadLogRecord(dataFile.HeaderSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode_CRC32C_128B(b *testing.B) {
+	benchmarkEncode(b, ChecksumCRC32C, 128)
+}
+
+func BenchmarkEncode_IEEE_128B(b *testing.B) {
+	benchmarkEncode(b, ChecksumIEEE, 128)
+}
+
+func BenchmarkEncode_XXHash64_128B(b *testing.B) {
+	benchmarkEncode(b, ChecksumXXHash64, 128)
+}
+
+func BenchmarkEncode_CRC32C_4K(b *testing.B) {
+	benchmarkEncode(b, ChecksumCRC32C, 4096)
+}
+
+func BenchmarkEncode_IEEE_4K(b *testing.B) {
+	benchmarkEncode(b, ChecksumIEEE, 4096)
+}
+
+func BenchmarkEncode_XXHash64_4K(b *testing.B) {
+	benchmarkEncode(b, ChecksumXXHash64, 4096)
+}
+
+func BenchmarkReadLogRecord_CRC32C_128B(b *testing.B) {
+	benchmarkReadLogRecord(b, ChecksumCRC32C, 128)
+}
+
+func BenchmarkReadLogRecord_IEEE_128B(b *testing.B) {
+	benchmarkReadLogRecord(b, ChecksumIEEE, 128)
+}
+
+func BenchmarkReadLogRecord_XXHash64_128B(b *testing.B) {
+	benchmarkReadLogRecord(b, ChecksumXXHash64, 128)
+}
+
+func BenchmarkReadLogRecord_CRC32C_4K(b *testing.B) {
+	benchmarkReadLogRecord(b, ChecksumCRC32C, 4096)
+}
+
+func BenchmarkReadLogRecord_IEEE_4K(b *testing.B) {
+	benchmarkReadLogRecord(b, ChecksumIEEE, 4096)
+}
+
+func BenchmarkReadLogRecord_XXHash64_4K(b *testing.B) {
+	benchmarkReadLogRecord(b, ChecksumXXHash64, 4096)
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"bitcask-go/utils"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// 记录的校验算法，保存在文件头中，同一个文件中的所有记录使用同一种算法
+// 没有文件头以及FormatVersionV1的文件固定使用IEEE crc32
+
+type ChecksumType = byte
+
+const (
+	//Castagnoli crc32，在amd64、arm64上由标准库使用硬件指令计算
+	ChecksumCRC32C ChecksumType = iota
+
+	//IEEE crc32，以前的版本使用的算法
+	ChecksumIEEE
+
+	//64位的xxhash，检测错误的能力更强，每条记录多占用4个字节
+	ChecksumXXHash64
+)
+
+// 新写入的文件默认使用的校验算法
+const DefaultChecksum = ChecksumCRC32C
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 是否是支持的校验算法
+func validChecksum(typ ChecksumType) bool {
+	return typ <= ChecksumXXHash64
+}
+
+// 校验值在记录中占用的字节数
+func checksumSize(typ ChecksumType) int {
+	if typ == ChecksumXXHash64 {
+		return 8
+	}
+	return crc32.Size
+}
+
+// 计算buf的校验值
+func checksum(typ ChecksumType, buf []byte) uint64 {
+	switch typ {
+	case ChecksumIEEE:
+		return uint64(crc32.ChecksumIEEE(buf))
+	case ChecksumXXHash64:
+		return utils.XXHash64(buf)
+	default:
+		return uint64(crc32.Checksum(buf, castagnoliTable))
+	}
+}
+
+// 把校验值写入到buf的开头
+func putChecksum(typ ChecksumType, buf []byte, sum uint64) {
+	if typ == ChecksumXXHash64 {
+		binary.LittleEndian.PutUint64(buf, sum)
+		return
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(sum))
+}
+
+// 从buf的开头读取校验值
+func readChecksum(typ ChecksumType, buf []byte) uint64 {
+	if typ == ChecksumXXHash64 {
+		return binary.LittleEndian.Uint64(buf)
+	}
+	return uint64(binary.LittleEndian.Uint32(buf))
+}
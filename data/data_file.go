@@ -2,11 +2,12 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"bitcask-go/vfs"
 	"errors"
 	"fmt"
-	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
 var (
@@ -14,52 +15,105 @@ var (
 )
 
 const (
-	DataFileNameSuffix    = ".data"
-	HintFileName          = "hint-index"
-	MergeFinishedFileName = "merge-finished"
-	SeqNoFileName         = "seq-no"
+	DataFileNameSuffix     = ".data"
+	CompressedFileSuffix   = ".gz" //冷数据目录中压缩之后的数据文件：000000000.data.gz
+	HintFileName           = "hint-index"
+	MergeFinishedFileName  = "merge-finished"
+	SeqNoFileName          = "seq-no"
+	CheckpointFileName     = "index-checkpoint"
+	FileStatsFileName      = "file-stats"
+	ManifestFileName       = "MANIFEST"
+	VersionRemapFileName   = "version-remap"
+	ImportProgressFileName = "import-progress"
 )
 
 // 数据文件的一些字段
 type DataFile struct {
-	FileId    uint32        //文件id   用于表明当前DataFile的编号
-	WriteOff  int64         //文件偏移：应该把文件写到当前DataFile文件的哪个位置
-	IoManager fio.IOManager //io读写管理   这里就涉及将数据写入到磁盘(数据库)中
+	FileId     uint32        //文件id   用于表明当前DataFile的编号
+	WriteOff   int64         //文件偏移：应该把文件写到当前DataFile文件的哪个位置
+	IoManager  fio.IOManager //io读写管理   这里就涉及将数据写入到磁盘(数据库)中
+	Header     FileHeader    //文件头，没有文件头的旧格式文件版本为FormatVersionLegacy
+	HeaderSize int64         //文件头的长度，第一条记录从这里开始   旧格式的文件为0
 }
 
 // 打开新的数据文件
-func OpenDataFile(dirpath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+func OpenDataFile(fs vfs.FS, dirpath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	//根据传入的dirpath和fileId，加上后缀.data之后  我们就找到了对应在磁盘上的文件，然后根据文件 填充好DataFile这个结构体，然后返回就好了
-	fileName := GetDataFileName(dirpath, fileId)
+	fileName := dataFileName(dirpath, fileId, ioType)
 	//这样就得到了文件的路径  G://....//000000000.data
-	return newDataFile(fileName, fileId, ioType)
-
+	dataFile, err := newDataFile(fs, fileName, fileId, ioType)
+	if err != nil {
+		return nil, err
+	}
+	if err := dataFile.readHeader(); err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+	return dataFile, nil
 }
 
 // 打开Hint索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(fs vfs.FS, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName) //HintFileName为"hint-index"
-	return newDataFile(fileName, 0, fio.StanderdFIO)
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
 }
 
 // 与打开Hint文件类似，添加一个标识merge完成的文件
-func OpenMergeFinishedFile(dirpath string) (*DataFile, error) {
+func OpenMergeFinishedFile(fs vfs.FS, dirpath string) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StanderdFIO)
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
 }
 
 // 存储事务序列号的文件
-func OpenSeqNoFile(dirpath string) (*DataFile, error) {
+func OpenSeqNoFile(fs vfs.FS, dirpath string) (*DataFile, error) {
 	fileName := filepath.Join(dirpath, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StanderdFIO)
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
+}
+
+// 打开索引检查点文件   这里传入的是完整的文件名，因为写检查点时会先写入临时文件，再重命名
+func OpenCheckpointFile(fs vfs.FS, fileName string) (*DataFile, error) {
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
+}
+
+// 打开MANIFEST文件   和检查点一样先写入临时文件，再重命名
+func OpenManifestFile(fs vfs.FS, fileName string) (*DataFile, error) {
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
+}
+
+// 打开保存数据文件统计信息的文件   和检查点一样先写入临时文件，再重命名
+func OpenFileStatsFile(fs vfs.FS, fileName string) (*DataFile, error) {
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
+}
+
+// 打开保存被merge移动过的历史版本位置的文件   和检查点一样先写入临时文件，再重命名
+func OpenVersionRemapFile(fs vfs.FS, fileName string) (*DataFile, error) {
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
+}
+
+// 打开保存导入进度的文件   和检查点一样先写入临时文件，再重命名
+func OpenImportProgressFile(fs vfs.FS, fileName string) (*DataFile, error) {
+	return newDataFile(fs, fileName, 0, fio.StanderdFIO)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
-	ioManager, err := fio.NewIOManager(fileName, ioType) //所以IOManager是针对磁盘上的文件进行操作的
+// 压缩之后的数据文件名
+func GetCompressedDataFileName(dirPath string, fileId uint32) string {
+	return GetDataFileName(dirPath, fileId) + CompressedFileSuffix
+}
+
+// 压缩文件使用单独的文件名，其他IO类型打开的都是同一个数据文件
+func dataFileName(dirPath string, fileId uint32, ioType fio.FileIOType) string {
+	if ioType == fio.Compressed {
+		return GetCompressedDataFileName(dirPath, fileId)
+	}
+	return GetDataFileName(dirPath, fileId)
+}
+
+func newDataFile(fs vfs.FS, fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	ioManager, err := fio.NewIOManager(fs, fileName, ioType) //所以IOManager是针对磁盘上的文件进行操作的
 	if err != nil {
 		return nil, err
 	}
@@ -68,11 +122,21 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
+		Header:    FileHeader{Version: FormatVersionLegacy, Checksum: ChecksumIEEE}, //没有文件头的文件使用IEEE crc32
 	}, nil
 	//这样返回的DataFile就能够对G://....//000000000.data文件进行read write等操作了
 }
 
-// header信息：crc验证码：4字节   Type：1字节  keysize：5字节  valuesize：5字节
+// 读取header使用的缓冲区，读取完之后就不再使用了，复用这部分内存
+var headerBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, MaxLogRecordHeaderSize)
+		return &buf
+	},
+}
+
+// header信息：校验值：4或8字节   Type：1字节  keysize：5字节  valuesize：5字节
+// 先读取header得到整条记录的长度，再分配一次内存放下整条记录，key和value直接引用其中的数据，校验值也在这段内存上一次算出
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	fileSize, err := df.IoManager.Size() //返回当前dataFile文件的总大小
 	if err != nil {
@@ -80,19 +144,25 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	}
 
 	//如果读取的最大header长度已经超过了文件的长度，则只需要读取到文件末尾即可
-	var headerBytes int64 = maxLogRecordHeaderSize //15
-	if int64(maxLogRecordHeaderSize)+offset > fileSize {
+	var headerBytes int64 = MaxLogRecordHeaderSize
+	if int64(MaxLogRecordHeaderSize)+offset > fileSize {
 		headerBytes = fileSize - offset
 	}
+	if headerBytes <= 0 {
+		return nil, 0, io.EOF
+	}
 
 	//在读取数据以及启动引擎实例的时候都需要使用，实现的作用就是根据偏移offset读取指定位置的logrecord信息
-	headerBuf, err := df.readNBytes(headerBytes, offset) //从offset开始处读取前headerBytes个字节的数据
-	if err != nil {
+	bufPtr := headerBufPool.Get().(*[]byte)
+	defer headerBufPool.Put(bufPtr)
+	headerBuf := (*bufPtr)[:headerBytes]
+	if _, err := df.IoManager.Read(headerBuf, offset); err != nil { //从offset开始处读取前headerBytes个字节的数据
 		return nil, 0, err
 	}
 
 	//拿到了header信息之后，需要对它进行解码
-	header, headerSize := decodeLogRecordHeader(headerBuf) //解码读取出来的headerBuf信息
+	typ := df.Header.Checksum
+	header, headerSize := decodeLogRecordHeaderWithVersion(headerBuf, typ, df.Header.Versioned()) //解码读取出来的headerBuf信息
 	//以下两个条件表示读取到了文件末尾，直接返回EOF错误
 	if header == nil {
 		return nil, 0, io.EOF
@@ -104,32 +174,67 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	//取出key和value的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize //这里记录的就是整个logrecord的长度
-	//整个record的形状是： crc   type   keysize    valuesize    key   value
-
-	logRecord := &LogRecord{
-		Type: header.recordType,
+	//整个record的形状是： checksum   type   keysize    valuesize    key   value
+	//记录超出了文件的末尾，说明是没有完整写入的记录(或者是崩溃时没有写完的文件头)，和读取到文件末尾一样处理
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
 	}
 
-	//开始读取用户实际存储的key/value
-	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize) //这里读取的就是key和value的数据
-		if err != nil {
+	//已经读取到的部分直接拷贝，只需要再读取剩下的key和value
+	recordBuf := make([]byte, recordSize)
+	n := int64(copy(recordBuf, headerBuf))
+	if n < recordSize {
+		if _, err := df.IoManager.Read(recordBuf[n:], offset+n); err != nil {
 			return nil, 0, err
 		}
+	}
 
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
+	//最后校验以下数据的校验值是否正确     校验值需要根据type字段   keysize字段   valuesize字段以及key、value共同计算得到
+	if checksum(typ, recordBuf[checksumSize(typ):]) != header.crc {
+		return nil, 0, ErrInvalidCRC
 	}
+	return &LogRecord{
+		Key:       recordBuf[headerSize : headerSize+keySize],
+		Value:     recordBuf[headerSize+keySize:],
+		Type:      header.recordType,
+		Timestamp: header.timestamp,
+		Prev:      header.prev,
+	}, recordSize, nil
+}
 
-	//最后校验以下数据的crc是否正确     crc需要根据type字段   keysize字段   value字段共同计算得到
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	//注意这里的headerBuf的总长度是maxLogRecordHeaderSize，但是实际上有效的数据长度只有headerSize，需要进行一次截取
-	//这里传入的第二个参数就是header中的keysize和valuesize字段
+// 读取文件头   空文件以及没有文件头的旧格式文件都当作旧格式，新的活跃文件在写入数据之前会先写入文件头(见WriteHeader)
+func (df *DataFile) readHeader() error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size < FileHeaderSize {
+		return nil
+	}
+	buf, err := df.readNBytes(FileHeaderSize, 0)
+	if err != nil {
+		return err
+	}
+	header, err := DecodeFileHeader(buf)
+	if err != nil || header == nil {
+		return err
+	}
+	df.Header = *header
+	df.HeaderSize = FileHeaderSize
+	return nil
+}
 
-	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+// 在空的数据文件开头写入文件头
+func (df *DataFile) WriteHeader(header *FileHeader) error {
+	if df.WriteOff != 0 {
+		return errors.New("the file header can only be written to an empty file")
 	}
-	return logRecord, recordSize, nil
+	if err := df.Write(EncodeFileHeader(header)); err != nil {
+		return err
+	}
+	df.Header = *header
+	df.HeaderSize = FileHeaderSize
+	return nil
 }
 
 func (df *DataFile) Sync() error { //将文件持久化到磁盘当中
@@ -159,11 +264,11 @@ func (df *DataFile) Write(buf []byte) error { //写入到数据文件中
 	return nil
 }
 
-func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
+func (df *DataFile) SetIOManager(fs vfs.FS, dirPath string, ioType fio.FileIOType) error {
 	if err := df.IoManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	ioManager, err := fio.NewIOManager(fs, dataFileName(dirPath, df.FileId, ioType), ioType)
 	if err != nil {
 		return err
 	}
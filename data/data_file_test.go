@@ -1,27 +1,29 @@
 package data
 
 import (
+	"bitcask-go/fio"
+	"bitcask-go/vfs"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0)
+	dataFile1, err := OpenDataFile(vfs.OS, os.TempDir(), 0, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 111)
+	dataFile2, err := OpenDataFile(vfs.OS, os.TempDir(), 111, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
-	dataFile3, err := OpenDataFile(os.TempDir(), 111)
+	dataFile3, err := OpenDataFile(vfs.OS, os.TempDir(), 111, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 	//t.Log(os.TempDir())
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0)
+	dataFile1, err := OpenDataFile(vfs.OS, os.TempDir(), 0, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
@@ -30,7 +32,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 123)
+	dataFile1, err := OpenDataFile(vfs.OS, os.TempDir(), 123, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
@@ -42,7 +44,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 456)
+	dataFile1, err := OpenDataFile(vfs.OS, os.TempDir(), 456, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
@@ -54,7 +56,7 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 222)
+	dataFile, err := OpenDataFile(vfs.OS, os.TempDir(), 222, fio.StanderdFIO)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// 数据文件和hint文件开头的文件头，标识文件的格式版本，之后增加新的字段(过期时间、时间戳、压缩等)时旧的文件仍然可以识别
+// 文件头的结构：
+//
+//	+-----------+---------------+-----------+---------------+---------------+-----------+
+//	| magic		| version 版本	| flags		| checksum 算法	| serial 序号	| crc 校验值	|
+//	+-----------+---------------+-----------+---------------+---------------+-----------+
+//	  4字节		  2字节			  2字节		  1字节			  3字节			  4字节
+//
+// 以前的版本写入的文件没有文件头，第一条记录直接从文件开头开始，读取时仍然支持这种格式(FormatVersionLegacy)
+// 文件头本身的crc固定使用IEEE crc32，记录使用的校验算法见checksum.go
+// serial是数据文件的序号，同一个文件id的数据文件被merge重写之后序号会改变，以前的版本中这3个字节是保留的0
+var (
+	ErrUnsupportedFileFormat = errors.New("unsupported file format, it maybe written by a newer version")
+)
+
+const (
+	FileHeaderSize = 16
+
+	//没有文件头的旧格式
+	FormatVersionLegacy uint16 = 0
+	//带有文件头的格式，记录固定使用IEEE crc32
+	FormatVersionV1 uint16 = 1
+	//文件头中记录了校验算法
+	FormatVersionV2 uint16 = 2
+	//新写入的文件使用的格式版本
+	CurrentFormatVersion = FormatVersionV2
+
+	//文件中的记录带有提交时间戳以及指向同一个key上一个版本的指针(见log_record.go)
+	FileFlagVersioned uint16 = 1 << 0
+
+	//serial只占用3个字节
+	MaxFileSerial uint32 = 1<<24 - 1
+
+	//当前版本能够识别的flags，出现其他的位说明文件是更新的版本写入的
+	supportedFileFlags = FileFlagVersioned
+)
+
+var fileHeaderMagic = [4]byte{'B', 'C', 'S', 'K'}
+
+// 文件头
+type FileHeader struct {
+	Version  uint16       //格式版本
+	Flags    uint16       //格式相关的标志位
+	Checksum ChecksumType //文件中的记录使用的校验算法
+	Serial   uint32       //数据文件的序号，只使用低24位
+}
+
+// 新写入的文件使用的文件头
+func NewFileHeader(checksum ChecksumType) *FileHeader {
+	return &FileHeader{Version: CurrentFormatVersion, Checksum: checksum}
+}
+
+// 新写入的数据文件使用的文件头   数据文件中的记录都带有版本信息，hint文件等其他文件不需要
+func NewDataFileHeader(checksum ChecksumType, serial uint32) *FileHeader {
+	header := NewFileHeader(checksum)
+	header.Flags |= FileFlagVersioned
+	header.Serial = serial & MaxFileSerial
+	return header
+}
+
+// 文件中的记录是否带有版本信息
+func (h *FileHeader) Versioned() bool {
+	return h.Flags&FileFlagVersioned != 0
+}
+
+// 编码文件头
+func EncodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf[0:4], fileHeaderMagic[:])
+	binary.LittleEndian.PutUint16(buf[4:6], header.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], header.Flags)
+	buf[8] = header.Checksum
+	buf[9], buf[10], buf[11] = byte(header.Serial), byte(header.Serial>>8), byte(header.Serial>>16)
+	binary.LittleEndian.PutUint32(buf[12:16], crc32.ChecksumIEEE(buf[:12]))
+	return buf
+}
+
+// 解码文件头   buf中不是有效的文件头时返回nil，说明是没有文件头的旧格式文件
+// 旧格式的文件开头是第一条记录的crc，恰好和magic、文件头的crc都对得上的概率可以忽略
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < FileHeaderSize || string(buf[0:4]) != string(fileHeaderMagic[:]) {
+		return nil, nil
+	}
+	if crc32.ChecksumIEEE(buf[:12]) != binary.LittleEndian.Uint32(buf[12:16]) {
+		return nil, nil
+	}
+	header := &FileHeader{
+		Version: binary.LittleEndian.Uint16(buf[4:6]),
+		Flags:   binary.LittleEndian.Uint16(buf[6:8]),
+		Serial:  uint32(buf[9]) | uint32(buf[10])<<8 | uint32(buf[11])<<16,
+	}
+	if header.Version > CurrentFormatVersion || header.Flags&^supportedFileFlags != 0 {
+		return nil, ErrUnsupportedFileFormat
+	}
+	header.Checksum = ChecksumIEEE
+	if header.Version >= FormatVersionV2 {
+		header.Checksum = buf[8]
+		if !validChecksum(header.Checksum) {
+			return nil, ErrUnsupportedFileFormat
+		}
+	}
+	return header, nil
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestFileHeader_Encode(t *testing.T) {
+	buf := EncodeFileHeader(NewFileHeader(DefaultChecksum))
+	assert.Equal(t, FileHeaderSize, len(buf))
+	header, err := DecodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFormatVersion, header.Version)
+	assert.Equal(t, uint16(0), header.Flags)
+	assert.Equal(t, DefaultChecksum, header.Checksum)
+
+	//V1版本的文件固定使用IEEE crc32
+	header, err = DecodeFileHeader(EncodeFileHeader(&FileHeader{Version: FormatVersionV1}))
+	assert.Nil(t, err)
+	assert.Equal(t, ChecksumIEEE, header.Checksum)
+
+	//旧格式文件的开头是一条记录，不是文件头
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	header, err = DecodeFileHeader(encRecord)
+	assert.Nil(t, err)
+	assert.Nil(t, header)
+
+	//文件头被破坏
+	buf[5] ^= 0xff
+	header, err = DecodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, header)
+
+	//更新的版本或者不认识的flags
+	_, err = DecodeFileHeader(EncodeFileHeader(&FileHeader{Version: CurrentFormatVersion + 1}))
+	assert.Equal(t, ErrUnsupportedFileFormat, err)
+	_, err = DecodeFileHeader(EncodeFileHeader(&FileHeader{Version: CurrentFormatVersion, Flags: 1 << 15}))
+	assert.Equal(t, ErrUnsupportedFileFormat, err)
+	_, err = DecodeFileHeader(EncodeFileHeader(&FileHeader{Version: CurrentFormatVersion, Checksum: ChecksumXXHash64 + 1}))
+	assert.Equal(t, ErrUnsupportedFileFormat, err)
+}
+
+func TestDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-header")
+	defer os.RemoveAll(dir)
+
+	record := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")}
+	encRecord, size := EncodeLogRecordWithChecksum(record, DefaultChecksum)
+
+	//带有文件头的数据文件，第一条记录在文件头之后
+	dataFile, err := OpenDataFile(vfs.OS, dir, 1, fio.StanderdFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.WriteHeader(NewFileHeader(DefaultChecksum)))
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.NotNil(t, dataFile.WriteHeader(NewFileHeader(DefaultChecksum))) //只能写入空文件
+	assert.Nil(t, dataFile.Close())
+
+	dataFile, err = OpenDataFile(vfs.OS, dir, 1, fio.StanderdFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFormatVersion, dataFile.Header.Version)
+	assert.Equal(t, int64(FileHeaderSize), dataFile.HeaderSize)
+	record, n, err := dataFile.ReadLogRecord(dataFile.HeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size, n)
+	assert.Equal(t, []byte("bitcask-go"), record.Value)
+	assert.Nil(t, dataFile.Close())
+
+	//没有文件头的旧格式数据文件使用IEEE crc32
+	encRecord, _ = EncodeLogRecord(record)
+	dataFile, err = OpenDataFile(vfs.OS, dir, 2, fio.StanderdFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Nil(t, dataFile.Close())
+	dataFile, err = OpenDataFile(vfs.OS, dir, 2, fio.StanderdFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, FormatVersionLegacy, dataFile.Header.Version)
+	assert.Equal(t, int64(0), dataFile.HeaderSize)
+	assert.Equal(t, ChecksumIEEE, dataFile.Header.Checksum)
+	record, _, err = dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("name"), record.Key)
+	assert.Nil(t, dataFile.Close())
+}
+
+func TestDataFile_VersionedRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-header")
+	defer os.RemoveAll(dir)
+
+	//数据文件的文件头带有序号，记录中带有提交时间和上一个版本的指针
+	header := NewDataFileHeader(DefaultChecksum, 42)
+	assert.True(t, header.Versioned())
+	decoded, err := DecodeFileHeader(EncodeFileHeader(header))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(42), decoded.Serial)
+	assert.True(t, decoded.Versioned())
+	assert.False(t, NewFileHeader(DefaultChecksum).Versioned())
+
+	dataFile, err := OpenDataFile(vfs.OS, dir, 1, fio.StanderdFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.WriteHeader(header))
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask"), Timestamp: 100}
+	enc1, size1 := EncodeLogRecordWithHeader(rec1, header)
+	assert.Nil(t, dataFile.Write(enc1))
+	rec2 := &LogRecord{
+		Key:       []byte("name"),
+		Value:     []byte("bitcask-go"),
+		Timestamp: 200,
+		Prev:      &VersionPtr{Pos: LogRecordPos{Fid: 1, Offset: FileHeaderSize, Size: uint32(size1)}, Serial: 42},
+	}
+	enc2, size2 := EncodeLogRecordWithHeader(rec2, header)
+	assert.Nil(t, dataFile.Write(enc2))
+	assert.Nil(t, dataFile.Close())
+
+	dataFile, err = OpenDataFile(vfs.OS, dir, 1, fio.StanderdFIO)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(42), dataFile.Header.Serial)
+	record, n, err := dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size1, n)
+	assert.Equal(t, int64(100), record.Timestamp)
+	assert.Nil(t, record.Prev)
+	record, n, err = dataFile.ReadLogRecord(FileHeaderSize + size1)
+	assert.Nil(t, err)
+	assert.Equal(t, size2, n)
+	assert.Equal(t, []byte("bitcask-go"), record.Value)
+	assert.Equal(t, int64(200), record.Timestamp)
+	assert.Equal(t, rec2.Prev, record.Prev)
+	assert.Nil(t, dataFile.Close())
+
+	//没有版本信息的文件头，记录按照以前的格式编码
+	enc, _ := EncodeLogRecordWithHeader(rec2, NewFileHeader(DefaultChecksum))
+	plain, _ := EncodeLogRecordWithChecksum(rec2, DefaultChecksum)
+	assert.Equal(t, plain, enc)
+}
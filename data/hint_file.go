@@ -0,0 +1,193 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/vfs"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// 每个数据文件对应的hint文件
+// 数据文件从活跃文件变为旧文件之后就不会再改变了，此时为它生成一个hint文件，记录其中每一条记录的key、位置、大小以及类型(墓碑值)
+// 启动的时候直接加载hint文件，不需要再把数据文件中的value也读一遍
+//
+// hint文件的结构：
+//
+//	+-----------+-----------+-----+-----------+---------------+---------------+-----------+
+//	| record 1	| record 2	| ... | record n	| count 记录数	| dataSize		| crc 校验值	|
+//	+-----------+-----------+-----+-----------+---------------+---------------+-----------+
+//	  每条记录都编码为LogRecord: key为数据文件中的key，value为位置信息     8字节			8字节			4字节
+//
+// 最后的footer中记录了对应数据文件的大小以及前面所有记录的crc校验值，任何一项对不上都说明hint文件无效
+// 和数据文件一样，hint文件开头有一个文件头(见file_header.go)，以前的版本写入的hint文件没有文件头
+// 记录使用文件头中的校验算法，footer中的校验值固定使用IEEE crc32
+var (
+	ErrInvalidHintFile = errors.New("invalid hint file, it maybe corrupted or out of date")
+)
+
+const (
+	HintFileNameSuffix = ".hint"
+	hintFooterSize     = 8 + 8 + crc32.Size
+)
+
+// hint文件中的一条记录，对应数据文件中的一条LogRecord
+type HintRecord struct {
+	Key  []byte        //数据文件中记录的key(包含事务序列号)
+	Type LogRecordType //记录的类型，包括墓碑值和事务完成标识
+	Pos  *LogRecordPos //记录在数据文件中的位置以及大小
+}
+
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+// 写入数据文件对应的hint文件    先写到临时文件中，sync之后再重命名，不会留下写了一半的hint文件
+func WriteHintFile(fs vfs.FS, dirPath string, fileId uint32, dataSize int64, records []*HintRecord) error {
+	fileName := GetHintFileName(dirPath, fileId)
+	tmpFileName := fileName + ".tmp"
+
+	//将文件头和所有记录编码到一个缓冲区中，一次写入
+	buf := EncodeFileHeader(NewFileHeader(DefaultChecksum))
+	body := len(buf)
+	for _, record := range records {
+		encRecord, _ := EncodeLogRecordWithChecksum(&LogRecord{
+			Key:   record.Key,
+			Value: EncodeLogRecordPos(record.Pos),
+			Type:  record.Type,
+		}, DefaultChecksum)
+		buf = append(buf, encRecord...)
+	}
+	footer := make([]byte, hintFooterSize)
+	binary.LittleEndian.PutUint64(footer[0:8], uint64(len(records)))
+	binary.LittleEndian.PutUint64(footer[8:16], uint64(dataSize))
+	crc := crc32.ChecksumIEEE(buf[body:])
+	crc = crc32.Update(crc, crc32.IEEETable, footer[:16])
+	binary.LittleEndian.PutUint32(footer[16:], crc)
+	buf = append(buf, footer...)
+
+	_ = fs.Remove(tmpFileName)
+	ioManager, err := fio.NewIOManager(fs, tmpFileName, fio.StanderdFIO)
+	if err != nil {
+		return err
+	}
+	_, err = ioManager.Write(buf)
+	if err == nil {
+		err = ioManager.Sync()
+	}
+	if closeErr := ioManager.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = fs.Remove(tmpFileName)
+		return err
+	}
+	return fs.Rename(tmpFileName, fileName)
+}
+
+// 读取数据文件对应的hint文件   dataSize为当前数据文件的大小，和hint文件中记录的不一致说明hint文件已经过期了
+// hint文件不存在时返回os.ErrNotExist，hint文件无效时返回ErrInvalidHintFile
+func ReadHintFile(fs vfs.FS, dirPath string, fileId uint32, dataSize int64) ([]*HintRecord, error) {
+	fileName := GetHintFileName(dirPath, fileId)
+	if _, err := fs.Stat(fileName); err != nil {
+		return nil, err
+	}
+	ioManager, err := fio.NewIOManager(fs, fileName, fio.StanderdFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer ioManager.Close()
+
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size < hintFooterSize {
+		return nil, ErrInvalidHintFile
+	}
+	buf := make([]byte, size)
+	if _, err := ioManager.Read(buf, 0); err != nil {
+		return nil, err
+	}
+
+	//跳过文件头   没有文件头的旧格式使用IEEE crc32
+	header, err := DecodeFileHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	typ := ChecksumIEEE
+	if header != nil {
+		typ = header.Checksum
+		buf = buf[FileHeaderSize:]
+		size -= FileHeaderSize
+		if size < hintFooterSize {
+			return nil, ErrInvalidHintFile
+		}
+	}
+
+	//校验footer
+	body, footer := buf[:size-hintFooterSize], buf[size-hintFooterSize:]
+	count := binary.LittleEndian.Uint64(footer[0:8])
+	hintDataSize := int64(binary.LittleEndian.Uint64(footer[8:16]))
+	crc := crc32.ChecksumIEEE(body)
+	crc = crc32.Update(crc, crc32.IEEETable, footer[:16])
+	if crc != binary.LittleEndian.Uint32(footer[16:]) || hintDataSize != dataSize {
+		return nil, ErrInvalidHintFile
+	}
+
+	//依次解码每一条记录
+	records := make([]*HintRecord, 0, count)
+	var offset int64 = 0
+	for offset < int64(len(body)) {
+		logRecord, n, err := decodeLogRecord(body[offset:], typ)
+		if err != nil {
+			return nil, ErrInvalidHintFile
+		}
+		//hint文件只对应一个数据文件，文件id以文件名为准   批量导入的文件挂载到数据库上时会换成新的文件id
+		pos := DecodeLogRecordPos(logRecord.Value)
+		pos.Fid = fileId
+		records = append(records, &HintRecord{
+			Key:  logRecord.Key,
+			Type: logRecord.Type,
+			Pos:  pos,
+		})
+		offset += n
+	}
+	if uint64(len(records)) != count {
+		return nil, ErrInvalidHintFile
+	}
+	return records, nil
+}
+
+// 删除数据文件对应的hint文件
+func RemoveHintFile(fs vfs.FS, dirPath string, fileId uint32) error {
+	if err := fs.Remove(GetHintFileName(dirPath, fileId)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 从内存中的字节数组里解码出一条完整的LogRecord，并校验校验值
+func decodeLogRecord(buf []byte, typ ChecksumType) (*LogRecord, int64, error) {
+	header, headerSize := decodeLogRecordHeader(buf, typ)
+	if header == nil {
+		return nil, 0, ErrInvalidCRC
+	}
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	recordSize := headerSize + keySize + valueSize
+	if recordSize > int64(len(buf)) {
+		return nil, 0, ErrInvalidCRC
+	}
+	if checksum(typ, buf[checksumSize(typ):recordSize]) != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	logRecord := &LogRecord{
+		Key:   buf[headerSize : headerSize+keySize],
+		Value: buf[headerSize+keySize : recordSize],
+		Type:  header.recordType,
+	}
+	return logRecord, recordSize, nil
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"bitcask-go/vfs"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+func TestWriteHintFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-file")
+	defer os.RemoveAll(dir)
+
+	records := []*HintRecord{
+		{Key: []byte("key-a"), Type: LogRecordNormal, Pos: &LogRecordPos{Fid: 3, Offset: 0, Size: 20}},
+		{Key: []byte("key-b"), Type: LogRecordDeleted, Pos: &LogRecordPos{Fid: 3, Offset: 20, Size: 12}},
+		{Key: []byte("txn-fin"), Type: LogRecordTxnFinished, Pos: &LogRecordPos{Fid: 3, Offset: 32, Size: 14}},
+	}
+	err := WriteHintFile(vfs.OS, dir, 3, 46, records)
+	assert.Nil(t, err)
+
+	res, err := ReadHintFile(vfs.OS, dir, 3, 46)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), len(res))
+	for i, record := range records {
+		assert.Equal(t, record.Key, res[i].Key)
+		assert.Equal(t, record.Type, res[i].Type)
+		assert.Equal(t, *record.Pos, *res[i].Pos)
+	}
+
+	//空的hint文件
+	err = WriteHintFile(vfs.OS, dir, 4, 0, nil)
+	assert.Nil(t, err)
+	res, err = ReadHintFile(vfs.OS, dir, 4, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res))
+}
+
+func TestReadHintFile_Invalid(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-file-invalid")
+	defer os.RemoveAll(dir)
+
+	//hint文件不存在
+	_, err := ReadHintFile(vfs.OS, dir, 1, 100)
+	assert.True(t, os.IsNotExist(err))
+
+	records := []*HintRecord{
+		{Key: []byte("key-a"), Type: LogRecordNormal, Pos: &LogRecordPos{Fid: 1, Offset: 0, Size: 100}},
+	}
+	err = WriteHintFile(vfs.OS, dir, 1, 100, records)
+	assert.Nil(t, err)
+
+	//数据文件大小发生了变化，hint文件过期
+	_, err = ReadHintFile(vfs.OS, dir, 1, 200)
+	assert.Equal(t, ErrInvalidHintFile, err)
+
+	//hint文件内容被破坏
+	buf, err := os.ReadFile(GetHintFileName(dir, 1))
+	assert.Nil(t, err)
+	buf[FileHeaderSize+2] ^= 0xff
+	err = os.WriteFile(GetHintFileName(dir, 1), buf, 0644)
+	assert.Nil(t, err)
+	_, err = ReadHintFile(vfs.OS, dir, 1, 100)
+	assert.Equal(t, ErrInvalidHintFile, err)
+
+	err = RemoveHintFile(vfs.OS, dir, 1)
+	assert.Nil(t, err)
+	_, err = ReadHintFile(vfs.OS, dir, 1, 100)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReadHintFile_Legacy(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-file-legacy")
+	defer os.RemoveAll(dir)
+
+	records := []*HintRecord{
+		{Key: []byte("key-a"), Type: LogRecordNormal, Pos: &LogRecordPos{Fid: 2, Offset: 0, Size: 20}},
+		{Key: []byte("key-b"), Type: LogRecordDeleted, Pos: &LogRecordPos{Fid: 2, Offset: 20, Size: 12}},
+	}
+	//和以前的版本写入的hint文件一样：没有文件头，记录使用IEEE crc32
+	var buf []byte
+	for _, record := range records {
+		encRecord, _ := EncodeLogRecord(&LogRecord{Key: record.Key, Value: EncodeLogRecordPos(record.Pos), Type: record.Type})
+		buf = append(buf, encRecord...)
+	}
+	footer := make([]byte, hintFooterSize)
+	binary.LittleEndian.PutUint64(footer[0:8], uint64(len(records)))
+	binary.LittleEndian.PutUint64(footer[8:16], 32)
+	crc := crc32.Update(crc32.ChecksumIEEE(buf), crc32.IEEETable, footer[:16])
+	binary.LittleEndian.PutUint32(footer[16:], crc)
+	err := os.WriteFile(GetHintFileName(dir, 2), append(buf, footer...), 0644)
+	assert.Nil(t, err)
+	res, err := ReadHintFile(vfs.OS, dir, 2, 32)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), len(res))
+	for i, record := range records {
+		assert.Equal(t, record.Key, res[i].Key)
+		assert.Equal(t, *record.Pos, *res[i].Pos)
+	}
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	"encoding/binary"
-	"hash/crc32"
 )
 
 //相对于data_file.go文件，这一段更多倾向于对内存中的索引结构的管理
@@ -16,7 +15,14 @@ const (
 )
 
 const (
-	maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+	//校验值最长为8字节(xxhash64)
+	MaxLogRecordHeaderSize = 8 + 1 + binary.MaxVarintLen32*2 + MaxVersionInfoSize
+
+	//编码之后最短的记录：4字节的校验值，类型、key和value的长度各1字节，以及至少1字节的key
+	MinLogRecordSize = 4 + 3 + 1
+
+	//版本信息最长的长度：时间戳 + 上一个版本的size、fid、offset以及所在文件的序号
+	MaxVersionInfoSize = binary.MaxVarintLen64*2 + binary.MaxVarintLen32*3
 )
 
 // 写入到数据文件的记录   包含键值对，已经墓碑值
@@ -25,14 +31,27 @@ type LogRecord struct {
 	Key   []byte
 	Value []byte
 	Type  LogRecordType //这是一个墓碑值，表示当前文件是否被删除
+
+	//以下是版本信息，只有带FileFlagVersioned的数据文件中的记录才会保存
+	Timestamp int64       //提交时间(unix纳秒)   旧格式的记录为0
+	Prev      *VersionPtr //同一个key上一个版本的位置，没有上一个版本时为nil
+}
+
+// 指向同一个key上一个版本的指针
+type VersionPtr struct {
+	Pos    LogRecordPos //上一个版本的位置
+	Serial uint32       //写入时上一个版本所在数据文件的序号(见file_header.go)，文件被重写之后序号改变，用来识别失效的指针
 }
 
 // LogRecord的头部信息
 type LogRecordHeader struct {
-	crc        uint32        //crc校验值
+	crc        uint64        //校验值，使用的算法由文件头决定(见checksum.go)
 	recordType LogRecordType //表示logrecord的类型
 	keySize    uint32        //key的长度
 	valueSize  uint32        //value的长度
+
+	timestamp int64       //提交时间
+	prev      *VersionPtr //上一个版本的位置
 }
 
 type LogRecordPos struct { //这个是存放在内存索引结构上的，用于指示文件位于磁盘上的哪个位置
@@ -47,42 +66,90 @@ type TransactionReocrd struct {
 	Pos    *LogRecordPos
 }
 
-// 对LogRecord进行编码，返回字符数组及长度
+// 对LogRecord进行编码，返回字符数组及长度   使用IEEE crc32，用于没有文件头的文件以及MANIFEST、检查点等元数据文件
+func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithChecksum(logRecord, ChecksumIEEE)
+}
+
+// 使用指定的校验算法对LogRecord进行编码，返回字符数组及长度   不带版本信息
+func EncodeLogRecordWithChecksum(logRecord *LogRecord, typ ChecksumType) ([]byte, int64) {
+	return encodeLogRecord(logRecord, typ, false)
+}
+
+// 按照文件头的格式对LogRecord进行编码，返回字符数组及长度   带FileFlagVersioned的文件中会写入版本信息
+func EncodeLogRecordWithHeader(logRecord *LogRecord, header *FileHeader) ([]byte, int64) {
+	return encodeLogRecord(logRecord, header.Checksum, header.Versioned())
+}
+
 // 需要将传入的logrecord添加上header信息，转化为字节数组返回。    后续会将header+kv一起放置在活跃文件中
 // 编码之后的结构：
 //
-//		+-----------+---------------+---------------+---------------+-----------+---------------+
-//		|crc 校验值	|	type类型		|	keysize		|	valuesize	|	key		|	value		|
-//		+-----------+---------------+---------------+---------------+-----------+---------------+
-//	      4字节		1字节					变长，最大为5字节			变长			变长
-func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
-	//初始化一个header信息
-	header := make([]byte, maxLogRecordHeaderSize)
-	//crc校验值是最后进行存储的，先解决后面几个字段
-
-	//从第五个字节开始存储
-	header[4] = logRecord.Type
-	var index = 5
-	//5字节之后，存储的是key和value的长度信息
-	//使用变长类型，节省空间
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key))) //使用了binary的库函数PutVarint：将整数进行变长编码并写入字节切片中
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
-	//此时header已经写完了，此时可能header总长度并没有达到maxLogRecordHeaderSize
-
-	var size = index + len(logRecord.Key) + len(logRecord.Value) //这里就表示了整个编码的长度
+//		+---------------+---------------+---------------+---------------+---------------+-----------+---------------+
+//		|checksum 校验值	|	type类型		|	keysize		|	valuesize	| 版本信息(可选)	|	key		|	value		|
+//		+---------------+---------------+---------------+---------------+---------------+-----------+---------------+
+//	      4或8字节			1字节					变长，最大为5字节			变长				变长			变长
+//
+// 版本信息依次是：timestamp、prev size，prev size不为0时后面还有prev fid、prev offset以及prev所在文件的序号
+// 先算出整条记录的长度，只分配一次内存，校验值直接在编码好的字节数组上计算
+func encodeLogRecord(logRecord *LogRecord, typ ChecksumType, versioned bool) ([]byte, int64) {
+	checksumLen := checksumSize(typ)
+	keySize, valueSize := int64(len(logRecord.Key)), int64(len(logRecord.Value))
+	headerSize := checksumLen + 1 + varintLen(keySize) + varintLen(valueSize)
+	if versioned {
+		headerSize += versionInfoLen(logRecord)
+	}
+	var size = headerSize + len(logRecord.Key) + len(logRecord.Value) //这里就表示了整个编码的长度
 	encBytes := make([]byte, size)
-	//这个encBytes将存放除了crc以外的数据
-	copy(encBytes[:index], header[:index])
+
+	//校验值之后依次是type、key和value的长度信息(变长编码，节省空间)
+	encBytes[checksumLen] = logRecord.Type
+	var index = checksumLen + 1
+	index += binary.PutVarint(encBytes[index:], keySize)
+	index += binary.PutVarint(encBytes[index:], valueSize)
+	if versioned {
+		index += binary.PutVarint(encBytes[index:], logRecord.Timestamp)
+		if prev := logRecord.Prev; prev != nil {
+			index += binary.PutVarint(encBytes[index:], int64(prev.Pos.Size))
+			index += binary.PutVarint(encBytes[index:], int64(prev.Pos.Fid))
+			index += binary.PutVarint(encBytes[index:], prev.Pos.Offset)
+			index += binary.PutVarint(encBytes[index:], int64(prev.Serial))
+		} else {
+			index += binary.PutVarint(encBytes[index:], 0)
+		}
+	}
 	copy(encBytes[index:], logRecord.Key)
 	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
 
-	//这样就将除了crc所有的内容都保存在encBytes中了，接下来就是进行crc校验码的生成
-	crc := crc32.ChecksumIEEE(encBytes[4:])
-	binary.LittleEndian.PutUint32(encBytes[:4], crc) //主流的平台(arm,x86)一般都是支持小端序，所以我们使用LittleEndian
-
+	//最后计算校验值，校验范围是校验值之后的所有内容   主流的平台(arm,x86)一般都是支持小端序，所以我们使用LittleEndian
+	putChecksum(typ, encBytes, checksum(typ, encBytes[checksumLen:]))
 	return encBytes, int64(size)
 }
 
+// 版本信息编码之后的长度
+func versionInfoLen(logRecord *LogRecord) int {
+	n := varintLen(logRecord.Timestamp)
+	prev := logRecord.Prev
+	if prev == nil {
+		return n + 1
+	}
+	n += varintLen(int64(prev.Pos.Size)) + varintLen(int64(prev.Pos.Fid)) + varintLen(prev.Pos.Offset)
+	return n + varintLen(int64(prev.Serial))
+}
+
+// 变长编码之后的长度，和binary.PutVarint一致
+func varintLen(x int64) int {
+	ux := uint64(x) << 1
+	if x < 0 {
+		ux = ^ux
+	}
+	n := 1
+	for ux >= 0x80 {
+		ux >>= 7
+		n++
+	}
+	return n
+}
+
 // 对位置信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
 	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
@@ -112,39 +179,67 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 //注意上方的encode部分和下面的decode部分，对于keysize和valuesize转化成字节流时，是作为两个元素转换进去的
 //所以下面使用Varint进行解码的时候，可以分别解码出keysize和valuesize
 
-// 从buf字节数组中解码中header的信息(仅包含header信息)   也就是从header的字节流之中提取出提取出有效的信息(header包括的信息：crc   type    keysize    valuesize)
-func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
-	if len(buf) <= 4 { //这里表示传入的buf连crc四个字节的要求都没有达到
+// 从buf字节数组中解码中header的信息(仅包含header信息)   也就是从header的字节流之中提取出提取出有效的信息(header包括的信息：checksum   type    keysize    valuesize)
+// buf中的数据不足以解码出完整的header时返回nil
+func decodeLogRecordHeader(buf []byte, typ ChecksumType) (*LogRecordHeader, int64) {
+	return decodeLogRecordHeaderWithVersion(buf, typ, false)
+}
+
+// 解码header信息，versioned为true时header中还带有版本信息
+func decodeLogRecordHeaderWithVersion(buf []byte, typ ChecksumType, versioned bool) (*LogRecordHeader, int64) {
+	checksumLen := checksumSize(typ)
+	if len(buf) <= checksumLen { //这里表示传入的buf连校验值的长度要求都没有达到
 		return nil, 0
 	}
 
 	header := &LogRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]), //这是将长度为4字节的字节切片转换为小端序的无符号32位整数
-		recordType: buf[4],                              //记录记录的类型
+		crc:        readChecksum(typ, buf),
+		recordType: buf[checksumLen], //记录记录的类型
 	}
 
-	var index = 5
+	var index = checksumLen + 1
+	readVarint := func() (int64, bool) {
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return 0, false
+		}
+		index += n
+		return v, true
+	}
 	//取出实际的key size
-	keysize, n := binary.Varint(buf[index:]) //由于在binary.PutVarint时，keysize和valuesize是分别放入的，所以这一次varint只会得到keysize的内容
+	keysize, ok := readVarint() //由于在binary.PutVarint时，keysize和valuesize是分别放入的，所以这一次varint只会得到keysize的内容
+	if !ok {
+		return nil, 0
+	}
 	header.keySize = uint32(keysize)
-	index += n
 
-	valuesize, n := binary.Varint(buf[index:]) //这一次也就只会得到valuesize的内容
+	valuesize, ok := readVarint() //这一次也就只会得到valuesize的内容
+	if !ok {
+		return nil, 0
+	}
 	header.valueSize = uint32(valuesize)
-	index += n
 
-	return header, int64(index) //将header信息返回，并且返回当前header的大小
-}
-
-// 根据输入的logrecord和header，得到crc值    这里的crc的计算是需要输出header部分以及key、value的
-func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
-	if lr == nil {
-		return 0
+	if versioned {
+		if header.timestamp, ok = readVarint(); !ok {
+			return nil, 0
+		}
+		prevSize, ok := readVarint()
+		if !ok {
+			return nil, 0
+		}
+		if prevSize != 0 {
+			prevFid, ok1 := readVarint()
+			prevOffset, ok2 := readVarint()
+			prevSerial, ok3 := readVarint()
+			if !ok1 || !ok2 || !ok3 {
+				return nil, 0
+			}
+			header.prev = &VersionPtr{
+				Pos:    LogRecordPos{Fid: uint32(prevFid), Offset: prevOffset, Size: uint32(prevSize)},
+				Serial: uint32(prevSerial),
+			}
+		}
 	}
 
-	crc := crc32.ChecksumIEEE(header[:])               //直接使用函数先计算得到kaysize和valuesize对应的crc32校验和
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Key)   // Update returns the result of adding the bytes in p to the crc.
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Value) //Update就是根据新传入的字节更新crc的值
-
-	return crc
+	return header, int64(index) //将header信息返回，并且返回当前header的大小
 }
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"encoding/binary"
 	"github.com/stretchr/testify/assert"
 	"hash/crc32"
 	"testing"
@@ -42,65 +43,102 @@ func TestEncodeLogRecord(t *testing.T) {
 
 func TestDecodeLogRecord(t *testing.T) {
 	headerBuf := []byte{104, 82, 240, 150, 0, 8, 20}
-	h1, size1 := decodeLogRecordHeader(headerBuf)
+	h1, size1 := decodeLogRecordHeader(headerBuf, ChecksumIEEE)
 	//t.Log(h1)
 	//t.Log(size1)
 	assert.NotNil(t, h1)
 	assert.Equal(t, int64(7), size1)
-	assert.Equal(t, uint32(2532332136), h1.crc)
+	assert.Equal(t, uint64(2532332136), h1.crc)
 	assert.Equal(t, LogRecordNormal, h1.recordType)
 	assert.Equal(t, uint32(4), h1.keySize)
 	assert.Equal(t, uint32(10), h1.valueSize)
 
 	headerBuf2 := []byte{9, 252, 88, 14, 0, 8, 0}
-	h2, size2 := decodeLogRecordHeader(headerBuf2)
+	h2, size2 := decodeLogRecordHeader(headerBuf2, ChecksumIEEE)
 	//t.Log(h2, size2)
 
 	assert.NotNil(t, h2)
 	assert.Equal(t, int64(7), size2)
-	assert.Equal(t, uint32(240712713), h2.crc)
+	assert.Equal(t, uint64(240712713), h2.crc)
 	assert.Equal(t, LogRecordNormal, h2.recordType)
 	assert.Equal(t, uint32(4), h2.keySize)
 	assert.Equal(t, uint32(0), h2.valueSize)
 
 	headerBuf3 := []byte{43, 153, 86, 17, 1, 8, 20}
-	h3, size3 := decodeLogRecordHeader(headerBuf3)
+	h3, size3 := decodeLogRecordHeader(headerBuf3, ChecksumIEEE)
 	//t.Log(h3, size3) //290887979
 	assert.NotNil(t, h3)
 	assert.Equal(t, int64(7), size3)
-	assert.Equal(t, uint32(290887979), h3.crc)
+	assert.Equal(t, uint64(290887979), h3.crc)
 	assert.Equal(t, LogRecordDeleted, h3.recordType)
 	assert.Equal(t, uint32(4), h3.keySize)
 	assert.Equal(t, uint32(10), h3.valueSize)
 }
 
-func TestGetLogRecordCRC(t *testing.T) {
+func TestLogRecordChecksum(t *testing.T) {
+	//校验值在编码之后的记录上计算，结果和以前按照header、key、value分段计算的一致
 	rec1 := &LogRecord{
 		Key:   []byte("name"),
 		Value: []byte("bitcask-go"),
 		Type:  LogRecordNormal,
 	}
-	headerBuf1 := []byte{104, 82, 240, 150, 0, 8, 20}
-	crc := getLogRecordCRC(rec1, headerBuf1[crc32.Size:])
-	//t.Log(crc)
-	assert.Equal(t, uint32(2532332136), crc)
+	enc1, _ := EncodeLogRecord(rec1)
+	assert.Equal(t, []byte{104, 82, 240, 150, 0, 8, 20}, enc1[:7])
+	assert.Equal(t, uint64(2532332136), checksum(ChecksumIEEE, enc1[crc32.Size:]))
 
 	rec2 := &LogRecord{
 		Key:  []byte("name"),
 		Type: LogRecordNormal,
 	}
-	headerBuf2 := []byte{9, 252, 88, 14, 0, 8, 0}
-	crc2 := getLogRecordCRC(rec2, headerBuf2[crc32.Size:])
-	//t.Log(crc2)
-	assert.Equal(t, uint32(240712713), crc2)
+	enc2, _ := EncodeLogRecord(rec2)
+	assert.Equal(t, []byte{9, 252, 88, 14, 0, 8, 0}, enc2[:7])
+	assert.Equal(t, uint64(240712713), checksum(ChecksumIEEE, enc2[crc32.Size:]))
 
 	rec3 := &LogRecord{
 		Key:   []byte("name"),
 		Value: []byte("bitcask-go"),
 		Type:  LogRecordDeleted,
 	}
-	headerBuf3 := []byte{43, 153, 86, 17, 1, 8, 20}
-	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
-	//t.Log(crc2)
-	assert.Equal(t, uint32(290887979), crc3)
+	enc3, _ := EncodeLogRecord(rec3)
+	assert.Equal(t, []byte{43, 153, 86, 17, 1, 8, 20}, enc3[:7])
+	assert.Equal(t, uint64(290887979), checksum(ChecksumIEEE, enc3[crc32.Size:]))
+}
+
+func TestEncodeLogRecordWithChecksum(t *testing.T) {
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask-go"),
+		Type:  LogRecordNormal,
+	}
+	for _, typ := range []ChecksumType{ChecksumCRC32C, ChecksumIEEE, ChecksumXXHash64} {
+		enc, size := EncodeLogRecordWithChecksum(rec, typ)
+		assert.Equal(t, int64(len(enc)), size)
+		assert.Equal(t, int64(checksumSize(typ)+3+len(rec.Key)+len(rec.Value)), size)
+
+		res, n, err := decodeLogRecord(enc, typ)
+		assert.Nil(t, err)
+		assert.Equal(t, size, n)
+		assert.Equal(t, rec.Key, res.Key)
+		assert.Equal(t, rec.Value, res.Value)
+		assert.Equal(t, rec.Type, res.Type)
+
+		//任何一个字节被修改都能检测出来
+		for i := range enc {
+			corrupted := append([]byte(nil), enc...)
+			corrupted[i] ^= 0x01
+			_, _, err := decodeLogRecord(corrupted, typ)
+			assert.Equal(t, ErrInvalidCRC, err, "checksum %d, byte %d", typ, i)
+		}
+	}
+
+	//不同的算法得到的编码不能混用
+	enc, _ := EncodeLogRecordWithChecksum(rec, ChecksumCRC32C)
+	_, _, err := decodeLogRecord(enc, ChecksumIEEE)
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	//变长编码的长度和binary.PutVarint一致
+	for _, x := range []int64{0, 1, -1, 63, 64, -64, -65, 1 << 20, -(1 << 20), 1<<31 - 1, -(1 << 31)} {
+		buf := make([]byte, binary.MaxVarintLen64)
+		assert.Equal(t, binary.PutVarint(buf, x), varintLen(x), "%d", x)
+	}
 }
@@ -0,0 +1,168 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 多个数据目录
+// 数据文件可以分散放在DirPath以及Option.DataDirs配置的多个目录中(例如不同的磁盘)，新建数据文件时按照DataDirPlacement选择目录
+// 文件id到目录的对应关系不单独保存，启动时扫描所有的数据目录恢复出来
+// 文件锁、seq-no、hint文件、检查点等只保存在DirPath(主目录)中
+// 冷数据目录(Option.ColdDir)中的数据文件同样在启动时扫描得到，见tiering.go
+
+// 初始化所有的数据目录，第一个是主目录
+func (db *DB) initDataDirs() error {
+	db.dataDirs = []string{db.options.DirPath}
+	db.fileDirs = make(map[uint32]string)
+	db.compressedFiles = make(map[uint32]bool)
+	for _, dir := range db.options.DataDirs {
+		var exist bool
+		for _, d := range db.dataDirs {
+			if filepath.Clean(d) == filepath.Clean(dir) {
+				exist = true
+				break
+			}
+		}
+		if exist {
+			continue
+		}
+		if err := db.fs.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		db.dataDirs = append(db.dataDirs, dir)
+	}
+
+	if db.options.ColdDir != "" {
+		if err := db.fs.MkdirAll(db.options.ColdDir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 数据文件所在的目录
+func (db *DB) dataFileDir(fileId uint32) string {
+	if dir, ok := db.fileDirs[fileId]; ok {
+		return dir
+	}
+	return db.options.DirPath
+}
+
+// 为新建的数据文件选择目录   在访问此方法前必须持有互斥锁
+func (db *DB) pickDataDir(fileId uint32) string {
+	if len(db.dataDirs) == 1 {
+		return db.dataDirs[0]
+	}
+	dir := db.dataDirs[int(fileId)%len(db.dataDirs)]
+	if db.options.DataDirPlacement == PlaceByFreeSpace && db.fs == vfs.OS {
+		var maxAvailable uint64
+		for _, d := range db.dataDirs {
+			available, err := availableDiskSize(d)
+			if err != nil { //获取不到剩余空间的时候退回到轮流放置
+				return db.dataDirs[int(fileId)%len(db.dataDirs)]
+			}
+			if available > maxAvailable {
+				dir, maxAvailable = d, available
+			}
+		}
+	}
+	return dir
+}
+
+// 扫描所有的数据目录和冷数据目录，得到每个数据文件所在的目录，以及哪些文件是压缩过的
+func (db *DB) scanDataFiles() (map[uint32]string, map[uint32]bool, error) {
+	fileDirs := make(map[uint32]string)
+	compressed := make(map[uint32]bool)
+	for _, dir := range db.allDataDirs() {
+		dirEntries, err := db.fs.ReadDir(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range dirEntries {
+			name := entry.Name()
+			isCompressed := strings.HasSuffix(name, data.DataFileNameSuffix+data.CompressedFileSuffix)
+			if entry.IsDir() || !(isCompressed || strings.HasSuffix(name, data.DataFileNameSuffix)) {
+				continue
+			}
+			//文件名命令格式：000000000.data    取名称前面的部分作为我们文件的id
+			fileId, ok := parseDataFileId(strings.TrimSuffix(name, data.CompressedFileSuffix))
+			if !ok {
+				return nil, nil, ErrDatatDirectoryCorrupted
+			}
+			//同一个文件id只能出现在一个数据目录中
+			if _, ok := fileDirs[fileId]; ok {
+				//移动到冷数据目录的过程中崩溃了，源文件还没有删除，以数据目录中的文件为准
+				if dir == db.options.ColdDir {
+					if err := db.fs.Remove(filepath.Join(dir, entry.Name())); err != nil {
+						return nil, nil, err
+					}
+					continue
+				}
+				return nil, nil, ErrDatatDirectoryCorrupted
+			}
+			fileDirs[fileId] = dir
+			if isCompressed {
+				compressed[fileId] = true
+			}
+		}
+	}
+	return fileDirs, compressed, nil
+}
+
+// 从数据文件的文件名中解析出文件id，不是数据文件时返回false
+func parseDataFileId(name string) (uint32, bool) {
+	if !strings.HasSuffix(name, data.DataFileNameSuffix) {
+		return 0, false
+	}
+	fileId, err := strconv.ParseUint(strings.TrimSuffix(name, data.DataFileNameSuffix), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(fileId), true
+}
+
+// 所有可能存放数据文件的目录，包括冷数据目录
+func (db *DB) allDataDirs() []string {
+	if db.options.ColdDir == "" {
+		return db.dataDirs
+	}
+	return append(append([]string{}, db.dataDirs...), db.options.ColdDir)
+}
+
+// 持久化目录中文件的创建、删除和重命名   不存在的目录直接跳过
+func (db *DB) syncDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := db.fs.SyncDir(dir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 所有数据目录占用的空间
+func (db *DB) dirSize() (int64, error) {
+	var size int64
+	for _, dir := range db.allDataDirs() {
+		dirSize, err := utils.DirSize(db.fs, dir)
+		if err != nil {
+			return 0, err
+		}
+		size += dirSize
+	}
+	return size, nil
+}
+
+// 额外的数据目录对应的merge目录，merge生成的数据文件会分散放在这些目录中，和数据目录在同一个文件系统中才能直接重命名
+func (db *DB) extraMergeDirs() []string {
+	var dirs []string
+	for _, dir := range db.dataDirs[1:] {
+		dirs = append(dirs, filepath.Join(dir, mergeDirName))
+	}
+	return dirs
+}
@@ -0,0 +1,189 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_DataDirs(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-dirs")
+	dir1, _ := os.MkdirTemp("", "bitcask-go-data-dirs-1")
+	dir2, _ := os.MkdirTemp("", "bitcask-go-data-dirs-2")
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+	opts.DirPath = dir
+	opts.DataDirs = []string{dir1, dir2, dir} //重复的主目录会被忽略
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{dir, dir1, dir2}, db.dataDirs)
+
+	values := make(map[int][]byte)
+	for version := 0; version < 2; version++ {
+		for i := 0; i < 1000; i++ {
+			values[i] = utils.RandomValue(64)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+	}
+	//数据文件按照文件id轮流放在各个目录中
+	activeFileId := db.activeFile.FileId
+	assert.True(t, activeFileId > 3)
+	dirs := []string{dir, dir1, dir2}
+	for fid := uint32(0); fid <= activeFileId; fid++ {
+		_, err := os.Stat(data.GetDataFileName(dirs[fid%3], fid))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	//文件锁、MANIFEST、hint文件只在主目录中
+	for _, d := range []string{dir1, dir2} {
+		entries, err := os.ReadDir(d)
+		assert.Nil(t, err)
+		for _, entry := range entries {
+			assert.Equal(t, data.DataFileNameSuffix, filepath.Ext(entry.Name()))
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, data.ManifestFileName))
+	assert.Nil(t, err)
+
+	//重新打开之后恢复文件id和目录的对应关系
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	for fid := uint32(0); fid <= activeFileId; fid++ {
+		assert.Equal(t, dirs[fid%3], db.dataFileDir(fid))
+	}
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	//merge生成的数据文件同样分散在各个目录中
+	assert.Nil(t, db.Merge())
+	for _, d := range dirs {
+		files, _ := filepath.Glob(filepath.Join(d, "*"+data.DataFileNameSuffix))
+		assert.True(t, len(files) > 0)
+		_, err := os.Stat(filepath.Join(d, mergeDirName))
+		assert.True(t, os.IsNotExist(err))
+	}
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	//备份包含所有目录中的数据文件
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-data-dirs-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.BackUp(backupDir))
+	assert.Nil(t, db.Close())
+
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), db.index.Size())
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	backupOpts := DefaultOptioins
+	backupOpts.DirPath = backupDir
+	backupDB, err := OpenDB(backupOpts)
+	assert.Nil(t, err)
+	for i, value := range values {
+		val, err := backupDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Nil(t, backupDB.Close())
+}
+
+func TestDB_DataDirs_FreeSpace(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-dirs")
+	dir1, _ := os.MkdirTemp("", "bitcask-go-data-dirs-1")
+	defer os.RemoveAll(dir1)
+	//模拟dir1所在的磁盘剩余空间更多
+	availableDiskSize = func(dirPath string) (uint64, error) {
+		if dirPath == dir1 {
+			return 20 * 1024 * 1024, nil
+		}
+		return 10 * 1024 * 1024, nil
+	}
+	defer func() { availableDiskSize = utils.AvailableDiskSize }()
+
+	opts.DirPath = dir
+	opts.DataDirs = []string{dir1}
+	opts.DataDirPlacement = PlaceByFreeSpace
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for fid := uint32(0); fid <= db.activeFile.FileId; fid++ {
+		assert.Equal(t, dir1, db.dataFileDir(fid))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+	assert.Equal(t, 0, len(files))
+}
+
+// merge生成的文件轮流放在各个数据目录中，每个目录所在的磁盘都需要容纳分到这个目录的数据
+func TestDB_DataDirs_MergeSpace(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-dirs")
+	dir1, _ := os.MkdirTemp("", "bitcask-go-data-dirs-1")
+	defer os.RemoveAll(dir1)
+	var available1 uint64 = 1024
+	availableDiskSize = func(dirPath string) (uint64, error) {
+		if dirPath == dir1 {
+			return available1, nil
+		}
+		return 100 * 1024 * 1024, nil
+	}
+	defer func() { availableDiskSize = utils.AvailableDiskSize }()
+
+	opts.DirPath = dir
+	opts.DataDirs = []string{dir1}
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	//DirPath所在的磁盘空间足够，但是dir1中的merge目录放不下
+	assert.Equal(t, ErrNoEnoughSpaceForMerge, db.Merge())
+	available1 = 100 * 1024 * 1024
+	assert.Nil(t, db.Merge())
+}
+
+// 同一个文件id出现在多个目录中
+func TestDB_DataDirs_Duplicate(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-dirs")
+	dir1, _ := os.MkdirTemp("", "bitcask-go-data-dirs-1")
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(dir1)
+	opts.DirPath = dir
+	opts.DataDirs = []string{dir1}
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir1, 0), nil, 0644))
+	_, err = OpenDB(opts)
+	assert.Equal(t, ErrDatatDirectoryCorrupted, err)
+}
@@ -5,16 +5,16 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bitcask-go/vfs"
 	"errors"
 	"fmt"
-	"github.com/gofrs/flock"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -26,25 +26,58 @@ const (
 type DB struct {
 	options         Option //初始化数据库的一些配置
 	mu              *sync.RWMutex
-	fileIds         []int                     //文件id(已排序)，只能在加载索引的时候使用，不能在其他地方更新或者修改
-	activeFile      *data.DataFile            //当前活跃文件，保存着索引信息。可以用于写入append   里面有文件id，有文件偏移，有io_manager(用于向磁盘中进行操作的read、write、sync、close)
-	olderFile       map[uint32]*data.DataFile //旧数据文件，只能用于读      在这里activeFile和olderFile文件的编号FileId 都是由DirPath目录下.data文件的编号决定的
-	index           index.Indexer             //数据内存索引   对索引进行操作的
-	seqNo           uint64                    //事务序列号 全局递增   针对writebatch的   在batch.go中，commit操作时会进行递增的
-	isMerging       bool                      //是否正在进行merge操作
-	seqNoFileExists bool                      //存储事务序列号的文件是否存在
-	isInitial       bool                      //是否第一次初始化此数据目录
-	fileLock        *flock.Flock              //文件锁保证多进程之间的互斥(保证当前只有一个存储引擎打开数据目录)
-	bytesWrite      uint                      //标识当前已经写了多少个字节   与配置项中bytespersync互帮互助
-	reclaimSize     int64                     //表示有多少数据是无效的
+	fileIds         []int                           //文件id(已排序)，只能在加载索引的时候使用，不能在其他地方更新或者修改
+	activeFile      *data.DataFile                  //当前活跃文件，保存着索引信息。可以用于写入append   里面有文件id，有文件偏移，有io_manager(用于向磁盘中进行操作的read、write、sync、close)
+	olderFile       map[uint32]*data.DataFile       //旧数据文件，只能用于读      在这里activeFile和olderFile文件的编号FileId 都是由DirPath目录下.data文件的编号决定的
+	index           index.Indexer                   //数据内存索引   对索引进行操作的
+	seqNo           uint64                          //事务序列号 全局递增   针对writebatch的   在batch.go中，commit操作时会进行递增的
+	isMerging       bool                            //是否正在进行merge操作
+	seqNoFileExists bool                            //存储事务序列号的文件是否存在
+	isInitial       bool                            //是否第一次初始化此数据目录
+	fileLock        io.Closer                       //文件锁保证多进程之间的互斥(保证当前只有一个存储引擎打开数据目录)
+	fs              vfs.FS                          //数据目录所在的文件系统，所有的文件操作都通过它进行
+	bytesWrite      uint                            //标识当前已经写了多少个字节   与配置项中bytespersync互帮互助
+	checkpointLock  *sync.Mutex                     //保证同一时刻只有一个goroutine在写检查点文件
+	hintWg          *sync.WaitGroup                 //等待后台生成hint文件的任务结束
+	checkpointStop  chan struct{}                   //通知后台检查点任务退出
+	checkpointDone  chan struct{}                   //后台检查点任务已经退出
+	diskGuard       diskGuard                       //磁盘剩余空间的检查状态，见disk.go
+	dataSize        int64                           //所有数据文件的总大小，用于MaxDiskSize配额
+	mergeGeneration uint64                          //merge生效的次数，每次生效之后旧的位置信息都会失效
+	quotaMergeLock  *sync.Mutex                     //保证配额不足时只有一个写入在自动merge
+	dataDirs        []string                        //所有的数据目录，第一个是主目录DirPath
+	fileDirs        map[uint32]string               //每个数据文件所在的数据目录
+	liveSize        map[uint32]int64                //每个数据文件中仍然被内存索引引用的数据量，见filestat.go
+	mergeStat       MergeStat                       //最近一次merge的进度和结果
+	manifest        *manifest                       //最近一次写入的MANIFEST，旧的数据目录在启动完成之前为nil，见manifest.go
+	mergeStatLock   *sync.Mutex                     //保护mergeStat，merge过程中不持有db.mu也可以更新
+	compressedFiles map[uint32]bool                 //冷数据目录中压缩过的数据文件
+	tierLock        *sync.Mutex                     //移动冷数据文件和merge不能同时进行
+	tierWg          *sync.WaitGroup                 //等待后台移动冷数据文件的任务结束
+	tierRunning     int32                           //后台是否有正在移动冷数据文件的任务
+	tombstones      map[string]*data.LogRecordPos   //被删除的key最新的墓碑值的位置，用于读取删除之前的版本，见version.go
+	versionRemap    map[versionRef]*data.VersionPtr //被merge移动过的历史版本现在的位置
+	fileSerial      uint32                          //最近分配的数据文件序号
+	minFileId       uint32                          //新建的数据文件最小的id，merge开始时用来给merge生成的文件预留id
+	lastTimestamp   int64                           //最近一次提交的时间
+	attachTimes     map[uint32]int64                //批量导入挂载的数据文件的提交时间，见bulkload.go
+	bulkLoadSeq     uint64                          //批量导入的临时目录的编号
+	importLock      *sync.Mutex                     //同一时刻只有一个导入，导入的进度保存在同一个文件中
+	exportPins      int32                           //正在进行的导出的数量，导出期间merge不能重写数据文件，见export.go
+	memFS           *vfs.MemFS                      //内存模式下为这个实例单独创建的内存文件系统，关闭时释放
 }
 
 // Stat 存储引擎统计信息
 type Stat struct {
-	KeyNum          uint  //存储引擎中key的总数量
-	DataFileNum     uint  //数据文件总数量
-	ReclaimableSize int64 //可以进行merge回收的数据量，字节为单位
-	DiskSize        int64 //数据目录所占磁盘空间的大小
+	KeyNum            uint      //存储引擎中key的总数量
+	DataFileNum       uint      //数据文件总数量
+	ReclaimableSize   int64     //可以进行merge回收的数据量，字节为单位
+	DiskSize          int64     //数据目录所占磁盘空间的大小，获取失败时为0
+	AvailableDiskSize uint64    //数据目录所在磁盘剩余的可用空间，数据不在操作系统的文件系统中或者当前平台不支持时为0
+	DataSize          int64     //所有数据文件的总大小，也就是MaxDiskSize配额的用量
+	MaxDiskSize       int64     //数据文件总大小的配额，为0表示不限制
+	MaxKeys           uint      //key数量的配额，用量就是KeyNum，为0表示不限制
+	Merge             MergeStat //最近一次merge的进度和结果
 }
 
 // 定义一个打开bitcask存储引擎实例的方法
@@ -56,25 +89,37 @@ func OpenDB(options Option) (*DB, error) {
 
 	var isInitial bool //这个参数表示是否是第一次初始化
 
+	//确定数据目录所在的文件系统   merge时打开的临时实例会沿用同一个文件系统
+	//内存模式下每个实例使用单独的内存文件系统，不同实例之间即使DirPath相同也互不影响
+	var memFS *vfs.MemFS
+	if options.FS == nil {
+		if options.InMemory {
+			memFS = vfs.NewMemFS()
+			options.FS = memFS
+		} else {
+			options.FS = vfs.OS
+		}
+	}
+	fs := options.FS
+
 	//对用户传递进来的目录进行校验     如果目录不存在就创建这个目录    该目录就是存放.data文件的地方
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+	if _, err := fs.Stat(options.DirPath); os.IsNotExist(err) {
 		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil { //os.ModePerm为0777，表示最大的读写权限
+		if err := fs.MkdirAll(options.DirPath, os.ModePerm); err != nil { //os.ModePerm为0777，表示最大的读写权限
 			return nil, err
 		}
 	}
 
-	//判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName)) //初始化一个文件锁
-	hold, err := fileLock.TryLock()                                     //尝试获取这把锁
+	//判断当前数据目录是否正在使用   尝试获取这把锁，没有获得就表示当前文件夹被其他进程打开了，直接返回错误类型就好
+	fileLock, err := fs.Lock(filepath.Join(options.DirPath, fileLockName))
+	if err == vfs.ErrLocked {
+		return nil, ErrDatabaseIsUsing
+	}
 	if err != nil {
 		return nil, err
 	}
-	if !hold { //这个布尔值就表示是否获得了锁，没有获得就表示当前文件夹被其他进程打开了，直接返回错误类型就好
-		return nil, ErrDatabaseIsUsing
-	}
 
-	entries, err := os.ReadDir(options.DirPath) //读取该目录下的所有文件
+	entries, err := fs.ReadDir(options.DirPath) //读取该目录下的所有文件
 	if err != nil {
 		return nil, err
 	}
@@ -84,37 +129,91 @@ func OpenDB(options Option) (*DB, error) {
 
 	//初始化db实例的结构体
 	db := &DB{
-		options:   options,
-		mu:        new(sync.RWMutex),
-		olderFile: make(map[uint32]*data.DataFile),
-		index:     index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites), //这里的index涉及到内存索引的一些操作
-		isInitial: isInitial,
-		fileLock:  fileLock,
+		options:        options,
+		mu:             new(sync.RWMutex),
+		olderFile:      make(map[uint32]*data.DataFile),
+		liveSize:       make(map[uint32]int64),
+		tombstones:     make(map[string]*data.LogRecordPos),
+		versionRemap:   make(map[versionRef]*data.VersionPtr),
+		attachTimes:    make(map[uint32]int64),
+		index:          index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites), //这里的index涉及到内存索引的一些操作
+		isInitial:      isInitial,
+		fileLock:       fileLock,
+		fs:             fs,
+		checkpointLock: new(sync.Mutex),
+		hintWg:         new(sync.WaitGroup),
+		quotaMergeLock: new(sync.Mutex),
+		importLock:     new(sync.Mutex),
+		tierLock:       new(sync.Mutex),
+		tierWg:         new(sync.WaitGroup),
+		mergeStatLock:  new(sync.Mutex),
+		memFS:          memFS,
+	}
+
+	//初始化所有的数据目录
+	if err := db.initDataDirs(); err != nil {
+		return nil, err
+	}
+
+	//以前没有提交的批量导入留下的临时文件
+	if err := db.fs.RemoveAll(db.getBulkLoadPath()); err != nil {
+		return nil, err
+	}
+
+	//读取MANIFEST
+	if db.manifest, err = db.readManifest(); err != nil {
+		return nil, err
 	}
 
 	//加载merge数据目录  经过这一步，就将merge临时文件中的内容都转移到原数据库的数据文件夹中了
 	if err := db.loadMergeFile(); err != nil {
 		return nil, err
 	}
+	//批量导入挂载的数据文件的提交时间
+	if db.manifest != nil {
+		for fid, t := range db.manifest.attachTimes {
+			db.attachTimes[fid] = t
+		}
+	}
 
 	//加载对应的数据文件  将磁盘上的文件加载到db实例的activeFile和olderFile中   注意activeFile和olderFile中的*data.DataFile是能够使用抽象接口IOManeger对磁盘上数据进行操作的
 	if err := db.loadDataFile(); err != nil {
 		return nil, err
 	}
+	db.loadFileSerial()
+
+	//加载被merge移动过的历史版本的位置
+	if err := db.loadVersionRemap(); err != nil {
+		return nil, err
+	}
 
 	//如果是b+树的结构，就不需要使用下面加载索引的方式了，直接从磁盘加载索引
 	if options.IndexType != BPLusTree {
-		//从hint索引文件中加载索引
-		if err := db.loadIndexFromHint(); err != nil {
-			return nil, err
+		//优先从检查点中加载索引，检查点无效的话丢弃已经加载的部分，回退到全量加载
+		var cpMeta *checkpointMeta
+		if options.IndexCheckpoint {
+			cpMeta, err = db.loadIndexFromCheckpoint()
+			if err == ErrInvalidCheckpoint {
+				db.resetIndex()
+				cpMeta = nil
+			} else if err != nil {
+				return nil, err
+			}
 		}
 
-		//从数据文件当中加载索引
-		if err := db.loadIndexerFromDataFile(); err != nil {
+		//从hint索引文件中加载索引   检查点中已经包含了hint文件中的索引
+		if cpMeta == nil {
+			if err := db.loadIndexFromHint(); err != nil {
+				return nil, err
+			}
+		}
+
+		//从数据文件当中加载索引   有检查点的话只需要回放检查点之后的数据
+		if err := db.loadIndexerFromDataFile(cpMeta); err != nil {
 			return nil, err
 		}
 
-		//充值IO类型为标准文件   因为本次课程只是用mmap进行启动加速，不涉及读
+		//启动时使用的只读mmap只是用来加速加载的，加载完成之后切换为配置的IO类型
 		if db.options.MMapAtStartup {
 			if err := db.resetIOType(); err != nil {
 				return nil, err
@@ -128,50 +227,128 @@ func OpenDB(options Option) (*DB, error) {
 		if err := db.loadSeqNo(); err != nil {
 			return nil, err
 		}
+		//没有回放数据文件，使用保存的统计信息
+		if _, err := db.loadFileStats(); err != nil {
+			return nil, err
+		}
 		if db.activeFile != nil {
 			size, err := db.activeFile.IoManager.Size()
 			if err != nil {
 				return nil, err
 			}
 			db.activeFile.WriteOff = size
+			//预分配的文件末尾是补零的空间，需要找到有效数据的末尾
+			if options.Preallocate {
+				if db.activeFile.WriteOff, err = findWriteOff(db.activeFile); err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
+	//活跃文件的末尾可能有上一次没有截断的预分配(或者对齐补零)的空间，或者崩溃时没有完整写入的记录，截断到实际写入的位置，后续的写入才能紧接着有效数据
+	//WriteOff之后还有有效记录的话说明是文件中间的数据损坏了，不修改文件，直接返回错误
+	if db.activeFile != nil {
+		size, err := db.activeFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		if size > db.activeFile.WriteOff {
+			torn, err := isTornTail(db.activeFile, db.activeFile.WriteOff, size)
+			if err != nil {
+				return nil, err
+			}
+			if !torn {
+				return nil, data.ErrInvalidCRC
+			}
+			if err := db.activeFile.IoManager.Truncate(db.activeFile.WriteOff); err != nil {
+				return nil, err
+			}
+		}
+		//空的活跃文件(例如创建之后还没有写入文件头就崩溃了)补上文件头，之后写入的数据使用新的格式
+		if db.activeFile.WriteOff == 0 {
+			if err := db.activeFile.WriteHeader(db.newDataFileHeader()); err != nil {
+				return nil, err
+			}
+		}
+		if err := db.preallocateActiveFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	//统计数据文件的总大小
+	if db.dataSize, err = db.computeDataSize(); err != nil {
+		return nil, err
+	}
+
+	//记录当前的数据文件，旧的数据目录也在这里补上MANIFEST
+	if err := db.saveManifest(); err != nil {
+		return nil, err
+	}
+
+	//启动后台定期保存检查点的任务
+	if options.IndexCheckpoint && options.CheckpointInterval > 0 && options.IndexType != BPLusTree {
+		db.startCheckpointLoop()
+	}
+
 	return db, nil
 }
 
 func (db *DB) Close() error {
 	defer func() { //在最后关闭数据库的时候，别忘了释放文件锁
-		if err := db.fileLock.Unlock(); err != nil {
+		if err := db.fileLock.Close(); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
 		}
+		//内存模式下的数据只属于这个实例，关闭之后释放
+		if db.memFS != nil {
+			db.memFS.Clear()
+		}
 	}()
+	db.stopCheckpointLoop()
+	db.tierWg.Wait()
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	//保存索引检查点，下一次启动时可以直接加载
+	if db.options.IndexCheckpoint && db.options.IndexType != BPLusTree {
+		cp, err := db.snapshotIndex()
+		if err != nil {
+			return err
+		}
+		if err := db.writeCheckpoint(cp); err != nil {
+			return err
+		}
+	}
+
 	//关闭索引
 	if err := db.index.Close(); err != nil {
 		return err
 	}
 
-	//保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath) //这是在dirpath下的"seq-no"文件中保存当前事务序列号
-	if err != nil {
+	//保存当前事务序列号   以前保存在seq-no文件中，现在记录在MANIFEST里
+	if err := db.saveManifest(); err != nil {
 		return err
 	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),                         //这个key表示当前是一个表示事务序列号的键值
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)), //这里将无符号整数db.seqNo转化为字符串表示形式
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
+
+	//等待后台生成hint文件的任务结束，再关闭数据文件
+	db.hintWg.Wait()
+
+	//保存每个数据文件的统计信息
+	if err := db.writeFileStats(); err != nil {
 		return err
 	}
-	if err := seqNoFile.Sync(); err != nil { //将当前保存事务序列号的信息保存在磁盘上
-		return err
+
+	//截断掉活跃文件中预分配的空间
+	if db.options.Preallocate {
+		if err := db.activeFile.IoManager.Truncate(db.activeFile.WriteOff); err != nil {
+			return err
+		}
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
 	}
 
 	//关闭当前的活跃文件
@@ -206,16 +383,32 @@ func (db *DB) Stat() *Stat {
 	if db.activeFile != nil {
 		dataFiles += 1
 	}
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	//统计信息只用于观察，获取不到磁盘空间时记为0，不影响其他的统计项
+	dirSize, err := db.dirSize()
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size : &v", err))
+		dirSize = 0
+	}
+	var availableSize uint64
+	if db.fs == vfs.OS {
+		if availableSize, err = availableDiskSize(db.options.DirPath); err != nil {
+			availableSize = 0
+		}
 	}
 
+	db.mergeStatLock.Lock()
+	mergeStat := db.mergeStat
+	db.mergeStatLock.Unlock()
+
 	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+		KeyNum:            uint(db.index.Size()),
+		DataFileNum:       dataFiles,
+		ReclaimableSize:   db.reclaimableSize(),
+		DiskSize:          dirSize,
+		AvailableDiskSize: availableSize,
+		DataSize:          db.dataSize,
+		MaxDiskSize:       db.options.MaxDiskSize,
+		MaxKeys:           db.options.MaxKeys,
+		Merge:             mergeStat,
 	}
 }
 
@@ -223,7 +416,24 @@ func (db *DB) Stat() *Stat {
 func (db *DB) BackUp(dir string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	//merge目录可能是数据目录的子目录，不需要拷贝
+	exclude := []string{fileLockName, "*.tmp", filepath.Base(db.getMergePath())}
+	if err := utils.CopyDir(db.fs, db.options.DirPath, dir, exclude); err != nil {
+		return err
+	}
+	//其他数据目录中的数据文件也拷贝到同一个备份目录中
+	for _, dataDir := range db.dataDirs[1:] {
+		if err := utils.CopyDir(db.fs, dataDir, dir, []string{mergeDirName, "*.tmp"}); err != nil {
+			return err
+		}
+	}
+	//冷数据目录中的文件同样拷贝到备份目录中，压缩过的文件保持压缩的状态
+	if db.options.ColdDir != "" {
+		if err := utils.CopyDir(db.fs, db.options.ColdDir, dir, []string{"*.tmp"}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // 向DB的activaFile中append写入key/value数据，key不能为空
@@ -236,23 +446,17 @@ func (db *DB) Put(key []byte, value []byte) error {
 
 	//构造logRecord结构体
 	logRecord := &data.LogRecord{
-		logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		value,
-		data.LogRecordNormal,
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: value,
+		Type:  data.LogRecordNormal,
 	}
 
-	//追加写入到当前的活跃数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
-	if err != nil {
-		return err
-	}
-
-	//程序运行到这里就能拿到我们的索引信息
-	//更新内存索引		内存索引更新之后，写数据流程就完成了
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	//追加写入到当前的活跃数据文件中，并更新内存索引   超出配额时可能需要等待自动merge回收空间之后重试
+	err := db.appendLogRecordWithLock(key, logRecord)
+	if db.reclaimForQuota(err) {
+		err = db.appendLogRecordWithLock(key, logRecord)
 	}
-	return nil
+	return err
 }
 
 // 根据key删除对应的数据    主要添加的逻辑就是要判断希望删除的key是否有效，不然免得无关的数据文件膨胀
@@ -274,22 +478,8 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDeleted,
 	}
 
-	//写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
-	if err != nil {
-		return err
-	}
-	db.reclaimSize += int64(pos.Size)
-
-	//然后在对应的内存索引当中将其删除掉    在内存索引的操作还是比较好实现的
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
-		return ErrIndexUpdataFailed
-	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
-	return nil
+	//写入到数据文件中，然后在对应的内存索引当中将其删除掉
+	return db.appendLogRecordWithLock(key, logRecord)
 }
 
 // 根据key读取数据，这一步的逻辑比较好实现
@@ -315,15 +505,20 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 }
 
 // 获取数据库中所有的key   这里的listkeys有问题啊，不能成功将所有key列出来
-func (db *DB) ListKeys() [][]byte {
+// 索引不支持遍历(UnorderedHash)时返回ErrIteratorUnsupported
+func (db *DB) ListKeys() ([][]byte, error) {
 	//先得到迭代器
 	iterator := db.index.Iterator(false)
+	if iterator == nil { //当前索引不支持遍历
+		return nil, ErrIteratorUnsupported
+	}
+	defer iterator.Close()
 	keys := make([][]byte, db.index.Size())
 	var idx int
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
 		keys[idx] = iterator.Key()
 	}
-	return keys
+	return keys, nil
 }
 
 // 获取所有的数据，并执行用户指定的函数操作，函数返回false时终止遍历
@@ -332,6 +527,9 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	defer db.mu.Unlock()
 
 	iterator := db.index.Iterator(false)
+	if iterator == nil {
+		return ErrIteratorUnsupported
+	}
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
 		value, err := db.getValueByPosition(iterator.Value())
@@ -378,10 +576,44 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 //Sync持久化数据库将数据文件在缓冲区的内容刷到磁盘，保证数据不丢失
 //只需要sync当前活跃文件就行了，旧数据文件在当时代码逻辑中已经进行sync了  体现在appendLogRecord中
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+// 追加写入一条记录并更新内存索引   两步在同一把锁中完成，merge修改索引中的位置时不会和写入交错
+func (db *DB) appendLogRecordWithLock(key []byte, logRecord *data.LogRecord) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+	//新增key的时候检查key数量的配额   和写入在同一把锁中，并发写入新的key时不会一起通过检查
+	if logRecord.Type == data.LogRecordNormal && db.index.Get(key) == nil {
+		if err := db.checkKeysQuota(1); err != nil {
+			return err
+		}
+	}
+	db.setVersion(key, logRecord, db.commitTimestamp())
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	if _, ok := db.updateIndex(key, logRecord.Type, pos); !ok {
+		return ErrIndexUpdataFailed
+	}
+	return nil
+}
+
+// 更新内存索引中key的位置，typ为LogRecordDeleted时从索引中删除key   返回key原来的位置，删除不存在的key时返回false
+// 同时维护每个数据文件中有效数据的大小和可以回收的数据量(被覆盖的旧数据和墓碑值本身)，以及被删除的key最新的墓碑值
+// 在访问此方法前必须持有互斥锁(启动加载索引的时候除外)
+func (db *DB) updateIndex(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) (*data.LogRecordPos, bool) {
+	var oldPos *data.LogRecordPos
+	var ok = true
+	if typ == data.LogRecordDeleted {
+		oldPos, ok = db.index.Delete(key)
+	} else {
+		oldPos = db.index.Put(key, pos)
+		db.liveSize[pos.Fid] += int64(pos.Size)
+	}
+	if oldPos != nil {
+		db.liveSize[oldPos.Fid] -= int64(oldPos.Size)
+	}
+	db.updateTombstone(key, typ, pos)
+	return oldPos, ok
 }
 
 // 追加写数据到活跃文件中
@@ -397,29 +629,35 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	//程序运行到此处，我们就有了自己的活跃文件，可以对该活跃文件添加文件了
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size := data.EncodeLogRecordWithHeader(logRecord, &db.activeFile.Header)
 
 	//在这里需要进行一个判断，如果写入的数据已经达到了活跃文件的阈值，则关闭活跃文件，并打开新的文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		//在进行文件状态转换的时候需要对当前活跃文件进行持久化，保证已有的文件被持久化到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.rotateActiveDataFile(); err != nil {
 			return nil, err
 		}
+		//新的活跃文件可能使用了不同的格式和校验算法(例如之前的活跃文件是旧格式的)，重新编码
+		encRecord, size = data.EncodeLogRecordWithHeader(logRecord, &db.activeFile.Header)
+	}
 
-		//持久化之后需要将当前的活跃文件转化为旧的活跃文件
-		db.olderFile[db.activeFile.FileId] = db.activeFile
-
-		//再打开一个新的活跃文件
-		if err := db.setActiveDataFile(); err != nil {
+	//超出配额或者磁盘剩余空间不足的时候拒绝写入   删除数据的墓碑值不受配额的限制
+	if logRecord.Type == data.LogRecordNormal {
+		if err := db.checkDiskQuota(size); err != nil {
 			return nil, err
 		}
 	}
+	if err := db.checkDiskSpace(size); err != nil {
+		return nil, err
+	}
 
 	//程序运行到这一步，也就该开始进行写入的操作了
 	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
+		//写入失败的时候文件末尾可能留下了写了一半的记录，截断掉，保证下一次写入仍然从WriteOff开始
+		_ = db.activeFile.IoManager.Truncate(writeOff)
 		return nil, err
 	}
+	db.dataSize += size
 
 	//根据用户配置决定是否进行持久化
 	db.bytesWrite += uint(size)
@@ -439,46 +677,156 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	//构造内存索引信息并返回
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff}
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
 	return pos, nil
 }
 
-// 这个函数的功能是设置活跃的数据文件(可append的)
+// 将当前的活跃文件转换为旧的数据文件，并打开一个新的活跃文件
 // 在访问此方法前必须持有互斥锁
-func (db *DB) setActiveDataFile() error {
-	var initialFileId uint32 = 0
+func (db *DB) rotateActiveDataFile() error {
+	oldFile, err := db.retireActiveFile()
+	if err != nil {
+		return err
+	}
+
+	//再打开一个新的活跃文件
+	if err := db.setActiveDataFile(); err != nil {
+		return err
+	}
+
+	//保存每个数据文件的统计信息，保存失败也没有关系，下一次启动时会重新统计
+	_ = db.writeFileStats()
+
+	//旧的数据文件不会再改变了，在后台为它生成hint文件
+	db.writeHintFileAsync(oldFile, nil, 0)
+	//检查是否有旧数据文件需要移动到冷数据目录
+	db.moveColdFilesAsync()
+	return nil
+}
+
+// 把当前的活跃文件转换为旧的数据文件，之后需要再打开一个新的活跃文件   在访问此方法前必须持有互斥锁
+func (db *DB) retireActiveFile() (*data.DataFile, error) {
+	//截断掉预分配的空间，旧文件的大小就是实际数据的大小
+	if db.options.Preallocate {
+		if err := db.activeFile.IoManager.Truncate(db.activeFile.WriteOff); err != nil {
+			return nil, err
+		}
+	}
+
+	//在进行文件状态转换的时候需要对当前活跃文件进行持久化，保证已有的文件被持久化到磁盘当中
+	if err := db.activeFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	//持久化之后需要将当前的活跃文件转化为旧的活跃文件
+	oldFile := db.activeFile
+	db.olderFile[oldFile.FileId] = oldFile
+
+	//旧文件和活跃文件使用不同的IO类型时需要切换   mmap和direct io的活跃文件末尾可能有预分配或者补齐的空间，重新打开一次，关闭的时候会截断
+	if db.options.ActiveIOType != db.options.OlderIOType || db.options.ActiveIOType != StandardIO {
+		if err := oldFile.SetIOManager(db.fs, db.dataFileDir(oldFile.FileId), fileIOType(db.options.OlderIOType)); err != nil {
+			return nil, err
+		}
+	}
+	return oldFile, nil
+}
+
+// 新建的数据文件使用的id，比所有已有的数据文件都大(批量导入的文件排在活跃文件之后)   在访问此方法前必须持有互斥锁
+func (db *DB) nextFileId() uint32 {
+	var fileId uint32 = 0
 	if db.activeFile != nil {
-		initialFileId = db.activeFile.FileId + 1 //每一个数据文件在新建的时候，id都是递增的
+		fileId = db.activeFile.FileId + 1 //每一个数据文件在新建的时候，id都是递增的
+	}
+	for fid := range db.olderFile {
+		if fid >= fileId {
+			fileId = fid + 1
+		}
+	}
+	if fileId < db.minFileId {
+		fileId = db.minFileId
 	}
+	return fileId
+}
 
-	//打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StanderdFIO) //在这里面应该注意实现的时候，writeOff也需要更新
+// 这个函数的功能是设置活跃的数据文件(可append的)
+// 在访问此方法前必须持有互斥锁
+func (db *DB) setActiveDataFile() error {
+	initialFileId := db.nextFileId()
+
+	//打开新的数据文件   按照配置的策略选择放在哪个数据目录中
+	dir := db.pickDataDir(initialFileId)
+	dataFile, err := data.OpenDataFile(db.fs, dir, initialFileId, fileIOType(db.options.ActiveIOType)) //在这里面应该注意实现的时候，writeOff也需要更新
 	if err != nil {
 		return err
 	}
+	//新的数据文件开头先写入文件头   上一次切换失败的时候可能留下了同名的文件，先清空
+	err = dataFile.IoManager.Truncate(0)
+	if err == nil {
+		err = dataFile.WriteHeader(db.newDataFileHeader())
+	}
+	if err != nil {
+		_ = dataFile.Close()
+		return err
+	}
+	//新建的文件持久化之后才能记录到MANIFEST中
+	if err := db.fs.SyncDir(dir); err != nil {
+		_ = dataFile.Close()
+		return err
+	}
+	db.fileDirs[initialFileId] = dir
+	prevFile := db.activeFile
 	db.activeFile = dataFile
-	return nil
+	//写入数据之前先把新的文件记录到MANIFEST中
+	if err := db.saveManifest(); err != nil {
+		db.activeFile = prevFile
+		_ = dataFile.Close()
+		return err
+	}
+	db.dataSize += dataFile.HeaderSize
+	db.diskGuard.probeTime = time.Time{} //活跃文件可能换到了其他的磁盘上，重新查询剩余空间
+	return db.preallocateActiveFile()
+}
+
+// 按照配置为活跃文件预分配DataFileSize大小的空间   在访问此方法前必须持有互斥锁
+func (db *DB) preallocateActiveFile() error {
+	if !db.options.Preallocate {
+		return nil
+	}
+	return db.activeFile.IoManager.Preallocate(db.options.DataFileSize)
+}
+
+// 扫描数据文件，找到有效数据的末尾   预分配的空间全部为零，读取到这里的时候返回io.EOF，崩溃时没有写完整的记录校验不通过
+func findWriteOff(dataFile *data.DataFile) (int64, error) {
+	var offset = dataFile.HeaderSize
+	for {
+		_, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || err == data.ErrInvalidCRC {
+				return offset, nil
+			}
+			return 0, err
+		}
+		offset += size
+	}
 }
 
 // 根据options中的参数从磁盘中加载对应的数据文件   加载到db实例的activeFile和olderFile中
 func (db *DB) loadDataFile() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath) //使用go提供的包，得到db.options.DirPath目录下的所有文件信息
+	//扫描所有的数据目录，只需要以.data为后缀的文件
+	fileDirs, compressed, err := db.scanDataFiles()
 	if err != nil {
 		return err
 	}
+	//只加载MANIFEST中记录的数据文件
+	if err := db.checkManifestFiles(fileDirs, compressed); err != nil {
+		return err
+	}
+	db.fileDirs = fileDirs
+	db.compressedFiles = compressed
 
-	var fileIds []int //这个数组就是用来统计db.options.DirPath中的所有id的
-	//遍历目录当中的所有文件，只需要以.data为后缀的文件
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) { //如果当前文件的文件名后缀为.data
-			//文件名命令格式：000000000.data    取名称前面的部分作为我们文件的id
-			splitName := strings.Split(entry.Name(), ".")
-			fileId, err := strconv.Atoi(splitName[0]) //这里实现的功能就是将字符串转换为整数
-			if err != nil {
-				return ErrDatatDirectoryCorrupted
-			}
-			fileIds = append(fileIds, fileId) //将读取到的文件id号append到数组中
-		}
+	var fileIds []int //这个数组就是用来统计所有数据目录中的文件id的
+	for fileId := range fileDirs {
+		fileIds = append(fileIds, int(fileId))
 	}
 	//然后对文件id进行一波排序，从小到达依次加载
 	sort.Ints(fileIds)
@@ -486,12 +834,18 @@ func (db *DB) loadDataFile() error {
 
 	//遍历每一个文件id，打开对应的数据文件
 	for i, fid := range fileIds {
-		ioType := fio.StanderdFIO
+		ioType := fileIOType(db.options.OlderIOType)
+		if i == len(fileIds)-1 {
+			ioType = fileIOType(db.options.ActiveIOType)
+		}
 		if db.options.MMapAtStartup {
 			ioType = fio.MemoryMap
 		}
+		if db.compressedFiles[uint32(fid)] {
+			ioType = fio.Compressed
+		}
 
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType) //此时得到的dataFile里面有IOManeger，能够实现对磁盘上的数据进行操作
+		dataFile, err := data.OpenDataFile(db.fs, db.dataFileDir(uint32(fid)), uint32(fid), ioType) //此时得到的dataFile里面有IOManeger，能够实现对磁盘上的数据进行操作
 		if err != nil {
 			return err
 		}
@@ -507,106 +861,108 @@ func (db *DB) loadDataFile() error {
 }
 
 // 从数据文件中加载索引
-// 遍历数据文件中的所有记录，并更新到内存索引中
-func (db *DB) loadIndexerFromDataFile() error {
+// 遍历数据文件中的所有记录，并更新到内存索引中   cpMeta不为空时，只需要回放检查点之后的记录
+// 旧数据文件如果有有效的hint文件，直接使用hint文件中的记录，不需要读取数据文件
+func (db *DB) loadIndexerFromDataFile(cpMeta *checkpointMeta) error {
 	//没有文件，说明数据库是空的，直接返回就可以了   也就是说磁盘文件里面没*.data文件
 	if len(db.fileIds) == 0 {
 		return nil
 	}
 
-	//查看是否发生过merge
+	//查看是否有以前的merge生成的hint-index文件，其中已经包含了nonMergeFileId之前所有文件的索引
 	hasMerge, nonMergeFileId := false, uint32(0)
+	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
-		if err != nil {
-			return err
+	if _, err := db.fs.Stat(hintFileName); err == nil && cpMeta == nil {
+		if _, err := db.fs.Stat(mergeFinFileName); err == nil {
+			fid, err := db.getNonMergeFileId(db.options.DirPath)
+			if err != nil {
+				return err
+			}
+			hasMerge = true
+			nonMergeFileId = fid //得到还没有进行merge操作的文件的id
 		}
-		hasMerge = true
-		nonMergeFileId = fid //得到还没有进行merge操作的文件的id
 	}
 
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) { //定义一个匿名函数，对每一段数据进行处理，如果是已经删除了，就在内存索引中删掉
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(oldPos.Size)
+		db.updateIndex(key, typ, pos)
+	}
+
+	//暂存事务数据
+	transactionRecords := make(map[uint64][]*data.TransactionReocrd)
+	var currentSeqNo = db.seqNo
+
+	//处理数据文件中的一条记录(或者hint文件中对应的记录)
+	applyRecord := func(record *data.HintRecord) {
+		//解析key，拿到事务序列号
+		realKey, seqNo := parseLogRecordKey(record.Key)
+		if seqNo == nonTransactionSeqNo {
+			//非writeBatch提交的事务，则直接更新
+			updateIndex(realKey, record.Type, record.Pos)
 		} else {
-			oldPos = db.index.Put(key, pos)
+			//事务操作，需要判断该事务是否已完成
+			if record.Type == data.LogRecordTxnFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(transactionRecords, seqNo) //清空暂存数据
+			} else {
+				//当前读到的事务数据中还没有读到最后一个
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionReocrd{
+					Record: &data.LogRecord{Key: realKey, Type: record.Type},
+					Pos:    record.Pos,
+				})
+			}
 		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+		//更新事务序列号
+		if seqNo > currentSeqNo {
+			currentSeqNo = seqNo
 		}
 	}
 
-	//暂存事务数据
-	transactionRecords := make(map[uint64][]*data.TransactionReocrd)
-	var currentSeqNo = nonTransactionSeqNo
+	//判断某个文件是否已经包含在检查点或者hint-index中了，不需要重复加载
+	skipFile := func(fileId uint32) bool {
+		//如果当前文件id比检查点覆盖到的文件id小，则说明其中的索引已经包含在检查点中了
+		if cpMeta != nil {
+			return fileId < cpMeta.fid
+		}
+		//如果当前文件id比nonMergeFileId小，则说明当前文件的索引已经在加载hint-index文件时被更新了，不需要重复更新
+		return hasMerge && fileId < nonMergeFileId
+	}
 
-	//遍历所有的文件id，处理文件中的记录
-	for i, fid := range db.fileIds {
+	//需要加载的数据文件
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIds {
 		var fileId = uint32(fid)
-		//如果当前文件id比nonMergeFileId小，则说明当前文件的索引已经在更新hint文件时被更新了，不需要重复更新
-		if hasMerge && fileId < nonMergeFileId {
+		if skipFile(fileId) {
 			continue
 		}
-
-		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileId { //当前文件是活跃文件
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFile[fileId]
+			dataFiles = append(dataFiles, db.olderFile[fileId])
 		}
+	}
 
-		//拿到对应的数据文件之后,就需要循环的处理这个文件当中的所有内容  也就是将该文件中的每一条记录都放置在内存索引中
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset) //根据这个偏移量，从dataFile中读取出logRecord项
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
-			//构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
-
-			//解析key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				//非readBatch提交的事务，则直接更新
-				updateIndex(realKey, data.LogRecordNormal, logRecordPos)
-			} else {
-				//事务操作，需要判断该事务是否已完成
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo) //清空暂存数据
-				} else {
-					//当前读到的事务数据中还没有读到最后一个
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionReocrd{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
-
-			}
-			//更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
+	//多个goroutine并行解码数据文件(或者其hint文件)，这里按照文件id的顺序依次应用到内存索引中，保证和顺序加载的语义一致
+	err := db.decodeDataFiles(dataFiles, cpMeta, func(dataFile *data.DataFile, result *loadResult) error {
+		for _, record := range result.records {
+			applyRecord(record)
+		}
 
-			//递增offset，下一次从新的位置开始读取
-			offset = offset + size
+		//旧的数据文件没有有效的hint文件，顺便为它补上一个
+		if dataFile != db.activeFile && !result.fromHint && result.startOffset == dataFile.HeaderSize {
+			db.writeHintFileAsync(dataFile, result.records, result.fileSize)
 		}
 
-		// 这里的i == len(db.fileIds)-1 表示达到了读取的磁盘文件的最后一项，我们通常将这一项设置为activaFile
 		// 如果当前是活跃文件的话，就需要对activeFile中的WriteOff进行更新   表示下一次写应该从哪里开始
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		if dataFile == db.activeFile {
+			db.activeFile.WriteOff = result.endOffset
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	//最后更新事务序列号
@@ -625,15 +981,55 @@ func checkOptions(options Option) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio,must be between 0 and 1")
 	}
+	if options.MaxDiskSize < 0 {
+		return errors.New("max disk size must not be less than 0")
+	}
+	if options.DiskHighWaterMark > 0 && options.DiskHighWaterMark < options.DiskLowWaterMark {
+		return errors.New("disk high water mark must not be less than the low water mark")
+	}
+	//冷数据目录和merge目录不能是数据目录中的一个
+	dataDirs := append([]string{options.DirPath}, options.DataDirs...)
+	for _, dir := range dataDirs {
+		if options.ColdDir != "" && filepath.Clean(dir) == filepath.Clean(options.ColdDir) {
+			return ErrInvalidColdDir
+		}
+	}
+	for _, dir := range append(dataDirs, options.ColdDir) {
+		if options.MergeDir != "" && filepath.Clean(dir) == filepath.Clean(options.MergeDir) {
+			return ErrInvalidMergeDir
+		}
+	}
+	if options.ActiveIOType > DirectIO || options.OlderIOType > DirectIO {
+		return errors.New("unsupported io type")
+	}
+	if options.Checksum > ChecksumXXHash64 {
+		return errors.New("unsupported checksum type")
+	}
+	if options.VersionRetention < 0 {
+		return errors.New("version retention must not be less than 0")
+	}
+	//检查点需要遍历索引，不支持遍历的索引无法保存检查点
+	if options.IndexCheckpoint && options.IndexType == UnorderedHash {
+		return errors.New("index checkpoint is not supported by the unordered hash index")
+	}
+	if (options.InMemory || (options.FS != nil && options.FS != vfs.OS)) && options.IndexType == BPLusTree {
+		return errors.New("b+ tree index only supports the os file system")
+	}
 	return nil
 }
 
 func (db *DB) loadSeqNo() error {
+	if db.manifest != nil {
+		db.seqNo = db.manifest.seqNo
+		db.seqNoFileExists = true
+		return nil
+	}
+	//旧的数据目录从seq-no文件中读取
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
 		return nil
 	}
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -647,18 +1043,35 @@ func (db *DB) loadSeqNo() error {
 	return nil
 }
 
-// 将数据文件的IO类型重新设置为标准文件IO
+// 将数据文件的IO类型重新设置为配置中指定的类型
 func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StanderdFIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.fs, db.dataFileDir(db.activeFile.FileId), fileIOType(db.options.ActiveIOType)); err != nil {
 		return err
 	}
 	for _, dataFile := range db.olderFile {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StanderdFIO); err != nil {
+		if db.compressedFiles[dataFile.FileId] { //压缩文件只能使用压缩文件IO
+			continue
+		}
+		if err := dataFile.SetIOManager(db.fs, db.dataFileDir(dataFile.FileId), fileIOType(db.options.OlderIOType)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// 将配置项中的IO类型转换为fio中对应的类型
+func fileIOType(ioType IOType) fio.FileIOType {
+	switch ioType {
+	case StandardIO:
+		return fio.StanderdFIO
+	case MMapIO:
+		return fio.MemoryMapRW
+	case DirectIO:
+		return fio.DirectIO
+	}
+	//配置项在checkOptions中已经检查过了
+	return fio.StanderdFIO
+}
@@ -1,13 +1,11 @@
 package bitcask_go
 
-import "os"
-
 func Destroy_DB(db *DB) {
 	if db != nil {
 		if db.activeFile != nil {
 			_ = db.Close()
 		}
-		err := os.RemoveAll(db.options.DirPath)
+		err := db.fs.RemoveAll(db.options.DirPath)
 		if err != nil {
 			panic(err)
 		}
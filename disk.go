@@ -0,0 +1,86 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"time"
+)
+
+// 磁盘空间保护
+// 磁盘剩余空间低于DiskLowWaterMark的时候拒绝所有写入，返回ErrDiskFull，之后剩余空间恢复到DiskHighWaterMark以上才重新允许写入
+// 每次写入都去查询文件系统的开销比较大，这里缓存上一次查询的结果，并扣除之后写入的数据量作为估计值
+// 只有估计值接近低水位、已经拒绝写入或者距离上一次查询超过diskProbeInterval的时候才重新查询
+
+const diskProbeInterval = time.Second
+
+// 查询磁盘剩余空间，测试的时候可以替换
+var availableDiskSize = utils.AvailableDiskSize
+
+type diskGuard struct {
+	available uint64    //上一次查询到的剩余空间
+	written   uint64    //上一次查询之后写入的数据量
+	probeTime time.Time //上一次查询的时间
+	full      bool      //磁盘空间不足，正在拒绝写入
+}
+
+// 估计当前磁盘剩余的空间
+func (g *diskGuard) estimate() uint64 {
+	if g.written >= g.available {
+		return 0
+	}
+	return g.available - g.written
+}
+
+// 重新查询磁盘剩余空间
+func (db *DB) probeDiskSpace() error {
+	//检查活跃文件所在的数据目录
+	dir := db.options.DirPath
+	if db.activeFile != nil {
+		dir = db.dataFileDir(db.activeFile.FileId)
+	}
+	available, err := availableDiskSize(dir)
+	if err != nil {
+		return err
+	}
+	db.diskGuard.available = available
+	db.diskGuard.written = 0
+	db.diskGuard.probeTime = time.Now()
+	return nil
+}
+
+// 写入size字节之前检查磁盘空间是否足够   在访问此方法前必须持有互斥锁
+func (db *DB) checkDiskSpace(size int64) error {
+	lowWaterMark := db.options.DiskLowWaterMark
+	//没有配置水位，或者数据不在操作系统的文件系统中时不需要检查
+	if lowWaterMark == 0 || db.fs != vfs.OS {
+		return nil
+	}
+	g := &db.diskGuard
+	if g.full || g.estimate() < lowWaterMark+uint64(size) || time.Since(g.probeTime) >= diskProbeInterval {
+		if err := db.probeDiskSpace(); err != nil {
+			return err
+		}
+	}
+
+	free := g.estimate()
+	if g.full {
+		if free < db.diskHighWaterMark() {
+			return ErrDiskFull
+		}
+		g.full = false
+	}
+	if free < lowWaterMark+uint64(size) {
+		g.full = true
+		return ErrDiskFull
+	}
+	g.written += uint64(size)
+	return nil
+}
+
+// 恢复写入需要的剩余空间，没有配置的时候和低水位相同
+func (db *DB) diskHighWaterMark() uint64 {
+	if db.options.DiskHighWaterMark < db.options.DiskLowWaterMark {
+		return db.options.DiskLowWaterMark
+	}
+	return db.options.DiskHighWaterMark
+}
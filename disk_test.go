@@ -0,0 +1,122 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_DiskGuard(t *testing.T) {
+	var available uint64 = 10 * 1024 * 1024
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-guard")
+	//模拟的磁盘剩余空间会随着数据目录的增长而减少   merge目录也在同一个磁盘上
+	availableDiskSize = func(dirPath string) (uint64, error) {
+		used, err := utils.DirSize(vfs.OS, dir)
+		if err != nil {
+			return 0, err
+		}
+		return available - uint64(used), nil
+	}
+	defer func() { availableDiskSize = utils.AvailableDiskSize }()
+
+	opts := DefaultOptioins
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.DiskLowWaterMark = 4 * 1024 * 1024
+	opts.DiskHighWaterMark = 6 * 1024 * 1024
+	//merge失败时会留下merge目录，关闭数据库之后一起删除
+	defer os.RemoveAll(filepath.Clean(dir) + mergeDirName)
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.True(t, db.Stat().AvailableDiskSize < available)
+	assert.True(t, db.Stat().AvailableDiskSize > available-1024)
+
+	//剩余空间低于低水位，拒绝写入   缓存的查询结果过期之后才会发现
+	available = 3 * 1024 * 1024
+	assert.Nil(t, db.Put(utils.GetTestKey(4), utils.RandomValue(24)))
+	db.diskGuard.probeTime = time.Time{}
+	assert.Equal(t, ErrDiskFull, db.Put(utils.GetTestKey(2), utils.RandomValue(24)))
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), utils.RandomValue(24)))
+	assert.Equal(t, ErrDiskFull, wb.Commit())
+
+	//恢复到低水位以上、高水位以下，仍然拒绝写入
+	available = 5 * 1024 * 1024
+	assert.Equal(t, ErrDiskFull, db.Put(utils.GetTestKey(2), utils.RandomValue(24)))
+
+	//恢复到高水位以上，重新允许写入
+	available = 7 * 1024 * 1024
+	assert.Nil(t, db.Put(utils.GetTestKey(2), utils.RandomValue(24)))
+
+	//写入的数据量会从上一次查询的结果中扣除，估计值低于低水位时重新查询
+	used, err := utils.DirSize(vfs.OS, dir)
+	assert.Nil(t, err)
+	available = opts.DiskLowWaterMark + uint64(used) + 1024
+	assert.Nil(t, db.probeDiskSpace())
+	for i := 0; i < 100; i++ {
+		if err := db.Put(utils.GetTestKey(i), utils.RandomValue(24)); err != nil {
+			assert.Equal(t, ErrDiskFull, err)
+			assert.True(t, i > 0)
+			break
+		}
+	}
+	assert.True(t, db.diskGuard.full)
+
+	//merge之后的数据加上低水位超过了剩余空间，拒绝merge
+	used, err = utils.DirSize(vfs.OS, dir)
+	assert.Nil(t, err)
+	available = opts.DiskLowWaterMark + uint64(used) + 16
+	assert.Equal(t, ErrNoEnoughSpaceForMerge, db.Merge())
+	available = 100 * 1024 * 1024
+	assert.Nil(t, db.Merge())
+}
+
+func TestDB_DiskGuard_Options(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-guard")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DiskLowWaterMark = 2048
+	opts.DiskHighWaterMark = 1024
+	_, err := OpenDB(opts)
+	assert.NotNil(t, err)
+
+	//实际的磁盘空间
+	opts.DiskHighWaterMark = 0
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.True(t, db.Stat().AvailableDiskSize > 0)
+	assert.Nil(t, db.Close())
+}
+
+// 获取不到磁盘剩余空间时统计信息中记为0，不会panic
+func TestDB_Stat_AvailableDiskSizeUnsupported(t *testing.T) {
+	availableDiskSize = func(dirPath string) (uint64, error) {
+		return 0, errors.New("available disk size is not supported on this platform")
+	}
+	defer func() { availableDiskSize = utils.AvailableDiskSize }()
+
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-guard")
+	opts.DirPath = dir
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	stat := db.Stat()
+	assert.Equal(t, uint64(0), stat.AvailableDiskSize)
+	assert.Equal(t, uint(1), stat.KeyNum)
+	assert.True(t, stat.DiskSize > 0)
+}
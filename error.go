@@ -1,17 +1,53 @@
 package bitcask_go
 
-import "errors"
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"errors"
+	"fmt"
+)
 
 // 以下定义了几种常见的错误
 var (
-	ErrKeyisEmpty              = errors.New("the key is empty")
-	ErrIndexUpdataFailed       = errors.New("failed to update index")
-	ErrKeyNotFound             = errors.New("key not found in database")
-	ErrDataFileNotFound        = errors.New("data file is not found")
-	ErrDatatDirectoryCorrupted = errors.New("the database directory maybe coorupted")
-	ErrExceedMaxBatchNum       = errors.New("exceed the max batch num")
-	ErrMergeIsProcess          = errors.New("merge is in process, try again later")
-	ErrDatabaseIsUsing         = errors.New("the database directory is used by another process")
-	ErrMergeRatioUnreached     = errors.New("the merge ratio do not reach options")
-	ErrNoEnoughSpaceForMerge   = errors.New("no enough space for merge")
+	ErrKeyisEmpty               = errors.New("the key is empty")
+	ErrIndexUpdataFailed        = errors.New("failed to update index")
+	ErrKeyNotFound              = errors.New("key not found in database")
+	ErrDataFileNotFound         = errors.New("data file is not found")
+	ErrDatatDirectoryCorrupted  = errors.New("the database directory maybe coorupted")
+	ErrExceedMaxBatchNum        = errors.New("exceed the max batch num")
+	ErrMergeIsProcess           = errors.New("merge is in process, try again later")
+	ErrDatabaseIsUsing          = errors.New("the database directory is used by another process")
+	ErrMergeRatioUnreached      = errors.New("the merge ratio do not reach options")
+	ErrNoEnoughSpaceForMerge    = errors.New("no enough space for merge")
+	ErrDiskFull                 = errors.New("the available disk space is below the low water mark")
+	ErrMaxDiskSizeExceeded      = errors.New("exceed the max disk size of the database")
+	ErrMaxKeysExceeded          = errors.New("exceed the max number of keys of the database")
+	ErrInvalidColdDir           = errors.New("the cold dir can not be one of the data dirs")
+	ErrInvalidMergeDir          = errors.New("the merge dir can not be one of the data dirs")
+	ErrMergeDirNotEmpty         = errors.New("the merge dir contains files not created by merge")
+	ErrManifestCorrupted        = errors.New("the manifest file is corrupted")
+	ErrUnsupportedFormatVersion = errors.New("the data format version is not supported")
+	ErrVersionNotRetained       = errors.New("the version at the given time is no longer retained")
+	ErrBulkLoaderClosed         = errors.New("the bulk loader is already committed or aborted")
+	ErrUnknownExportFormat      = errors.New("unknown export format")
+	ErrInvalidImportData        = errors.New("the import data is corrupted or truncated")
+	ErrImportProgressMismatch   = errors.New("the saved import progress does not match the import data")
+	ErrExportIsProcess          = errors.New("export is in process, the data files can not be rewritten, try again later")
+	ErrIteratorUnsupported      = index.ErrIteratorUnsupported
+	ErrUnsupportedFileFormat    = data.ErrUnsupportedFileFormat
 )
+
+// 超出配额时返回的错误   可以使用errors.Is和ErrMaxDiskSizeExceeded、ErrMaxKeysExceeded比较，判断超出的是哪一项配额
+type QuotaExceededError struct {
+	Quota error //超出的配额，ErrMaxDiskSizeExceeded或者ErrMaxKeysExceeded
+	Limit int64 //配置的上限
+	Usage int64 //写入之后将会达到的用量
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%v, limit: %d, usage: %d", e.Quota, e.Limit, e.Usage)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return e.Quota
+}
@@ -7,7 +7,7 @@ import (
 
 func main() {
 	opts := bitcask.DefaultOptioins
-	db, err := bitcask.OpneDB(opts)
+	db, err := bitcask.OpenDB(opts)
 	if err != nil {
 		panic(err)
 	}
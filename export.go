@@ -0,0 +1,247 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const importProgressKey = "import.progress"
+
+// 导出和导入
+// Export把数据库中所有的key/value按照指定的格式写到w中，Import从r中读取同样格式的数据写入数据库，可以用来在机器之间、不同的索引类型之间迁移数据
+// 支持三种格式(见export_format.go)：
+//   - JSON Lines：每行一个json对象{"key":...,"value":...}，不是合法utf-8文本的key、value使用base64编码，放在key_base64、value_base64字段中
+//   - CSV：第一行是表头key,value,encoding，encoding为base64时这一行的key和value都经过base64编码
+//   - 二进制：文件头"BCEX"以及版本号，之后每条记录是uvarint编码的key长度、key、value长度、value，最后以长度为0的key和记录数结尾
+//
+// 导出的是开始导出时的快照：先在读锁中拷贝下索引中所有的key以及位置，之后依次读取value；数据文件只会追加写入，导出期间的写入不会影响已经拷贝下来的位置
+// merge会重写数据文件，拷贝位置的时候通过tierLock等待正在进行的merge结束，之后通过db.exportPins固定住数据文件：
+// 导出期间merge直接返回ErrExportIsProcess，不会阻塞；冷数据文件的移动、批量导入的挂载不会改变已有记录的位置，仍然可以进行
+//
+// 导入通过WriteBatch分批原子提交，每提交一批就把已经提交的记录数保存到import-progress文件中，全部完成之后删除
+// 导入中途失败之后，设置ImportOptions.Resume重新导入同一份数据时会跳过已经提交的记录
+// 进度中还保存了已经提交的记录的sha256摘要，跳过之前先计算这些记录的摘要进行比较，不是同一份数据时返回ErrImportProgressMismatch，不会写入任何数据
+// 提交之后、保存进度之前崩溃的话，最后一批会重新写入一次，写入的值相同
+
+// Export 把数据库当前所有的数据按照format格式写到w中
+func (db *DB) Export(w io.Writer, format ExportFormat) error {
+	writer, err := newExportWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	//导出期间不能merge，拷贝下来的位置一直有效   写出数据的时候不持有tierLock，慢的w不会阻塞其他操作
+	db.tierLock.Lock()
+	db.mu.RLock()
+	keys, positions, err := db.snapshotPositions()
+	db.mu.RUnlock()
+	if err == nil {
+		atomic.AddInt32(&db.exportPins, 1)
+	}
+	db.tierLock.Unlock()
+	if err != nil {
+		return err
+	}
+	defer atomic.AddInt32(&db.exportPins, -1)
+
+	for i, key := range keys {
+		db.mu.RLock()
+		value, err := db.getValueByPosition(positions[i])
+		db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(key, value); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// 拷贝下索引中所有的key以及位置   在访问此方法前必须持有读锁
+func (db *DB) snapshotPositions() ([][]byte, []*data.LogRecordPos, error) {
+	iterator := db.index.Iterator(false)
+	if iterator == nil { //不支持遍历的索引无法导出
+		return nil, nil, ErrIteratorUnsupported
+	}
+	defer iterator.Close()
+
+	keys := make([][]byte, 0, db.index.Size())
+	positions := make([]*data.LogRecordPos, 0, db.index.Size())
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+		positions = append(positions, iterator.Value())
+	}
+	return keys, positions, nil
+}
+
+// Import 从r中读取format格式的数据写入数据库
+func (db *DB) Import(r io.Reader, format ExportFormat) error {
+	return db.ImportWithOptions(r, format, DefaultImportOptions)
+}
+
+// ImportWithOptions 从r中读取format格式的数据，按照opts分批写入数据库
+func (db *DB) ImportWithOptions(r io.Reader, format ExportFormat, opts ImportOptions) error {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = DefaultImportOptions.BatchSize
+	}
+	reader, err := newExportReader(r, format)
+	if err != nil {
+		return err
+	}
+	db.importLock.Lock()
+	defer db.importLock.Unlock()
+
+	//上一次导入已经提交的记录数，以及这些记录的摘要
+	var committed uint64
+	var digest []byte
+	if opts.Resume {
+		progress, err := db.loadImportProgress()
+		if err != nil {
+			return err
+		}
+		if progress.count > 0 && progress.format != format {
+			return ErrImportProgressMismatch
+		}
+		committed, digest = progress.count, progress.digest
+	} else if err := db.removeImportProgress(); err != nil { //重新开始的导入不能使用以前保存的进度
+		return err
+	}
+
+	resumeFrom := committed
+	var skipped uint64
+	var batched uint
+	//读到的所有记录的摘要，提交时和进度一起保存
+	recordHash := sha256.New()
+	wb := db.NewWrietBatch(WriteBatchOptions{MaxBatchNum: opts.BatchSize, SyncWrites: opts.SyncWrites})
+	commit := func() error {
+		if batched == 0 {
+			return nil
+		}
+		if err := wb.Commit(); err != nil {
+			return err
+		}
+		committed += uint64(batched)
+		batched = 0
+		wb = db.NewWrietBatch(WriteBatchOptions{MaxBatchNum: opts.BatchSize, SyncWrites: opts.SyncWrites})
+		return db.writeImportProgress(&importProgress{format: format, count: committed, digest: recordHash.Sum(nil)})
+	}
+	for {
+		key, value, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		hashImportRecord(recordHash, key, value)
+		//跳过上一次已经提交的记录   全部跳过之后检查是不是同一份数据
+		if skipped < resumeFrom {
+			skipped++
+			if skipped == resumeFrom && !bytes.Equal(recordHash.Sum(nil), digest) {
+				return ErrImportProgressMismatch
+			}
+			continue
+		}
+		if err := wb.Put(key, value); err != nil {
+			return err
+		}
+		batched++
+		if batched >= opts.BatchSize {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	//数据比上一次已经提交的还要少，不是同一份数据
+	if skipped < resumeFrom {
+		return ErrImportProgressMismatch
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	return db.removeImportProgress()
+}
+
+// 保存的导入进度
+type importProgress struct {
+	format ExportFormat //导入的格式
+	count  uint64       //已经提交的记录数
+	digest []byte       //已经提交的记录的sha256摘要
+}
+
+// 把一条记录加入到摘要中   key和value都带上长度，不同的切分方式不会得到相同的摘要
+func hashImportRecord(h hash.Hash, key, value []byte) {
+	buf := binary.AppendUvarint(nil, uint64(len(key)))
+	h.Write(buf)
+	h.Write(key)
+	buf = binary.AppendUvarint(buf[:0], uint64(len(value)))
+	h.Write(buf)
+	h.Write(value)
+}
+
+// 保存导入的进度，先写临时文件再重命名
+func (db *DB) writeImportProgress(progress *importProgress) error {
+	fileName := filepath.Join(db.options.DirPath, data.ImportProgressFileName)
+	tmpFileName := fileName + ".tmp"
+	_ = db.fs.Remove(tmpFileName)
+
+	progressFile, err := data.OpenImportProgressFile(db.fs, tmpFileName)
+	if err != nil {
+		return err
+	}
+	value := binary.AppendUvarint([]byte{progress.format}, progress.count)
+	value = append(value, progress.digest...)
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte(importProgressKey), Value: value})
+	writeErr := progressFile.Write(encRecord)
+	if writeErr == nil {
+		writeErr = progressFile.Sync()
+	}
+	if err := progressFile.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = db.fs.Remove(tmpFileName)
+		return writeErr
+	}
+	return db.fs.Rename(tmpFileName, fileName)
+}
+
+// 读取保存的导入进度   没有保存过进度时记录数为0
+func (db *DB) loadImportProgress() (*importProgress, error) {
+	fileName := filepath.Join(db.options.DirPath, data.ImportProgressFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return &importProgress{}, nil
+	}
+	progressFile, err := data.OpenImportProgressFile(db.fs, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer progressFile.Close()
+
+	logRecord, _, err := progressFile.ReadLogRecord(0)
+	if err != nil || string(logRecord.Key) != importProgressKey || len(logRecord.Value) < 2 {
+		return nil, ErrImportProgressMismatch
+	}
+	count, n := binary.Uvarint(logRecord.Value[1:])
+	if n <= 0 || len(logRecord.Value[1+n:]) != sha256.Size {
+		return nil, ErrImportProgressMismatch
+	}
+	return &importProgress{format: logRecord.Value[0], count: count, digest: logRecord.Value[1+n:]}, nil
+}
+
+// 导入完成之后删除保存的进度
+func (db *DB) removeImportProgress() error {
+	fileName := filepath.Join(db.options.DirPath, data.ImportProgressFileName)
+	if err := db.fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package bitcask_go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// 导出和导入使用的数据格式，见export.go
+
+type ExportFormat = byte
+
+const (
+	//JSON Lines，每行一个json对象
+	FormatJSONLines ExportFormat = iota
+
+	//CSV，第一行是表头
+	FormatCSV
+
+	//紧凑的二进制格式，key和value前面是uvarint编码的长度
+	FormatBinary
+)
+
+const (
+	binaryExportMagic   = "BCEX" //二进制格式的文件头
+	binaryExportVersion = 1
+)
+
+var csvExportHeader = []string{"key", "value", "encoding"}
+
+// 按照某种格式依次写入key/value
+type exportWriter interface {
+	Write(key, value []byte) error
+	Close() error //写入格式的结尾，并把缓冲区中的数据写到底层的io.Writer中
+}
+
+// 按照某种格式依次读取key/value   读取完所有的数据之后返回io.EOF
+type exportReader interface {
+	Next() (key, value []byte, err error)
+}
+
+func newExportWriter(w io.Writer, format ExportFormat) (exportWriter, error) {
+	switch format {
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		encoder := json.NewEncoder(bw)
+		encoder.SetEscapeHTML(false)
+		return &jsonExportWriter{w: bw, encoder: encoder}, nil
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvExportHeader); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{w: writer}, nil
+	case FormatBinary:
+		bw := bufio.NewWriter(w)
+		if _, err := bw.WriteString(binaryExportMagic); err != nil {
+			return nil, err
+		}
+		if err := bw.WriteByte(binaryExportVersion); err != nil {
+			return nil, err
+		}
+		return &binaryExportWriter{w: bw}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+func newExportReader(r io.Reader, format ExportFormat) (exportReader, error) {
+	switch format {
+	case FormatJSONLines:
+		return &jsonExportReader{decoder: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvExportHeader)
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil, ErrInvalidImportData
+		}
+		if err != nil {
+			return nil, csvImportError(err)
+		}
+		for i, name := range csvExportHeader {
+			if header[i] != name {
+				return nil, ErrInvalidImportData
+			}
+		}
+		return &csvExportReader{r: reader}, nil
+	case FormatBinary:
+		br := bufio.NewReader(r)
+		magic := make([]byte, len(binaryExportMagic)+1)
+		if _, err := io.ReadFull(br, magic); err != nil {
+			return nil, ErrInvalidImportData
+		}
+		if string(magic[:len(binaryExportMagic)]) != binaryExportMagic || magic[len(binaryExportMagic)] != binaryExportVersion {
+			return nil, ErrInvalidImportData
+		}
+		return &binaryExportReader{r: br}, nil
+	}
+	return nil, ErrUnknownExportFormat
+}
+
+// 可以直接作为文本写入的数据：合法的utf-8并且不包含\r(csv读取时会把\r\n转换为\n)   其他的数据使用base64编码
+func isExportText(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, '\r') < 0
+}
+
+// JSON Lines中的一行   文本使用key、value字段，二进制数据使用key_base64、value_base64字段
+type jsonExportRecord struct {
+	Key         *string `json:"key,omitempty"`
+	KeyBase64   []byte  `json:"key_base64,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	ValueBase64 []byte  `json:"value_base64,omitempty"`
+}
+
+type jsonExportWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (jw *jsonExportWriter) Write(key, value []byte) error {
+	var record jsonExportRecord
+	if isExportText(key) {
+		k := string(key)
+		record.Key = &k
+	} else {
+		record.KeyBase64 = key
+	}
+	if isExportText(value) {
+		v := string(value)
+		record.Value = &v
+	} else {
+		record.ValueBase64 = value
+	}
+	return jw.encoder.Encode(&record)
+}
+
+func (jw *jsonExportWriter) Close() error {
+	return jw.w.Flush()
+}
+
+type jsonExportReader struct {
+	decoder *json.Decoder
+}
+
+func (jr *jsonExportReader) Next() ([]byte, []byte, error) {
+	var record jsonExportRecord
+	if err := jr.decoder.Decode(&record); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case err == io.EOF:
+			return nil, nil, io.EOF
+		case err == io.ErrUnexpectedEOF, errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+			return nil, nil, ErrInvalidImportData
+		}
+		//读取失败的错误原样返回，可以继续导入
+		return nil, nil, err
+	}
+	key := record.KeyBase64
+	if record.Key != nil {
+		key = []byte(*record.Key)
+	}
+	if len(key) == 0 {
+		return nil, nil, ErrInvalidImportData
+	}
+	value := record.ValueBase64
+	if record.Value != nil {
+		value = []byte(*record.Value)
+	}
+	return key, value, nil
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvExportWriter) Write(key, value []byte) error {
+	if isExportText(key) && isExportText(value) {
+		return cw.w.Write([]string{string(key), string(value), ""})
+	}
+	return cw.w.Write([]string{
+		base64.StdEncoding.EncodeToString(key),
+		base64.StdEncoding.EncodeToString(value),
+		"base64",
+	})
+}
+
+func (cw *csvExportWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// csv格式错误说明数据损坏，读取失败的错误原样返回
+func csvImportError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return ErrInvalidImportData
+	}
+	return err
+}
+
+type csvExportReader struct {
+	r *csv.Reader
+}
+
+func (cr *csvExportReader) Next() ([]byte, []byte, error) {
+	record, err := cr.r.Read()
+	if err != nil {
+		return nil, nil, csvImportError(err)
+	}
+	var key, value []byte
+	switch record[2] {
+	case "":
+		key, value = []byte(record[0]), []byte(record[1])
+	case "base64":
+		if key, err = base64.StdEncoding.DecodeString(record[0]); err != nil {
+			return nil, nil, ErrInvalidImportData
+		}
+		if value, err = base64.StdEncoding.DecodeString(record[1]); err != nil {
+			return nil, nil, ErrInvalidImportData
+		}
+	default:
+		return nil, nil, ErrInvalidImportData
+	}
+	if len(key) == 0 {
+		return nil, nil, ErrInvalidImportData
+	}
+	return key, value, nil
+}
+
+// 二进制格式：每条记录是key的长度、key、value的长度、value，最后是一个长度为0的key以及记录数，读取时可以发现被截断的数据
+type binaryExportWriter struct {
+	w     *bufio.Writer
+	count uint64
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (bw *binaryExportWriter) Write(key, value []byte) error {
+	if err := bw.writeUvarint(uint64(len(key))); err != nil {
+		return err
+	}
+	if _, err := bw.w.Write(key); err != nil {
+		return err
+	}
+	if err := bw.writeUvarint(uint64(len(value))); err != nil {
+		return err
+	}
+	if _, err := bw.w.Write(value); err != nil {
+		return err
+	}
+	bw.count++
+	return nil
+}
+
+func (bw *binaryExportWriter) Close() error {
+	if err := bw.writeUvarint(0); err != nil {
+		return err
+	}
+	if err := bw.writeUvarint(bw.count); err != nil {
+		return err
+	}
+	return bw.w.Flush()
+}
+
+func (bw *binaryExportWriter) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(bw.buf[:], v)
+	_, err := bw.w.Write(bw.buf[:n])
+	return err
+}
+
+type binaryExportReader struct {
+	r     *bufio.Reader
+	count uint64
+	ended bool
+}
+
+func (br *binaryExportReader) Next() ([]byte, []byte, error) {
+	if br.ended {
+		return nil, nil, io.EOF
+	}
+	keySize, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return nil, nil, ErrInvalidImportData
+	}
+	//长度为0的key是结尾，之后是记录数
+	if keySize == 0 {
+		count, err := binary.ReadUvarint(br.r)
+		if err != nil || count != br.count {
+			return nil, nil, ErrInvalidImportData
+		}
+		if _, err := br.r.ReadByte(); err != io.EOF {
+			return nil, nil, ErrInvalidImportData
+		}
+		br.ended = true
+		return nil, nil, io.EOF
+	}
+	key, err := br.readField(keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	valueSize, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return nil, nil, ErrInvalidImportData
+	}
+	value, err := br.readField(valueSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	br.count++
+	return key, value, nil
+}
+
+// 读取size字节   数据损坏时size可能非常大，边读边分配内存
+func (br *binaryExportReader) readField(size uint64) ([]byte, error) {
+	//记录中key和value的长度都是uint32
+	if size > math.MaxUint32 {
+		return nil, ErrInvalidImportData
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, br.r, int64(size)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrInvalidImportData
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
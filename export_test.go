@@ -0,0 +1,324 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 导出用的测试数据：包括二进制数据、包含\r\n的文本以及空的value
+func exportTestData() map[string][]byte {
+	expected := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		expected[string(utils.GetTestKey(i))] = utils.RandomValue(32)
+	}
+	expected["binary"] = []byte{0x00, 0xff, 0xfe, 0x80}
+	expected[string([]byte{0xff, 0x00, 'k'})] = []byte("binary key")
+	expected["line\r\nbreak"] = []byte("a,\"quoted\"\r\nvalue")
+	expected["utf8-中文"] = []byte("值")
+	expected["empty"] = []byte{}
+	return expected
+}
+
+func openExportTestDB(t *testing.T, indexType IndexerType) *DB {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-export")
+	opts.DirPath = dir
+	opts.IndexType = indexType
+	opts.DataFileSize = 64 * 1024
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func assertExportData(t *testing.T, db *DB, expected map[string][]byte) {
+	assert.Equal(t, len(expected), db.index.Size())
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, len(value), len(val))
+		if len(value) > 0 {
+			assert.Equal(t, value, val)
+		}
+	}
+}
+
+func TestDB_ExportImport(t *testing.T) {
+	for _, format := range []ExportFormat{FormatJSONLines, FormatCSV, FormatBinary} {
+		t.Run(fmt.Sprintf("format-%d", format), func(t *testing.T) {
+			db := openExportTestDB(t, BTree)
+			defer Destroy_DB(db)
+			expected := exportTestData()
+			for key, value := range expected {
+				assert.Nil(t, db.Put([]byte(key), value))
+			}
+			assert.Nil(t, db.Put([]byte("deleted"), []byte("value")))
+			assert.Nil(t, db.Delete([]byte("deleted")))
+
+			var buf bytes.Buffer
+			assert.Nil(t, db.Export(&buf, format))
+
+			//导入到另一个使用不同索引类型的数据库中
+			db2 := openExportTestDB(t, ART)
+			defer Destroy_DB(db2)
+			assert.Nil(t, db2.Put(utils.GetTestKey(0), []byte("old")))
+			assert.Nil(t, db2.ImportWithOptions(bytes.NewReader(buf.Bytes()), format, ImportOptions{BatchSize: 64}))
+			assertExportData(t, db2, expected)
+			_, err := os.Stat(filepath.Join(db2.options.DirPath, "import-progress"))
+			assert.True(t, os.IsNotExist(err))
+
+			//空的数据库
+			db3 := openExportTestDB(t, BTree)
+			defer Destroy_DB(db3)
+			var empty bytes.Buffer
+			assert.Nil(t, db3.Export(&empty, format))
+			assert.Nil(t, db2.Import(&empty, format))
+			assertExportData(t, db2, expected)
+		})
+	}
+
+	db := openExportTestDB(t, BTree)
+	defer Destroy_DB(db)
+	assert.Equal(t, ErrUnknownExportFormat, db.Export(io.Discard, 9))
+	assert.Equal(t, ErrUnknownExportFormat, db.Import(strings.NewReader(""), 9))
+}
+
+// 导出的是开始导出时的快照，导出期间的写入不会出现在导出的数据中
+func TestDB_Export_PointInTime(t *testing.T) {
+	db := openExportTestDB(t, BTree)
+	defer Destroy_DB(db)
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("before")))
+	}
+
+	//导出开始之后才开始写入
+	pr, pw := io.Pipe()
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = pw.CloseWithError(db.Export(pw, FormatBinary))
+	}()
+	reader, err := newExportReader(pr, FormatBinary)
+	assert.Nil(t, err)
+	key, value, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("before"), value)
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("after")))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(4999)))
+	assert.Nil(t, db.Put([]byte("new-key"), []byte("after")))
+
+	exported := map[string]bool{string(key): true}
+	for {
+		key, value, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("before"), value)
+		exported[string(key)] = true
+	}
+	wg.Wait()
+	assert.Equal(t, 5000, len(exported))
+	assert.False(t, exported["new-key"])
+}
+
+// 导出期间写出数据很慢的时候，不会阻塞冷数据文件的移动和批量导入，merge直接返回错误
+func TestDB_Export_SlowWriter(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-export")
+	coldDir, _ := os.MkdirTemp("", "bitcask-go-export-cold")
+	defer os.RemoveAll(coldDir)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ColdDir = coldDir
+	opts.ColdCompression = true
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("before")))
+	}
+
+	pr, pw := io.Pipe()
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = pw.CloseWithError(db.Export(pw, FormatBinary))
+	}()
+	reader, err := newExportReader(pr, FormatBinary)
+	assert.Nil(t, err)
+	_, _, err = reader.Next()
+	assert.Nil(t, err)
+
+	//导出卡在写出数据上
+	assert.Equal(t, ErrExportIsProcess, db.Merge())
+	assert.Equal(t, ErrExportIsProcess, db.MergeWithOptions(context.Background(), MergeOptions{}))
+	db.options.ColdFileNum = 1
+	assert.Nil(t, db.MoveColdFiles())
+	assert.True(t, len(db.compressedFiles) > 0)
+	loader, err := db.NewBulkLoader(DefaultBulkLoaderOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, loader.Put([]byte("bulk-key"), []byte("after")))
+	assert.Nil(t, loader.Commit())
+
+	count := 1
+	for {
+		_, value, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("before"), value)
+		count++
+	}
+	wg.Wait()
+	assert.Equal(t, 5000, count)
+
+	//导出结束之后可以merge
+	assert.Nil(t, db.Merge())
+	val, err := db.Get([]byte("bulk-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), val)
+}
+
+func TestDB_Import_InvalidData(t *testing.T) {
+	db := openExportTestDB(t, BTree)
+	defer Destroy_DB(db)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, db.Export(&buf, FormatBinary))
+
+	db2 := openExportTestDB(t, BTree)
+	defer Destroy_DB(db2)
+	//被截断的二进制数据
+	for _, n := range []int{0, 3, 20, buf.Len() - 1} {
+		err := db2.Import(bytes.NewReader(buf.Bytes()[:n]), FormatBinary)
+		assert.Equal(t, ErrInvalidImportData, err)
+	}
+	//结尾之后还有数据
+	err := db2.Import(bytes.NewReader(append(buf.Bytes(), 0)), FormatBinary)
+	assert.Equal(t, ErrInvalidImportData, err)
+
+	//表头不对的csv
+	err = db2.Import(strings.NewReader("k,v,e\nkey,value,\n"), FormatCSV)
+	assert.Equal(t, ErrInvalidImportData, err)
+	err = db2.Import(strings.NewReader("key,value,encoding\nkey,value,hex\n"), FormatCSV)
+	assert.Equal(t, ErrInvalidImportData, err)
+	//key为空
+	err = db2.Import(strings.NewReader(`{"value":"v"}`+"\n"), FormatJSONLines)
+	assert.Equal(t, ErrInvalidImportData, err)
+	err = db2.Import(strings.NewReader("not json\n"), FormatJSONLines)
+	assert.Equal(t, ErrInvalidImportData, err)
+}
+
+// 读取到n字节之后返回错误
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+var errReadFailed = errors.New("read failed")
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errReadFailed
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+// 导入中途失败之后继续导入，已经提交的批次不会重复写入
+func TestDB_Import_Resume(t *testing.T) {
+	db := openExportTestDB(t, BTree)
+	defer Destroy_DB(db)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, db.Export(&buf, FormatJSONLines))
+
+	db2 := openExportTestDB(t, BTree)
+	defer Destroy_DB(db2)
+	opts := ImportOptions{BatchSize: 100, SyncWrites: true}
+	err := db2.ImportWithOptions(&failingReader{r: bytes.NewReader(buf.Bytes()), n: buf.Len() / 2}, FormatJSONLines, opts)
+	assert.Equal(t, errReadFailed, err)
+	//只有完整提交的批次可见
+	committed := db2.index.Size()
+	assert.Greater(t, committed, 0)
+	assert.Equal(t, 0, committed%100)
+
+	//重启之后继续导入，进度保存在数据目录中
+	assert.Nil(t, db2.Close())
+	db2, err = OpenDB(db2.options)
+	assert.Nil(t, err)
+	//格式不同的导入不能使用保存的进度
+	opts.Resume = true
+	err = db2.ImportWithOptions(strings.NewReader("key,value,encoding\n"), FormatCSV, opts)
+	assert.Equal(t, ErrImportProgressMismatch, err)
+
+	//同样格式的另一份数据也不能使用保存的进度，不会写入任何数据
+	var other bytes.Buffer
+	otherWriter, err := newExportWriter(&other, FormatJSONLines)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, otherWriter.Write([]byte(fmt.Sprintf("other-%d", i)), []byte("other")))
+	}
+	assert.Nil(t, otherWriter.Close())
+	err = db2.ImportWithOptions(bytes.NewReader(other.Bytes()), FormatJSONLines, opts)
+	assert.Equal(t, ErrImportProgressMismatch, err)
+	assert.Equal(t, committed, db2.index.Size())
+	//数据比已经提交的还要少
+	truncated := bytes.SplitAfterN(buf.Bytes(), []byte("\n"), committed/2+1)
+	err = db2.ImportWithOptions(bytes.NewReader(bytes.Join(truncated[:committed/2], nil)), FormatJSONLines, opts)
+	assert.Equal(t, ErrImportProgressMismatch, err)
+	assert.Equal(t, committed, db2.index.Size())
+
+	//已经提交的记录被跳过：把它们改掉之后继续导入不会被覆盖
+	var skipped []byte
+	iterator := db2.NewIterator(DefaultIteratorOptions)
+	iterator.Rewind()
+	skipped = iterator.Key()
+	iterator.Close()
+	assert.Nil(t, db2.Put(skipped, []byte("changed")))
+	assert.Nil(t, db2.ImportWithOptions(bytes.NewReader(buf.Bytes()), FormatJSONLines, opts))
+	assert.Equal(t, 1000, db2.index.Size())
+	val, err := db2.Get(skipped)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("changed"), val)
+	for i := 0; i < 1000; i++ {
+		if bytes.Equal(utils.GetTestKey(i), skipped) {
+			continue
+		}
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), val)
+	}
+	_, err = os.Stat(filepath.Join(db2.options.DirPath, "import-progress"))
+	assert.True(t, os.IsNotExist(err))
+
+	//不设置Resume时从头开始导入
+	assert.Nil(t, db2.Import(bytes.NewReader(buf.Bytes()), FormatJSONLines))
+	val, err = db2.Get(skipped)
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("changed"), val)
+}
@@ -0,0 +1,221 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/vfs"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// 每个数据文件的有效数据统计
+// liveSize记录每个数据文件中仍然被内存索引引用的数据量，在Put、Delete、批量提交、启动加载索引以及merge的时候维护
+// 文件中其余的部分(被覆盖的旧数据、墓碑值、事务完成的标记等)都是可以回收的，整个数据库可以回收的数据量也由此计算，不再单独累加
+// 统计信息在转换活跃文件和关闭数据库的时候保存到file-stats文件中；内存索引在启动时重新加载的话会重新统计，
+// B+树索引本身保存在磁盘上，启动时不会回放数据文件，直接使用保存的统计信息
+
+// DataFileStat 单个数据文件的统计信息
+type DataFileStat struct {
+	FileId   uint32 //文件id
+	Size     int64  //文件中数据的大小
+	LiveSize int64  //仍然有效的数据量
+	DeadSize int64  //可以通过merge回收的数据量
+}
+
+// DataFileStats 返回所有数据文件的统计信息，按照文件id从小到大排序
+func (db *DB) DataFileStats() ([]DataFileStat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var stats []DataFileStat
+	for _, dataFile := range db.olderFile {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, db.dataFileStat(dataFile, size))
+	}
+	if db.activeFile != nil {
+		stats = append(stats, db.dataFileStat(db.activeFile, db.activeFile.WriteOff))
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].FileId < stats[j].FileId
+	})
+	return stats, nil
+}
+
+// 在访问此方法前必须持有互斥锁
+func (db *DB) dataFileStat(dataFile *data.DataFile, size int64) DataFileStat {
+	liveSize := db.liveSize[dataFile.FileId]
+	return DataFileStat{
+		FileId:   dataFile.FileId,
+		Size:     size,
+		LiveSize: liveSize,
+		DeadSize: size - dataFile.HeaderSize - liveSize, //文件头不属于可以回收的数据
+	}
+}
+
+// 无效数据占文件大小的比例
+func (s DataFileStat) garbageRatio() float32 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float32(s.DeadSize) / float32(s.Size)
+}
+
+// 所有数据文件中可以回收的数据量   在访问此方法前必须持有互斥锁
+func (db *DB) reclaimableSize() int64 {
+	var liveSize int64
+	for _, size := range db.liveSize {
+		liveSize += size
+	}
+	//文件头merge之后仍然存在，和有效数据一样不能回收
+	for _, dataFile := range db.olderFile {
+		liveSize += dataFile.HeaderSize
+	}
+	if db.activeFile != nil {
+		liveSize += db.activeFile.HeaderSize
+	}
+	if db.dataSize < liveSize {
+		return 0
+	}
+	return db.dataSize - liveSize
+}
+
+// 把每个数据文件的统计信息保存到file-stats文件中，先写临时文件再重命名   在访问此方法前必须持有互斥锁
+// 每个数据文件一条记录，key是文件id，value是文件大小和有效数据量
+func (db *DB) writeFileStats() error {
+	fileName := filepath.Join(db.options.DirPath, data.FileStatsFileName)
+	tmpFileName := fileName + ".tmp"
+	_ = db.fs.Remove(tmpFileName)
+
+	statsFile, err := data.OpenFileStatsFile(db.fs, tmpFileName)
+	if err != nil {
+		return err
+	}
+	writeErr := func() error {
+		for _, dataFile := range db.olderFile {
+			size, err := dataFile.IoManager.Size()
+			if err != nil {
+				return err
+			}
+			if err := statsFile.Write(encodeFileStat(db.dataFileStat(dataFile, size))); err != nil {
+				return err
+			}
+		}
+		if db.activeFile != nil {
+			stat := db.dataFileStat(db.activeFile, db.activeFile.WriteOff)
+			if err := statsFile.Write(encodeFileStat(stat)); err != nil {
+				return err
+			}
+		}
+		return statsFile.Sync()
+	}()
+	if err := statsFile.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = db.fs.Remove(tmpFileName)
+		return writeErr
+	}
+	return db.fs.Rename(tmpFileName, fileName)
+}
+
+// 从file-stats文件中加载每个数据文件的有效数据量   文件不存在或者已经损坏的时候返回false
+// 保存之后数据文件又写入了数据(没有正常关闭)，新写入的部分当作有效的数据
+func (db *DB) loadFileStats() (bool, error) {
+	fileName := filepath.Join(db.options.DirPath, data.FileStatsFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return false, nil
+	}
+	statsFile, err := data.OpenFileStatsFile(db.fs, fileName)
+	if err != nil {
+		return false, err
+	}
+	defer statsFile.Close()
+
+	stats := make(map[uint32]DataFileStat)
+	var offset int64 = 0
+	for {
+		logRecord, size, err := statsFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return false, nil
+		}
+		stat, ok := decodeFileStat(logRecord)
+		if !ok {
+			return false, nil
+		}
+		stats[stat.FileId] = stat
+		offset += size
+	}
+
+	liveSize := make(map[uint32]int64)
+	addFile := func(dataFile *data.DataFile, size int64) {
+		stat, ok := stats[dataFile.FileId]
+		if !ok || stat.Size > size { //文件和保存的统计信息对不上，全部当作有效的数据
+			liveSize[dataFile.FileId] = size - dataFile.HeaderSize
+			return
+		}
+		liveSize[dataFile.FileId] = stat.LiveSize + size - stat.Size
+	}
+	for _, dataFile := range db.olderFile {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return false, err
+		}
+		addFile(dataFile, size)
+	}
+	if db.activeFile != nil {
+		addFile(db.activeFile, db.activeFile.WriteOff)
+	}
+	db.liveSize = liveSize
+	return true, nil
+}
+
+// 删除file-stats文件   merge生效之后保存的统计信息就失效了
+func removeFileStats(fs vfs.FS, dirPath string) error {
+	fileName := filepath.Join(dirPath, data.FileStatsFileName)
+	if err := fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 对单个数据文件的统计信息进行编码
+func encodeFileStat(stat DataFileStat) []byte {
+	key := make([]byte, binary.MaxVarintLen32)
+	n := binary.PutUvarint(key, uint64(stat.FileId))
+	value := make([]byte, binary.MaxVarintLen64*2)
+	var index = 0
+	index += binary.PutVarint(value[index:], stat.Size)
+	index += binary.PutVarint(value[index:], stat.LiveSize)
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: key[:n], Value: value[:index]})
+	return encRecord
+}
+
+// 对单个数据文件的统计信息进行解码
+func decodeFileStat(logRecord *data.LogRecord) (DataFileStat, bool) {
+	fid, n := binary.Uvarint(logRecord.Key)
+	if n <= 0 {
+		return DataFileStat{}, false
+	}
+	size, n1 := binary.Varint(logRecord.Value)
+	if n1 <= 0 {
+		return DataFileStat{}, false
+	}
+	liveSize, n2 := binary.Varint(logRecord.Value[n1:])
+	if n2 <= 0 {
+		return DataFileStat{}, false
+	}
+	return DataFileStat{
+		FileId:   uint32(fid),
+		Size:     size,
+		LiveSize: liveSize,
+		DeadSize: size - liveSize,
+	}, true
+}
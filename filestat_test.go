@@ -0,0 +1,198 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 扫描所有的数据文件，统计每个文件中可以回收的数据量：只有内存索引指向的记录是有效的
+func scanDeadSize(t *testing.T, db *DB) map[uint32]int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	files := make([]*data.DataFile, 0, len(db.olderFile)+1)
+	for _, dataFile := range db.olderFile {
+		files = append(files, dataFile)
+	}
+	if db.activeFile != nil {
+		files = append(files, db.activeFile)
+	}
+	deadSize := make(map[uint32]int64)
+	for _, dataFile := range files {
+		deadSize[dataFile.FileId] = 0
+		var offset = dataFile.HeaderSize
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			if err != nil {
+				break
+			}
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			pos := db.index.Get(realKey)
+			live := logRecord.Type == data.LogRecordNormal && pos != nil && pos.Fid == dataFile.FileId && pos.Offset == offset
+			if !live {
+				deadSize[dataFile.FileId] += size
+			}
+			offset += size
+		}
+	}
+	return deadSize
+}
+
+func checkDeadSize(t *testing.T, db *DB) {
+	deadSize := scanDeadSize(t, db)
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Equal(t, len(deadSize), len(stats))
+	var total int64
+	for _, stat := range stats {
+		assert.Equal(t, deadSize[stat.FileId], stat.DeadSize, "file %d", stat.FileId)
+		total += deadSize[stat.FileId]
+	}
+	assert.Equal(t, total, db.Stat().ReclaimableSize)
+}
+
+func TestDB_ReclaimableSize(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-reclaim-size")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 2000; i += 2 {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 1; i < 2000; i += 4 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Delete(utils.GetTestKey(3)))
+	assert.Nil(t, wb.Commit())
+	checkDeadSize(t, db)
+	reclaimSize := db.Stat().ReclaimableSize
+	assert.True(t, reclaimSize > 0)
+
+	//旧数据文件从hint文件加载
+	db.hintWg.Wait()
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkDeadSize(t, db)
+
+	//保存的统计信息和重新统计的一致
+	db.mu.Lock()
+	liveSize := db.liveSize
+	ok, err := db.loadFileStats()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, liveSize, db.liveSize)
+	db.liveSize = liveSize
+	db.mu.Unlock()
+
+	//不使用hint文件，直接扫描数据文件
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	for _, stat := range stats {
+		assert.Nil(t, data.RemoveHintFile(db.fs, dir, stat.FileId))
+	}
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkDeadSize(t, db)
+
+	//超过一半的数据是无效的，重启之后也可以merge
+	opts.DataFileMergeRatio = 0.3
+	db.options.DataFileMergeRatio = 0.3
+	assert.Nil(t, db.Merge())
+	checkDeadSize(t, db)
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkDeadSize(t, db)
+
+	//从检查点加载
+	opts.IndexCheckpoint = true
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkDeadSize(t, db)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkDeadSize(t, db)
+}
+
+func TestDB_FileStats_Stale(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-stats")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+
+	//保存之后又写入的数据当作有效的数据
+	savedLive := make(map[uint32]int64)
+	for fid, size := range db.liveSize {
+		savedLive[fid] = size
+	}
+	savedSize := db.activeFile.WriteOff
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	db.mu.Lock()
+	liveSize := db.liveSize
+	ok, err := db.loadFileStats()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	activeFid := db.activeFile.FileId
+	for fid, size := range db.liveSize {
+		if fid == activeFid {
+			assert.Equal(t, savedLive[fid]+db.activeFile.WriteOff-savedSize, size)
+		} else {
+			assert.Equal(t, savedLive[fid], size)
+		}
+	}
+	db.liveSize = liveSize
+	db.mu.Unlock()
+
+	//损坏的文件不会被使用
+	fileName := filepath.Join(dir, data.FileStatsFileName)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	buf[len(buf)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+	db.mu.Lock()
+	ok, err = db.loadFileStats()
+	db.mu.Unlock()
+	assert.False(t, ok)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,92 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 对比标准文件IO、可读写mmap以及direct io的写入、读取性能
+
+func benchmarkWrite(b *testing.B, ioType FileIOType, syncEvery int) {
+	path := filepath.Join(b.TempDir(), "bench.data")
+	ioManager, err := NewIOManager(vfs.OS, path, ioType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ioManager.Close()
+	buf := make([]byte, 1024)
+
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ioManager.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+		if syncEvery > 0 && (i+1)%syncEvery == 0 {
+			if err := ioManager.Sync(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func benchmarkRead(b *testing.B, ioType FileIOType) {
+	path := filepath.Join(b.TempDir(), "bench.data")
+	const fileSize = 64 * 1024 * 1024
+	if err := os.WriteFile(path, make([]byte, fileSize), DataFilePerm); err != nil {
+		b.Fatal(err)
+	}
+	ioManager, err := NewIOManager(vfs.OS, path, ioType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ioManager.Close()
+	buf := make([]byte, 1024)
+
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset := int64(i*4096) % (fileSize - int64(len(buf)))
+		if _, err := ioManager.Read(buf, offset); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileIO_Write(b *testing.B) {
+	benchmarkWrite(b, StanderdFIO, 0)
+}
+
+func BenchmarkMMapRW_Write(b *testing.B) {
+	benchmarkWrite(b, MemoryMapRW, 0)
+}
+
+func BenchmarkFileIO_WriteSync(b *testing.B) {
+	benchmarkWrite(b, StanderdFIO, 1000)
+}
+
+func BenchmarkMMapRW_WriteSync(b *testing.B) {
+	benchmarkWrite(b, MemoryMapRW, 1000)
+}
+
+func BenchmarkDirectIO_Write(b *testing.B) {
+	benchmarkWrite(b, DirectIO, 0)
+}
+
+func BenchmarkDirectIO_WriteSync(b *testing.B) {
+	benchmarkWrite(b, DirectIO, 1000)
+}
+
+func BenchmarkFileIO_Read(b *testing.B) {
+	benchmarkRead(b, StanderdFIO)
+}
+
+func BenchmarkMMapRW_Read(b *testing.B) {
+	benchmarkRead(b, MemoryMapRW)
+}
+
+func BenchmarkDirectIO_Read(b *testing.B) {
+	benchmarkRead(b, DirectIO)
+}
@@ -0,0 +1,253 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var ErrCompressedFileReadOnly = errors.New("compressed data file is read only")
+
+// 压缩文件中每个块压缩前的大小
+const compressedChunkSize = 64 * 1024
+
+// 压缩之后的只读数据文件(gzip格式)，用于冷数据目录
+// 文件按照compressedChunkSize分成多个块，每个块单独压缩成一个gzip member，读取时只解压用到的块，内存中只保留最近读取的一个块
+// 文件的结构：
+//
+//	+-----------+-----------+-------+---------------------------+-------------------+
+//	| 块0		| 块1		| ...	| 每个块在文件中的位置			| 位置信息的偏移		|
+//	+-----------+-----------+-------+---------------------------+-------------------+
+//	  gzip		  gzip				  每个块8字节					  8字节
+//
+// 第一个块的gzip头的Extra字段中保存了压缩前的大小以及块的大小，打开的时候只读取gzip头和最后的位置信息
+type CompressedIO struct {
+	mu        *sync.Mutex
+	file      vfs.File
+	size      int64   //压缩前的大小
+	chunkSize int64   //每个块压缩前的大小
+	offsets   []int64 //每个块在文件中的位置，最后一个元素是最后一个块结束的位置
+	chunk     []byte  //最近读取的一个块解压之后的数据
+	chunkIdx  int     //chunk是第几个块，没有缓存时为-1
+}
+
+// 初始化压缩文件IO
+func NewCompressedIOManager(fs vfs.FS, fileName string) (*CompressedIO, error) {
+	file, err := fs.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	cio, err := openCompressedIO(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return cio, nil
+}
+
+// 读取gzip头以及每个块的位置
+func openCompressedIO(file vfs.File) (*CompressedIO, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileSize := info.Size()
+	reader, err := gzip.NewReader(io.NewSectionReader(file, 0, fileSize))
+	if err != nil {
+		return nil, err
+	}
+	//Extra字段只有压缩前的大小和块的大小两项
+	if len(reader.Extra) != 16 {
+		return nil, gzip.ErrHeader
+	}
+	cio := &CompressedIO{mu: new(sync.Mutex), file: file, chunkIdx: -1}
+	cio.size = int64(binary.BigEndian.Uint64(reader.Extra[:8]))
+	cio.chunkSize = int64(binary.BigEndian.Uint64(reader.Extra[8:]))
+	if cio.size < 0 || cio.chunkSize <= 0 || fileSize < 8 {
+		return nil, gzip.ErrHeader
+	}
+	footer := make([]byte, 8)
+	if _, err := file.ReadAt(footer, fileSize-8); err != nil {
+		return nil, err
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(footer))
+	chunkNum := compressedChunkNum(cio.size, cio.chunkSize)
+	if indexOffset < 0 || indexOffset+chunkNum*8+8 != fileSize {
+		return nil, gzip.ErrHeader
+	}
+	index := make([]byte, chunkNum*8)
+	if _, err := file.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+	for i := int64(0); i < chunkNum; i++ {
+		cio.offsets = append(cio.offsets, int64(binary.BigEndian.Uint64(index[i*8:])))
+	}
+	cio.offsets = append(cio.offsets, indexOffset)
+	return cio, nil
+}
+
+// 从文件给定位置读取对应的数据
+func (cio *CompressedIO) Read(b []byte, offset int64) (int, error) {
+	cio.mu.Lock()
+	defer cio.mu.Unlock()
+	var n int
+	for n < len(b) {
+		if offset >= cio.size {
+			return n, io.EOF
+		}
+		idx := int(offset / cio.chunkSize)
+		if err := cio.loadChunk(idx); err != nil {
+			return n, err
+		}
+		copied := copy(b[n:], cio.chunk[offset-int64(idx)*cio.chunkSize:])
+		n += copied
+		offset += int64(copied)
+	}
+	return n, nil
+}
+
+// 压缩文件是只读的
+func (cio *CompressedIO) Write([]byte) (int, error) {
+	return 0, ErrCompressedFileReadOnly
+}
+
+// 压缩文件不会被修改，不需要持久化
+func (cio *CompressedIO) Sync() error {
+	return nil
+}
+
+// 关闭文件，释放解压出来的数据
+func (cio *CompressedIO) Close() error {
+	cio.mu.Lock()
+	defer cio.mu.Unlock()
+	cio.chunk, cio.chunkIdx = nil, -1
+	if cio.file == nil {
+		return nil
+	}
+	err := cio.file.Close()
+	cio.file = nil
+	return err
+}
+
+// 获取到文件压缩前的大小
+func (cio *CompressedIO) Size() (int64, error) {
+	return cio.size, nil
+}
+
+// 压缩文件是只读的
+func (cio *CompressedIO) Truncate(int64) error {
+	return ErrCompressedFileReadOnly
+}
+
+// 压缩文件是只读的
+func (cio *CompressedIO) Preallocate(int64) error {
+	return ErrCompressedFileReadOnly
+}
+
+// 解压第idx个块，替换掉之前缓存的块   在访问此方法前必须持有互斥锁
+func (cio *CompressedIO) loadChunk(idx int) error {
+	if cio.chunkIdx == idx {
+		return nil
+	}
+	if cio.file == nil {
+		return os.ErrClosed
+	}
+	start, end := cio.offsets[idx], cio.offsets[idx+1]
+	reader, err := gzip.NewReader(io.NewSectionReader(cio.file, start, end-start))
+	if err != nil {
+		return err
+	}
+	reader.Multistream(false)
+	size := cio.chunkSize
+	if rest := cio.size - int64(idx)*cio.chunkSize; rest < size {
+		size = rest
+	}
+	//复用上一个块的缓冲区
+	chunk := cio.chunk
+	if int64(cap(chunk)) < size {
+		chunk = make([]byte, size)
+	}
+	chunk = chunk[:size]
+	cio.chunkIdx = -1
+	if _, err := io.ReadFull(reader, chunk); err != nil {
+		return err
+	}
+	cio.chunk, cio.chunkIdx = chunk, idx
+	return nil
+}
+
+// 压缩前大小为size的文件分成的块数，空文件也有一个块
+func compressedChunkNum(size, chunkSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + chunkSize - 1) / chunkSize
+}
+
+// 记录写入的字节数，得到每个块在文件中的位置
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// 把src文件分块压缩之后写入dest文件，dest已经存在的话会被覆盖
+func WriteCompressedFile(fs vfs.FS, src, dest string) error {
+	srcFile, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destFile, err := fs.OpenFile(dest, os.O_CREATE|os.O_RDWR|os.O_TRUNC, DataFilePerm)
+	if err != nil {
+		return err
+	}
+	writeErr := func() error {
+		cw := &countingWriter{w: destFile}
+		srcReader := io.NewSectionReader(srcFile, 0, info.Size())
+		buf := make([]byte, compressedChunkSize)
+		var index []byte
+		for i := int64(0); i < compressedChunkNum(info.Size(), compressedChunkSize); i++ {
+			n, err := io.ReadFull(srcReader, buf)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			index = binary.BigEndian.AppendUint64(index, uint64(cw.n))
+			writer := gzip.NewWriter(cw)
+			if i == 0 {
+				writer.Extra = make([]byte, 16)
+				binary.BigEndian.PutUint64(writer.Extra[:8], uint64(info.Size()))
+				binary.BigEndian.PutUint64(writer.Extra[8:], compressedChunkSize)
+			}
+			if _, err := writer.Write(buf[:n]); err != nil {
+				return err
+			}
+			if err := writer.Close(); err != nil {
+				return err
+			}
+		}
+		index = binary.BigEndian.AppendUint64(index, uint64(cw.n))
+		if _, err := cw.Write(index); err != nil {
+			return err
+		}
+		return destFile.Sync()
+	}()
+	if err := destFile.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	return writeErr
+}
@@ -0,0 +1,116 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedIO_Read(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "compressed-a.data")
+	gzPath := path + ".gz"
+	defer destroyFile(path)
+	defer destroyFile(gzPath)
+	assert.Nil(t, os.WriteFile(path, []byte("key-akey-b"), DataFilePerm))
+
+	assert.Nil(t, WriteCompressedFile(vfs.OS, path, gzPath))
+	cio, err := NewIOManager(vfs.OS, gzPath, Compressed)
+	assert.Nil(t, err)
+	size, err := cio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	b := make([]byte, 5)
+	n, err := cio.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), b)
+	n, err = cio.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+
+	//压缩文件是只读的
+	_, err = cio.Write([]byte("key-c"))
+	assert.Equal(t, ErrCompressedFileReadOnly, err)
+	assert.Equal(t, ErrCompressedFileReadOnly, cio.Truncate(0))
+	assert.Nil(t, cio.Close())
+
+	//不是压缩文件
+	_, err = NewIOManager(vfs.OS, path, Compressed)
+	assert.NotNil(t, err)
+}
+
+// 跨越多个块读取，内存中只保留一个块
+func TestCompressedIO_Chunks(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "compressed-b.data")
+	gzPath := path + ".gz"
+	defer destroyFile(path)
+	defer destroyFile(gzPath)
+	content := make([]byte, 3*compressedChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(content[:compressedChunkSize])
+	assert.Nil(t, os.WriteFile(path, content, DataFilePerm))
+
+	assert.Nil(t, WriteCompressedFile(vfs.OS, path, gzPath))
+	cio, err := NewCompressedIOManager(vfs.OS, gzPath)
+	assert.Nil(t, err)
+	defer cio.Close()
+	size, err := cio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, 5, len(cio.offsets))
+
+	for _, offset := range []int64{0, compressedChunkSize - 10, 2*compressedChunkSize - 1, 3 * compressedChunkSize} {
+		b := make([]byte, 100)
+		n, err := cio.Read(b, offset)
+		assert.Nil(t, err)
+		assert.Equal(t, 100, n)
+		assert.Equal(t, content[offset:offset+100], b)
+		assert.True(t, len(cio.chunk) <= compressedChunkSize)
+	}
+	b := make([]byte, len(content))
+	n, err := cio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(content), n)
+	assert.Equal(t, content, b)
+	assert.True(t, len(cio.chunk) <= compressedChunkSize)
+	n, err = cio.Read(b, int64(len(content)-1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1, n)
+
+	//空文件
+	assert.Nil(t, os.WriteFile(path, nil, DataFilePerm))
+	assert.Nil(t, WriteCompressedFile(vfs.OS, path, gzPath))
+	empty, err := NewCompressedIOManager(vfs.OS, gzPath)
+	assert.Nil(t, err)
+	n, err = empty.Read(b, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, empty.Close())
+}
+
+// 以前的版本整个压缩成一个gzip member的文件仍然可以读取
+// Extra字段不是压缩前的大小和块的大小时不能打开
+func TestCompressedIO_UnknownExtra(t *testing.T) {
+	gzPath := filepath.Join(os.TempDir(), "compressed-c.data.gz")
+	defer destroyFile(gzPath)
+	content := bytes.Repeat([]byte("key-a"), 30000)
+	for _, extra := range [][]byte{nil, binary.BigEndian.AppendUint64(nil, uint64(len(content))), make([]byte, 24)} {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Extra = extra
+		_, err := writer.Write(content)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+		assert.Nil(t, os.WriteFile(gzPath, buf.Bytes(), DataFilePerm))
+
+		_, err = NewCompressedIOManager(vfs.OS, gzPath)
+		assert.Equal(t, gzip.ErrHeader, err)
+	}
+}
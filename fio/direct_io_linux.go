@@ -0,0 +1,225 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// 使用O_DIRECT的文件IO，读写都绕过操作系统的页缓存，避免大value把更热的数据从页缓存中挤出去
+// O_DIRECT要求读写的内存地址、文件偏移以及长度都按块对齐，所以：
+// 写入的数据先追加到一个对齐的尾部缓冲区中，缓冲区写满之后整块写入文件；Sync的时候把没有写满的部分补零之后写入
+// 读取任意位置的数据时，先按块对齐读取包含目标区间的数据，再拷贝出需要的部分，还没有写入文件的部分直接从尾部缓冲区中拷贝
+// 文件系统不支持O_DIRECT时(比如tmpfs)退回到标准文件IO
+const (
+	directIOAlignment  = 4096                   //对齐的块大小
+	directIOBufferSize = 64 * directIOAlignment //尾部缓冲区的大小
+)
+
+type DirectFileIO struct {
+	fd      *os.File
+	size    int64       //文件中有效数据的大小
+	tail    []byte      //对齐的尾部缓冲区，保存从tailOff开始到size之间的数据
+	tailLen int         //尾部缓冲区中有效数据的长度
+	tailOff int64       //尾部缓冲区对应的文件偏移，总是按块对齐的
+	lock    *sync.Mutex //尾部缓冲区在读写之间共享
+}
+
+// 初始化Direct IO
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|unix.O_DIRECT, DataFilePerm)
+	if err != nil && isEINVAL(err) {
+		//文件系统不支持O_DIRECT
+		return NewFileOPManager(vfs.OS, fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dio := &DirectFileIO{
+		fd:   fd,
+		tail: alignedBlock(directIOBufferSize),
+		lock: new(sync.Mutex),
+	}
+	if err := dio.loadTail(); err != nil {
+		_ = fd.Close()
+		if isEINVAL(err) { //打开成功但是不支持对齐读取
+			return NewFileOPManager(vfs.OS, fileName)
+		}
+		return nil, err
+	}
+	return dio, nil
+}
+
+// 从文件给定位置读取对应的数据
+func (dio *DirectFileIO) Read(b []byte, offset int64) (int, error) {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	if offset >= dio.size {
+		return 0, io.EOF
+	}
+	end := offset + int64(len(b))
+	if end > dio.size {
+		end = dio.size
+	}
+
+	var n int
+	//已经写入文件的部分，按块对齐读取之后再拷贝
+	if offset < dio.tailOff {
+		diskEnd := end
+		if diskEnd > dio.tailOff {
+			diskEnd = dio.tailOff
+		}
+		alignedOff := alignDown(offset)
+		buf := alignedBlock(int(alignUp(diskEnd) - alignedOff))
+		if _, err := dio.fd.ReadAt(buf, alignedOff); err != nil && err != io.EOF {
+			return 0, err
+		}
+		n += copy(b, buf[offset-alignedOff:diskEnd-alignedOff])
+	}
+	//还在尾部缓冲区中的部分
+	if end > dio.tailOff {
+		start := offset + int64(n)
+		n += copy(b[n:], dio.tail[start-dio.tailOff:end-dio.tailOff])
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// 写入字节数组到文件中
+func (dio *DirectFileIO) Write(b []byte) (int, error) {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	var n int
+	for n < len(b) {
+		copied := copy(dio.tail[dio.tailLen:], b[n:])
+		dio.tailLen += copied
+		dio.size += int64(copied)
+		n += copied
+		//缓冲区写满了，整块写入文件
+		if dio.tailLen == len(dio.tail) {
+			if _, err := dio.fd.WriteAt(dio.tail, dio.tailOff); err != nil {
+				return n, err
+			}
+			dio.tailOff += int64(len(dio.tail))
+			dio.tailLen = 0
+		}
+	}
+	return n, nil
+}
+
+// 将内存缓冲区的文件数据持久化到磁盘中
+func (dio *DirectFileIO) Sync() error {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	if err := dio.flushTail(); err != nil {
+		return err
+	}
+	return dio.fd.Sync()
+}
+
+// 关闭文件   最后一个块是补零之后写入的，关闭前截断到有效数据的大小
+func (dio *DirectFileIO) Close() error {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	if err := dio.flushTail(); err != nil {
+		return err
+	}
+	if err := dio.fd.Truncate(dio.size); err != nil {
+		return err
+	}
+	if err := dio.fd.Sync(); err != nil {
+		return err
+	}
+	return dio.fd.Close()
+}
+
+// 获取到文件大小
+func (dio *DirectFileIO) Size() (int64, error) {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	return dio.size, nil
+}
+
+// 将文件截断到指定的大小，之后从这个位置开始写入
+func (dio *DirectFileIO) Truncate(size int64) error {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	if err := dio.flushTail(); err != nil {
+		return err
+	}
+	if err := dio.fd.Truncate(size); err != nil {
+		return err
+	}
+	return dio.loadTail()
+}
+
+// 预先分配磁盘空间   使用FALLOC_FL_KEEP_SIZE，不改变文件大小，文件系统不支持的时候直接忽略
+func (dio *DirectFileIO) Preallocate(size int64) error {
+	dio.lock.Lock()
+	defer dio.lock.Unlock()
+	err := unix.Fallocate(int(dio.fd.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if err == unix.EOPNOTSUPP || err == unix.ENOSYS {
+		return nil
+	}
+	return err
+}
+
+// 把尾部缓冲区中的数据补零到整块之后写入文件   缓冲区保留，之后的写入会继续追加并重写这个块
+func (dio *DirectFileIO) flushTail() error {
+	if dio.tailLen == 0 {
+		return nil
+	}
+	n := alignUp(int64(dio.tailLen))
+	for i := dio.tailLen; i < int(n); i++ {
+		dio.tail[i] = 0
+	}
+	_, err := dio.fd.WriteAt(dio.tail[:n], dio.tailOff)
+	return err
+}
+
+// 根据文件当前的大小，把最后一个没有写满的块读取到尾部缓冲区中
+func (dio *DirectFileIO) loadTail() error {
+	stat, err := dio.fd.Stat()
+	if err != nil {
+		return err
+	}
+	dio.size = stat.Size()
+	dio.tailOff = alignDown(dio.size)
+	dio.tailLen = int(dio.size - dio.tailOff)
+	if dio.tailLen > 0 {
+		if _, err := dio.fd.ReadAt(dio.tail[:directIOAlignment], dio.tailOff); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// 分配一个起始地址按块对齐的字节数组
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1))
+	if offset != 0 {
+		offset = directIOAlignment - offset
+	}
+	return buf[offset : offset+size : offset+size]
+}
+
+func alignDown(n int64) int64 {
+	return n &^ (directIOAlignment - 1)
+}
+
+func alignUp(n int64) int64 {
+	return alignDown(n + directIOAlignment - 1)
+}
+
+func isEINVAL(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == unix.EINVAL
+	}
+	return err == unix.EINVAL
+}
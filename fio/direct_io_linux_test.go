@@ -0,0 +1,92 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectIO_WriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "direct-a.data")
+	dio, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+	_, ok := dio.(*DirectFileIO)
+	assert.True(t, ok) //ext4/xfs等文件系统支持O_DIRECT
+
+	//写入不对齐的数据，跨越多次整块写入
+	var expected []byte
+	for i := 0; i < 500; i++ {
+		buf := make([]byte, 1+rand.Intn(3000))
+		rand.Read(buf)
+		n, err := dio.Write(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, len(buf), n)
+		expected = append(expected, buf...)
+		if i%50 == 0 {
+			assert.Nil(t, dio.Sync())
+		}
+	}
+	size, err := dio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), size)
+
+	//任意位置的读取，包括同时跨越已写入文件的部分和尾部缓冲区的读取
+	for i := 0; i < 200; i++ {
+		offset := rand.Int63n(size)
+		length := 1 + rand.Int63n(10000)
+		b := make([]byte, length)
+		n, err := dio.Read(b, offset)
+		if offset+length > size {
+			assert.Equal(t, io.EOF, err)
+			assert.Equal(t, int(size-offset), n)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, int(length), n)
+		}
+		assert.Equal(t, expected[offset:offset+int64(n)], b[:n])
+	}
+	assert.Nil(t, dio.Close())
+
+	//关闭之后文件截断到有效数据的大小，重新打开之后可以继续追加
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+
+	dio, err = NewDirectIOManager(path)
+	assert.Nil(t, err)
+	_, err = dio.Write([]byte("tail"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("tail")...)
+	b := make([]byte, len(expected))
+	_, err = dio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, b)
+	assert.Nil(t, dio.Close())
+}
+
+func TestDirectIO_Truncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "direct-b.data")
+	dio, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+	_, err = dio.Write(make([]byte, 10000))
+	assert.Nil(t, err)
+	_, err = dio.Write([]byte("garbage"))
+	assert.Nil(t, err)
+	assert.Nil(t, dio.Sync())
+
+	assert.Nil(t, dio.Truncate(10000))
+	_, err = dio.Write([]byte("valid"))
+	assert.Nil(t, err)
+	b := make([]byte, 5)
+	_, err = dio.Read(b, 10000)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("valid"), b)
+	assert.Nil(t, dio.Close())
+
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10005), stat.Size())
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "bitcask-go/vfs"
+
+// 只有linux支持O_DIRECT，其他平台上退回到标准文件IO
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	return NewFileOPManager(vfs.OS, fileName)
+}
@@ -0,0 +1,18 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"golang.org/x/sys/unix"
+)
+
+// 使用fallocate为文件分配磁盘空间并扩展文件大小
+// 不是操作系统中的文件，或者文件系统不支持fallocate时，直接扩展文件大小(稀疏文件)
+func preallocate(fd vfs.File, size int64) error {
+	if f, ok := fd.(interface{ Fd() uintptr }); ok {
+		err := unix.Fallocate(int(f.Fd()), 0, 0, size)
+		if err != unix.EOPNOTSUPP && err != unix.ENOSYS {
+			return err
+		}
+	}
+	return fd.Truncate(size)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fio
+
+import "bitcask-go/vfs"
+
+// 没有fallocate的平台上直接扩展文件大小(稀疏文件)，不会真正分配磁盘空间，但读取和写入的语义是一致的
+func preallocate(fd vfs.File, size int64) error {
+	return fd.Truncate(size)
+}
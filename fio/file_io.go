@@ -1,23 +1,32 @@
 package fio
 
-import "os"
+import (
+	"bitcask-go/vfs"
+	"os"
+)
 
 // 使用标准系统文件IO来实现IOManager接口
 type FileIO struct {
 	//使用go语言提供的一些文件接口进行封装
-	fd *os.File //系统文件描述符     这里指向的文件是位于磁盘上的，而我们实现的存储数据库就是将文件存储在磁盘上的
+	fd       vfs.File //文件描述符     默认指向的文件是位于磁盘上的，而我们实现的存储数据库就是将文件存储在磁盘上的
+	writeOff int64    //下一次写入的位置   文件可能预分配了空间，实际大小不一定是数据的末尾，所以不能使用O_APPEND
 }
 
-func NewFileOPManager(fileName string) (*FileIO, error) {
-	fil, err := os.OpenFile( //如果该文件不存在则会创建对应的文件
+func NewFileOPManager(fs vfs.FS, fileName string) (*FileIO, error) {
+	fil, err := fs.OpenFile( //如果该文件不存在则会创建对应的文件
 		fileName,
-		os.O_CREATE|os.O_APPEND, //os.O_APPEND表示我们的文件是只支持追加写入的
+		os.O_CREATE|os.O_RDWR,
 		DataFilePerm,
 	)
 	if err != nil {
 		return nil, err
 	}
-	return &FileIO{fd: fil}, nil
+	stat, err := fil.Stat()
+	if err != nil {
+		_ = fil.Close()
+		return nil, err
+	}
+	return &FileIO{fd: fil, writeOff: stat.Size()}, nil
 }
 
 // 从文件给定位置读取对应的数据
@@ -25,9 +34,11 @@ func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
 
-// 写入字节数组到文件中
+// 写入字节数组到文件中   只会追加写入到writeOff的位置
 func (fio *FileIO) Write(b []byte) (int, error) {
-	return fio.fd.Write(b)
+	n, err := fio.fd.WriteAt(b, fio.writeOff)
+	fio.writeOff += int64(n)
+	return n, err
 }
 
 // 将内存缓冲区的文件数据持久化到磁盘中
@@ -47,3 +58,25 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+// 将文件截断到指定的大小   文件是追加写入的，之后的写入会从新的末尾开始
+func (fio *FileIO) Truncate(size int64) error {
+	if err := fio.fd.Truncate(size); err != nil {
+		return err
+	}
+	fio.writeOff = size
+	return nil
+}
+
+// 将文件预分配到指定的大小，预分配的部分全部为零，读取的时候会被当作文件末尾(见data.ReadLogRecord)
+// 之后的追加写入仍然从writeOff开始，不需要再修改文件大小等元数据
+func (fio *FileIO) Preallocate(size int64) error {
+	stat, err := fio.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() >= size {
+		return nil
+	}
+	return preallocate(fio.fd, size)
+}
@@ -1,6 +1,7 @@
 package fio
 
 import (
+	"bitcask-go/vfs"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"path/filepath"
@@ -14,8 +15,8 @@ func destroyFile(name string) { //新建的文件记得要清理掉   同时注
 }
 
 func TestNewFileOPManager(t *testing.T) {
-	path := filepath.Join("G:\\GO_Project\\kv_project\\tmp", "a.data")
-	fio, err := NewFileOPManager(path)
+	path := filepath.Join(os.TempDir(), "a.data")
+	fio, err := NewFileOPManager(vfs.OS, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -25,8 +26,8 @@ func TestNewFileOPManager(t *testing.T) {
 }
 
 func TestFileIO_Write(t *testing.T) {
-	path := filepath.Join("G:\\GO_Project\\kv_project\\tmp", "a.data")
-	fio, err := NewFileOPManager(path)
+	path := filepath.Join(os.TempDir(), "a.data")
+	fio, err := NewFileOPManager(vfs.OS, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -49,8 +50,8 @@ func TestFileIO_Write(t *testing.T) {
 }
 
 func TestFileIO_Read(t *testing.T) {
-	path := filepath.Join("G:\\GO_Project\\kv_project\\tmp", "a.data")
-	fio, err := NewFileOPManager(path)
+	path := filepath.Join(os.TempDir(), "a.data")
+	fio, err := NewFileOPManager(vfs.OS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
@@ -77,8 +78,8 @@ func TestFileIO_Read(t *testing.T) {
 }
 
 func TestFileIO_Sync(t *testing.T) {
-	path := filepath.Join("G:\\GO_Project\\kv_project\\tmp", "a.data")
-	fio, err := NewFileOPManager(path)
+	path := filepath.Join(os.TempDir(), "a.data")
+	fio, err := NewFileOPManager(vfs.OS, path)
 	defer destroyFile(path)
 
 	assert.Nil(t, err)
@@ -93,8 +94,8 @@ func TestFileIO_Sync(t *testing.T) {
 }
 
 func TestFileIO_Close(t *testing.T) {
-	path := filepath.Join("G:\\GO_Project\\kv_project\\tmp", "a.data")
-	fio, err := NewFileOPManager(path)
+	path := filepath.Join(os.TempDir(), "a.data")
+	fio, err := NewFileOPManager(vfs.OS, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -102,3 +103,45 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close() //试试重复close
 	assert.Nil(t, err)
 }
+
+func TestFileIO_Preallocate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "a.data")
+	fio, err := NewFileOPManager(vfs.OS, path)
+	defer destroyFile(path)
+	assert.Nil(t, err)
+
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Preallocate(4096))
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4096), size)
+
+	//预分配之后仍然接着已有的数据写入，预分配的部分全部为零
+	_, err = fio.Write([]byte(" storage"))
+	assert.Nil(t, err)
+	b := make([]byte, 20)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask kv storage\x00\x00"), b)
+
+	//截断之后从新的末尾开始写入
+	assert.Nil(t, fio.Truncate(18))
+	_, err = fio.Write([]byte("!"))
+	assert.Nil(t, err)
+	size, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(19), size)
+	assert.Nil(t, fio.Close())
+
+	//重新打开之后从文件末尾开始写入
+	fio, err = NewFileOPManager(vfs.OS, path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("?"))
+	assert.Nil(t, err)
+	b = make([]byte, 20)
+	n, err := fio.Read(b, 0)
+	assert.Equal(t, 20, n)
+	assert.Equal(t, []byte("bitcask kv storage!?"), b)
+	assert.Nil(t, fio.Close())
+}
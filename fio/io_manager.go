@@ -1,17 +1,33 @@
 package fio
 
+import (
+	"bitcask-go/vfs"
+	"errors"
+)
+
 //这里涉及的就是对磁盘上的数据进行处理
 
 const DataFilePerm = 0644
 
+var ErrUnsupportedIOType = errors.New("unsupported io type")
+
 type FileIOType = byte
 
 const (
 	//标准文件IO
 	StanderdFIO FileIOType = iota
 
-	//mmap内存文件映射
+	//mmap内存文件映射，只能读取，用于启动时加速加载
 	MemoryMap
+
+	//可读写的mmap内存文件映射
+	MemoryMapRW
+
+	//绕过页缓存的Direct IO，只在linux上支持，其他情况下退回到标准文件IO
+	DirectIO
+
+	//整个文件压缩之后的只读文件，用于冷数据目录
+	Compressed
 )
 
 // 抽象的io管理接口，可以接入不同的io类型，目前支持标准文件io
@@ -34,18 +50,38 @@ type IOManager interface {
 
 	//获取到文件大小
 	Size() (int64, error)
+
+	//将文件截断到指定的大小，之后的写入从这个位置开始
+	Truncate(int64) error
+
+	//为文件预先分配指定大小的磁盘空间，不改变下一次写入的位置
+	Preallocate(int64) error
 }
 
-// 初始化IOManager，目前只支持标准FileIO
+// 初始化IOManager，fs为数据文件所在的文件系统
 // 后续如果实现了新的IO方法，可以在下面增加一个判断来选择不同的io类型
-func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+func NewIOManager(fs vfs.FS, fileName string, ioType FileIOType) (IOManager, error) {
+	//压缩文件在任何文件系统上都是把用到的块解压到内存中再读取
+	if ioType == Compressed {
+		return NewCompressedIOManager(fs, fileName)
+	}
+	//内存文件系统中的文件统一使用内存IO
+	if memFS, ok := fs.(*vfs.MemFS); ok {
+		return NewMemIOManager(memFS, fileName)
+	}
+	//mmap和direct io需要直接操作系统中的文件，其他的文件系统上只能使用标准文件IO
+	if fs != vfs.OS {
+		return NewFileOPManager(fs, fileName)
+	}
 	switch ioType {
 	case StanderdFIO:
-		return NewFileOPManager(fileName)
+		return NewFileOPManager(fs, fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
-	default:
-		panic("unsupported io type")
+	case MemoryMapRW:
+		return NewMMapRWIOManager(fileName)
+	case DirectIO:
+		return NewDirectIOManager(fileName)
 	}
-
+	return nil, ErrUnsupportedIOType
 }
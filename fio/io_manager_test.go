@@ -0,0 +1,16 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewIOManager_UnsupportedIOType(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "io-type-a.data")
+	defer destroyFile(path)
+	_, err := NewIOManager(vfs.OS, path, 100)
+	assert.Equal(t, ErrUnsupportedIOType, err)
+}
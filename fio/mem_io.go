@@ -0,0 +1,56 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"os"
+)
+
+// 内存IO，数据文件保存在内存文件系统(vfs.MemFS)中
+// 读写直接操作内存中的字节数组，Sync不需要做任何事情
+type MemIO struct {
+	file *vfs.MemFile
+}
+
+// 初始化内存IO
+func NewMemIOManager(fs *vfs.MemFS, fileName string) (*MemIO, error) {
+	file, err := fs.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &MemIO{file: file.(*vfs.MemFile)}, nil
+}
+
+// 从文件给定位置读取对应的数据
+func (mio *MemIO) Read(b []byte, offset int64) (int, error) {
+	return mio.file.ReadAt(b, offset)
+}
+
+// 写入字节数组到文件中
+func (mio *MemIO) Write(b []byte) (int, error) {
+	return mio.file.Write(b)
+}
+
+// 内存中的数据不需要持久化
+func (mio *MemIO) Sync() error {
+	return nil
+}
+
+// 关闭文件
+func (mio *MemIO) Close() error {
+	return mio.file.Close()
+}
+
+// 获取到文件大小
+func (mio *MemIO) Size() (int64, error) {
+	return mio.file.Size(), nil
+}
+
+// 将文件截断到指定的大小
+func (mio *MemIO) Truncate(size int64) error {
+	return mio.file.Truncate(size)
+}
+
+// 内存中的文件不需要预分配空间
+func (mio *MemIO) Preallocate(int64) error {
+	return nil
+}
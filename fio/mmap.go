@@ -1,10 +1,13 @@
 package fio
 
 import (
+	"errors"
 	"golang.org/x/exp/mmap"
 	"os"
 )
 
+var ErrReadOnly = errors.New("the read only mmap does not support writing")
+
 // MMap IO  内存文件映射
 type MMap struct {
 	readerAt *mmap.ReaderAt //go语言官方的mmap包只能实现读取数据
@@ -28,14 +31,14 @@ func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
 	return mmap.readerAt.ReadAt(b, offset)
 }
 
-// 写入字节数组到文件中
+// 只读的mmap不支持写入
 func (mmap *MMap) Write([]byte) (int, error) {
-	panic("not implemented")
+	return 0, ErrReadOnly
 }
 
-// 将内存缓冲区的文件数据持久化到磁盘中
+// 只读的mmap不支持持久化
 func (mmap *MMap) Sync() error {
-	panic("not implemented")
+	return ErrReadOnly
 }
 
 // 关闭文件
@@ -47,3 +50,13 @@ func (mmap *MMap) Close() error {
 func (mmap *MMap) Size() (int64, error) {
 	return int64(mmap.readerAt.Len()), nil
 }
+
+// 只读的mmap不支持截断
+func (mmap *MMap) Truncate(int64) error {
+	return ErrReadOnly
+}
+
+// 只读的mmap不支持预分配
+func (mmap *MMap) Preallocate(int64) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,178 @@
+//go:build !windows
+
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync"
+)
+
+// 可读写的MMap IO
+// 写入的时候直接拷贝到映射的内存中，不需要经过系统调用；映射空间不够时按块扩大文件并重新映射，预分配(Preallocate)之后一次映射整个文件
+// 文件的实际大小总是大于等于已经写入的数据量，关闭的时候再把文件截断到真正写入的位置
+// 截断之前崩溃的话，文件尾部会留下一段全零的数据，读取时会被当作文件末尾(见data.ReadLogRecord)
+const mmapGrowSize = 4 * 1024 * 1024 //每一次扩大文件的大小
+
+type MMapRW struct {
+	fd       *os.File
+	data     []byte        //映射的内存，长度等于文件当前的实际大小
+	writeOff int64         //已经写入的数据量，也是下一次写入的位置
+	resized  bool          //文件大小发生了变化，sync的时候需要连同元数据一起持久化
+	lock     *sync.RWMutex //重新映射的时候不能有并发的读取
+}
+
+// 初始化可读写的MMap IO
+func NewMMapRWIOManager(fileName string) (IOManager, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	mmapRW := &MMapRW{fd: fd, writeOff: stat.Size(), lock: new(sync.RWMutex)}
+	if err := mmapRW.remap(stat.Size()); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return mmapRW, nil
+}
+
+// 从文件给定位置读取对应的数据
+func (m *MMapRW) Read(b []byte, offset int64) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if offset >= m.writeOff {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[offset:m.writeOff])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// 写入字节数组到文件中
+func (m *MMapRW) Write(b []byte) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	end := m.writeOff + int64(len(b))
+	if end > int64(len(m.data)) {
+		//映射空间不够了，按块扩大文件
+		newSize := int64(len(m.data)) + mmapGrowSize
+		for newSize < end {
+			newSize += mmapGrowSize
+		}
+		if err := m.resize(newSize); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(m.data[m.writeOff:], b)
+	m.writeOff += int64(n)
+	return n, nil
+}
+
+// 将内存缓冲区的文件数据持久化到磁盘中
+func (m *MMapRW) Sync() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.data) > 0 {
+		if err := unix.Msync(m.data, unix.MS_SYNC); err != nil {
+			return err
+		}
+	}
+	//msync不会持久化文件大小，扩大过文件的话还需要sync一次文件
+	if m.resized {
+		if err := m.fd.Sync(); err != nil {
+			return err
+		}
+		m.resized = false
+	}
+	return nil
+}
+
+// 关闭文件   关闭之前把文件截断到实际写入的位置
+func (m *MMapRW) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.data != nil {
+		if err := unix.Msync(m.data, unix.MS_SYNC); err != nil {
+			return err
+		}
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	if err := m.fd.Truncate(m.writeOff); err != nil {
+		return err
+	}
+	if err := m.fd.Sync(); err != nil {
+		return err
+	}
+	return m.fd.Close()
+}
+
+// 获取到文件大小   这里返回的是已经写入的数据量，不包括预先分配的部分
+func (m *MMapRW) Size() (int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.writeOff, nil
+}
+
+// 将文件截断到指定的大小，之后从这个位置开始写入
+func (m *MMapRW) Truncate(size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.resize(size); err != nil {
+		return err
+	}
+	m.writeOff = size
+	return nil
+}
+
+// 修改文件的实际大小并重新映射   调用时必须持有写锁
+func (m *MMapRW) resize(size int64) error {
+	if err := m.fd.Truncate(size); err != nil {
+		return err
+	}
+	m.resized = true
+	return m.remap(size)
+}
+
+// 按照文件的实际大小重新映射   调用时必须持有写锁(或者还没有被其他goroutine访问)
+func (m *MMapRW) remap(size int64) error {
+	if m.data != nil {
+		if err := unix.Munmap(m.data); err != nil {
+			return err
+		}
+		m.data = nil
+	}
+	if size == 0 { //长度为0的文件无法映射
+		return nil
+	}
+	data, err := unix.Mmap(int(m.fd.Fd()), 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+// 把文件预分配到size并映射整个文件，写满之前不需要再扩大文件、重新映射   已经写入的数据量不变
+func (m *MMapRW) Preallocate(size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if size <= int64(len(m.data)) {
+		return nil
+	}
+	if err := preallocate(m.fd, size); err != nil {
+		return err
+	}
+	m.resized = true
+	return m.remap(size)
+}
@@ -0,0 +1,139 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMapRW_WriteRead(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-a.data")
+	defer destroyFile(path)
+	mmapRW, err := NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+	assert.NotNil(t, mmapRW)
+
+	n, err := mmapRW.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	_, err = mmapRW.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	size, err := mmapRW.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	b := make([]byte, 5)
+	n, err = mmapRW.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("key-b"), b)
+
+	//超出写入位置的读取
+	n, err = mmapRW.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 2, n)
+	_, err = mmapRW.Read(b, 10)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Nil(t, mmapRW.Sync())
+	assert.Nil(t, mmapRW.Close())
+}
+
+func TestMMapRW_Grow(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-b.data")
+	defer destroyFile(path)
+	mmapRW, err := NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+
+	//写入的数据量超过一次扩大的大小，需要多次重新映射
+	buf := make([]byte, 1024*1024+1)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := mmapRW.Write(buf)
+		assert.Nil(t, err)
+	}
+	size, err := mmapRW.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)*10), size)
+
+	b := make([]byte, len(buf))
+	_, err = mmapRW.Read(b, int64(len(buf)*9))
+	assert.Nil(t, err)
+	assert.Equal(t, buf, b)
+	assert.Nil(t, mmapRW.Close())
+
+	//关闭之后文件被截断到实际写入的位置
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)*10), stat.Size())
+
+	//重新打开之后可以继续追加
+	mmapRW, err = NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+	_, err = mmapRW.Write([]byte("tail"))
+	assert.Nil(t, err)
+	b = make([]byte, 4)
+	_, err = mmapRW.Read(b, int64(len(buf)*10))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tail"), b)
+	assert.Nil(t, mmapRW.Close())
+}
+
+func TestMMapRW_Truncate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-c.data")
+	defer destroyFile(path)
+	//模拟崩溃之后留下的预分配空间
+	assert.Nil(t, os.WriteFile(path, append([]byte("valid"), make([]byte, 100)...), DataFilePerm))
+
+	mmapRW, err := NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+	assert.Nil(t, mmapRW.Truncate(5))
+	_, err = mmapRW.Write([]byte("-next"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 10)
+	_, err = mmapRW.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("valid-next"), b)
+	assert.Nil(t, mmapRW.Close())
+
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), stat.Size())
+}
+
+func TestMMapRW_Preallocate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-rw-d.data")
+	defer destroyFile(path)
+	mmapRW, err := NewMMapRWIOManager(path)
+	assert.Nil(t, err)
+	_, err = mmapRW.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	//预分配整个文件，之后的写入不再扩大文件
+	var fileSize int64 = 8 * 1024 * 1024
+	assert.Nil(t, mmapRW.Preallocate(fileSize))
+	buf := make([]byte, 5*1024*1024)
+	_, err = mmapRW.Write(buf)
+	assert.Nil(t, err)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, fileSize, stat.Size())
+	size, err := mmapRW.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)+5), size)
+
+	b := make([]byte, 5)
+	_, err = mmapRW.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+	assert.Nil(t, mmapRW.Close())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(buf)+5), stat.Size())
+}
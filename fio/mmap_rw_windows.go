@@ -0,0 +1,8 @@
+package fio
+
+import "bitcask-go/vfs"
+
+// windows上暂时没有实现可读写的MMap，退回到标准文件IO
+func NewMMapRWIOManager(fileName string) (IOManager, error) {
+	return NewFileOPManager(vfs.OS, fileName)
+}
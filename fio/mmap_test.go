@@ -0,0 +1,34 @@
+package fio
+
+import (
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMap_ReadOnly(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "mmap-a.data")
+	defer destroyFile(path)
+	fio, err := NewFileOPManager(vfs.OS, path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Close())
+
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	defer mmapIO.Close()
+	b := make([]byte, 5)
+	_, err = mmapIO.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b)
+
+	//只读的mmap不支持任何修改，返回错误而不是panic
+	_, err = mmapIO.Write([]byte("key-b"))
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, mmapIO.Sync())
+	assert.Equal(t, ErrReadOnly, mmapIO.Truncate(0))
+	assert.Equal(t, ErrReadOnly, mmapIO.Preallocate(1024))
+}
@@ -0,0 +1,62 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+)
+
+// 每个旧数据文件都对应一个hint文件，具体的文件格式见data/hint_file.go
+// 活跃文件写满之后转换为旧文件时，会在后台为它生成hint文件；启动时直接加载旧数据文件的hint文件，只需要扫描活跃文件(见load.go)
+// hint文件只是一个加速手段，不存在或者无效的时候直接扫描对应的数据文件即可
+
+// 读取数据文件中从offset开始的所有记录，转换为hint记录(不保留value)    返回读取结束的位置
+// 读取出错时返回出错之前读到的记录以及出错的位置
+func readHintRecords(dataFile *data.DataFile, offset int64) ([]*data.HintRecord, int64, error) {
+	var records []*data.HintRecord
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return records, offset, err
+		}
+		//这里把key拷贝出来，避免一直引用着读取时分配的包含value的缓冲区
+		key := make([]byte, len(logRecord.Key))
+		copy(key, logRecord.Key)
+		records = append(records, &data.HintRecord{
+			Key:  key,
+			Type: logRecord.Type,
+			Pos:  &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size)},
+		})
+		offset += size
+	}
+	return records, offset, nil
+}
+
+// 为不会再写入的数据文件生成hint文件
+func (db *DB) writeHintFile(dataFile *data.DataFile) error {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	records, _, err := readHintRecords(dataFile, dataFile.HeaderSize)
+	if err != nil {
+		return err
+	}
+	return data.WriteHintFile(db.fs, db.options.DirPath, dataFile.FileId, size, records)
+}
+
+// 在后台为数据文件生成hint文件   生成失败也没有关系，下一次启动时会直接扫描这个数据文件
+// records不为空时直接使用已经读取出来的记录，不需要再扫描一遍数据文件
+func (db *DB) writeHintFileAsync(dataFile *data.DataFile, records []*data.HintRecord, size int64) {
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		if records == nil {
+			_ = db.writeHintFile(dataFile)
+			return
+		}
+		_ = data.WriteHintFile(db.fs, db.options.DirPath, dataFile.FileId, size, records)
+	}()
+}
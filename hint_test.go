@@ -0,0 +1,127 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_HintFile_Rotate(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-rotate")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	//跨越多个数据文件的事务
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1400; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Commit())
+	activeFileId := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+
+	//每个旧的数据文件都有对应的hint文件，活跃文件没有
+	for fid := uint32(0); fid < activeFileId; fid++ {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+	_, err = os.Stat(data.GetHintFileName(dir, activeFileId))
+	assert.True(t, os.IsNotExist(err))
+
+	db2, err := OpenDB(opts)
+	defer Destroy_DB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1300, db2.index.Size())
+	_, err = db2.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(1200))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_HintFile_Stale(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-stale")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	//hint文件和数据文件对不上时，直接扫描数据文件
+	err = data.WriteHintFile(vfs.OS, dir, 0, 1, nil)
+	assert.Nil(t, err)
+	//hint文件丢失时也一样，并且会重新生成
+	err = data.RemoveHintFile(vfs.OS, dir, 1)
+	assert.Nil(t, err)
+
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, db2.index.Size())
+	err = db2.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetHintFileName(dir, 1))
+	assert.Nil(t, err)
+
+	db3, err := OpenDB(opts)
+	defer Destroy_DB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, db3.index.Size())
+}
+
+func TestDB_HintFile_Merge(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	//重启之后merge生效，merge生成的数据文件都有对应的hint文件
+	db2, err := OpenDB(opts)
+	defer Destroy_DB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, db2.index.Size())
+	for fid := range db2.olderFile {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+	val, err := db2.Get(utils.GetTestKey(800))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	_, err = db2.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
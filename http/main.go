@@ -86,7 +86,11 @@ func handleListKeys(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	keys := db.ListKeys()
+	keys, err := db.ListKeys()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	writer.Header().Set("Content-Type", "application/json")
 	var result []string
 	for _, key := range keys {
@@ -0,0 +1,136 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// 索引的基准测试，对比不同内存索引每个key占用的内存以及Get的延迟
+
+const benchIndexKeyNum = 100000
+
+func benchIndexKey(i int) []byte {
+	return []byte(fmt.Sprintf("bitcask-go-key-%09d", i))
+}
+
+// 统计每个key在索引中占用的内存大小(包含索引内部保存的key副本，不包含预先生成的测试key)
+func benchmarkMemoryPerKey(b *testing.B, newIndexer func() Indexer) {
+	keys := make([][]byte, benchIndexKeyNum)
+	for i := range keys {
+		keys[i] = benchIndexKey(i)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		indexer := newIndexer()
+		for i, key := range keys {
+			indexer.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: 100})
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/benchIndexKeyNum, "bytes/key")
+		runtime.KeepAlive(indexer)
+	}
+}
+
+func benchmarkGet(b *testing.B, indexer Indexer) {
+	for i := 0; i < benchIndexKeyNum; i++ {
+		indexer.Put(benchIndexKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: 100})
+	}
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = benchIndexKey(rand.Intn(benchIndexKeyNum))
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if pos := indexer.Get(keys[i%len(keys)]); pos == nil {
+			b.Fatal("key not found")
+		}
+	}
+}
+
+func Benchmark_MemoryPerKey_BTree(b *testing.B) {
+	benchmarkMemoryPerKey(b, func() Indexer { return NewTree() })
+}
+
+func Benchmark_MemoryPerKey_ART(b *testing.B) {
+	benchmarkMemoryPerKey(b, func() Indexer { return NewART() })
+}
+
+func Benchmark_MemoryPerKey_Hash(b *testing.B) {
+	benchmarkMemoryPerKey(b, func() Indexer { return NewHashMap(true) })
+}
+
+func Benchmark_MemoryPerKey_SkipList(b *testing.B) {
+	benchmarkMemoryPerKey(b, func() Indexer { return NewSkipList() })
+}
+
+func Benchmark_Get_BTree(b *testing.B) {
+	benchmarkGet(b, NewTree())
+}
+
+func Benchmark_Get_ART(b *testing.B) {
+	benchmarkGet(b, NewART())
+}
+
+func Benchmark_Get_Hash(b *testing.B) {
+	benchmarkGet(b, NewHashMap(true))
+}
+
+func Benchmark_Get_SkipList(b *testing.B) {
+	benchmarkGet(b, NewSkipList())
+}
+
+// 有并发写入时的读性能   跳表的读操作不需要加锁
+func benchmarkGetWithWriter(b *testing.B, indexer Indexer) {
+	for i := 0; i < benchIndexKeyNum; i++ {
+		indexer.Put(benchIndexKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: 100})
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				indexer.Put(benchIndexKey(i%benchIndexKeyNum), &data.LogRecordPos{Fid: 2, Offset: int64(i), Size: 100})
+			}
+		}
+	}()
+	defer close(done)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(benchIndexKeyNum)
+		for pb.Next() {
+			indexer.Get(benchIndexKey(i % benchIndexKeyNum))
+			i++
+		}
+	})
+}
+
+func Benchmark_GetWithWriter_BTree(b *testing.B) {
+	benchmarkGetWithWriter(b, NewTree())
+}
+
+func Benchmark_GetWithWriter_ART(b *testing.B) {
+	benchmarkGetWithWriter(b, NewART())
+}
+
+func Benchmark_GetWithWriter_Hash(b *testing.B) {
+	benchmarkGetWithWriter(b, NewHashMap(true))
+}
+
+func Benchmark_GetWithWriter_SkipList(b *testing.B) {
+	benchmarkGetWithWriter(b, NewSkipList())
+}
@@ -40,7 +40,9 @@ func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{
 		key: key,
 	}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it) //根据it得到btreeItem，注意这里的btreeItem是谷歌那个btree
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -59,6 +61,8 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
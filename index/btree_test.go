@@ -12,27 +12,27 @@ import (
 func TestBTree_Put(t *testing.T) {
 	bt := NewTree()
 	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
-	assert.True(t, res1)
+	assert.Nil(t, res1)
 
 	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
-	assert.True(t, res2)
+	assert.Nil(t, res2)
 
 }
 
 func TestBTree_Get(t *testing.T) {
 	bt := NewTree()
 	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
-	assert.True(t, res1)
+	assert.Nil(t, res1)
 
 	pos1 := bt.Get(nil)
 	assert.Equal(t, uint32(1), pos1.Fid)
 	assert.Equal(t, int64(100), pos1.Offset)
 
 	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
-	assert.True(t, res2)
+	assert.Nil(t, res2)
 
 	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
-	assert.True(t, res3)
+	assert.Equal(t, int64(2), res3.Offset)
 
 	pos2 := bt.Get([]byte("a"))
 	assert.Equal(t, uint32(1), pos2.Fid)
@@ -42,16 +42,18 @@ func TestBTree_Get(t *testing.T) {
 func TestBTree_Delete(t *testing.T) {
 	bt := NewTree()
 	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
-	assert.True(t, res1)
+	assert.Nil(t, res1)
 
-	res2 := bt.Delete(nil)
-	assert.True(t, res2)
+	res2, ok := bt.Delete(nil)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), res2.Offset)
 
 	res3 := bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 33})
-	assert.True(t, res3)
+	assert.Nil(t, res3)
 
-	res4 := bt.Delete([]byte("aaa"))
-	assert.True(t, res4)
+	res4, ok := bt.Delete([]byte("aaa"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(22), res4.Fid)
 
 }
 
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// 哈希表索引，适用于只有点查(Put/Get/Delete)、几乎不做范围遍历的场景
+// 相比btree和art，哈希表的查找是O(1)的，并且每个key只保存一份紧凑的位置信息
+// 内部按key的哈希值分成多个分段，每个分段各自持有一把读写锁，降低并发访问时的锁竞争
+const hashShardCount = 32
+
+type HashMap struct {
+	shards  [hashShardCount]*hashShard
+	ordered bool //是否支持遍历，支持的话在创建迭代器时按需对所有key进行排序
+}
+
+// 哈希表的一个分段
+type hashShard struct {
+	lock  *sync.RWMutex
+	items map[string]hashEntry
+}
+
+// 紧凑的位置信息，直接按值存放在map中，避免为每一个key额外分配一个*data.LogRecordPos
+type hashEntry struct {
+	fid    uint32
+	size   uint32
+	offset int64
+}
+
+// 初始化哈希表索引   ordered为false时，Iterator会返回nil，表示该索引不支持遍历
+func NewHashMap(ordered bool) *HashMap {
+	hm := &HashMap{ordered: ordered}
+	for i := range hm.shards {
+		hm.shards[i] = &hashShard{
+			lock:  new(sync.RWMutex),
+			items: make(map[string]hashEntry),
+		}
+	}
+	return hm
+}
+
+// 根据key的哈希值找到对应的分段   这里使用的是FNV-1a哈希算法
+func (hm *HashMap) getShard(key []byte) *hashShard {
+	var hash uint32 = 2166136261
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return hm.shards[hash%hashShardCount]
+}
+
+// 向索引中存储key对应的数据的位置
+func (hm *HashMap) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	shard := hm.getShard(key)
+	shard.lock.Lock()
+	oldEntry, ok := shard.items[string(key)]
+	shard.items[string(key)] = hashEntry{fid: pos.Fid, size: pos.Size, offset: pos.Offset}
+	shard.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	return oldEntry.toPos()
+}
+
+// 根据key取出对应的索引位置信息
+func (hm *HashMap) Get(key []byte) *data.LogRecordPos {
+	shard := hm.getShard(key)
+	shard.lock.RLock()
+	entry, ok := shard.items[string(key)] //这里使用string(key)作为map的下标，编译器会进行优化，不会产生额外的内存分配
+	shard.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return entry.toPos()
+}
+
+// 根据key删除对应的索引位置信息
+func (hm *HashMap) Delete(key []byte) (*data.LogRecordPos, bool) {
+	shard := hm.getShard(key)
+	shard.lock.Lock()
+	oldEntry, ok := shard.items[string(key)]
+	if ok {
+		delete(shard.items, string(key))
+	}
+	shard.lock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return oldEntry.toPos(), true
+}
+
+// 返回索引中的数据量
+func (hm *HashMap) Size() int {
+	var size int
+	for _, shard := range hm.shards {
+		shard.lock.RLock()
+		size += len(shard.items)
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+// 返回一个索引迭代器
+// 哈希表本身是无序的，所以在这里将所有的key取出来排序，排序之后的结构和btree迭代器是一样的，直接复用btreeIterator
+// 如果创建索引时指定了不支持遍历，这里返回nil
+func (hm *HashMap) Iterator(reverse bool) Iterator {
+	if !hm.ordered {
+		return nil
+	}
+	values := make([]*Item, 0, hm.Size())
+	for _, shard := range hm.shards {
+		shard.lock.RLock()
+		for key, entry := range shard.items {
+			values = append(values, &Item{key: []byte(key), pos: entry.toPos()})
+		}
+		shard.lock.RUnlock()
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+	return &btreeIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+	}
+}
+
+func (hm *HashMap) Close() error {
+	return nil
+}
+
+func (e hashEntry) toPos() *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: e.fid, Offset: e.offset, Size: e.size}
+}
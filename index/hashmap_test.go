@@ -0,0 +1,77 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHashMap_Put(t *testing.T) {
+	hm := NewHashMap(true)
+	res1 := hm.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 100, Size: 10})
+	assert.Nil(t, res1)
+
+	res2 := hm.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 200, Size: 20})
+	assert.NotNil(t, res2)
+	assert.Equal(t, uint32(1), res2.Fid)
+	assert.Equal(t, int64(100), res2.Offset)
+	assert.Equal(t, uint32(10), res2.Size)
+	assert.Equal(t, 1, hm.Size())
+}
+
+func TestHashMap_Get(t *testing.T) {
+	hm := NewHashMap(true)
+	hm.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	pos1 := hm.Get(nil)
+	assert.Equal(t, uint32(1), pos1.Fid)
+	assert.Equal(t, int64(100), pos1.Offset)
+
+	hm.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	hm.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	pos2 := hm.Get([]byte("a"))
+	assert.Equal(t, int64(3), pos2.Offset)
+
+	//不存在的key
+	assert.Nil(t, hm.Get([]byte("not-exist")))
+}
+
+func TestHashMap_Delete(t *testing.T) {
+	hm := NewHashMap(true)
+	_, ok := hm.Delete([]byte("not-exist"))
+	assert.False(t, ok)
+
+	hm.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 33})
+	oldPos, ok := hm.Delete([]byte("aaa"))
+	assert.True(t, ok)
+	assert.Equal(t, uint32(22), oldPos.Fid)
+	assert.Nil(t, hm.Get([]byte("aaa")))
+	assert.Equal(t, 0, hm.Size())
+}
+
+func TestHashMap_Iterator(t *testing.T) {
+	hm := NewHashMap(true)
+	hm.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	hm.Put([]byte("aade"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	hm.Put([]byte("eede"), &data.LogRecordPos{Fid: 1, Offset: 30})
+	hm.Put([]byte("bbde"), &data.LogRecordPos{Fid: 1, Offset: 40})
+
+	//正向遍历是有序的
+	iter1 := hm.Iterator(false)
+	var keys []string
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		keys = append(keys, string(iter1.Key()))
+	}
+	assert.Equal(t, []string{"aade", "bbde", "ccde", "eede"}, keys)
+
+	//反向遍历 + seek
+	iter2 := hm.Iterator(true)
+	iter2.Seek([]byte("cc"))
+	assert.True(t, iter2.Valid())
+	assert.Equal(t, "bbde", string(iter2.Key()))
+	assert.Equal(t, int64(40), iter2.Value().Offset)
+
+	//不支持遍历的哈希表
+	hm2 := NewHashMap(false)
+	hm2.Put([]byte("ccde"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Nil(t, hm2.Iterator(false))
+}
@@ -3,9 +3,13 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
+	"errors"
 	"github.com/google/btree"
 )
 
+// 部分索引(例如不排序的哈希表)不支持遍历，此时Iterator返回nil，上层使用这个错误告知用户
+var ErrIteratorUnsupported = errors.New("the index does not support iteration")
+
 // 针对内存数据表进行操作的部分
 // 定义抽象的索引接口,后续要想接入其他的数据结构，则直接实现这个接口即可       我们在btree.go中使用了google提供的btree，实现了Indexer接口中的所有函数
 type Indexer interface {
@@ -21,7 +25,7 @@ type Indexer interface {
 	//返回索引中的数据量
 	Size() int
 
-	//返回一个索引迭代器
+	//返回一个索引迭代器，不支持遍历的索引返回nil
 	Iterator(reverse bool) Iterator
 
 	//关闭索引
@@ -48,6 +52,12 @@ const (
 	ART //自适应基数树索引
 
 	BPTree //新增的b+树类型
+
+	Hash //哈希表索引，遍历时按需排序
+
+	UnorderedHash //哈希表索引，不支持遍历
+
+	SkipList //跳表索引，读操作无锁
 )
 
 // 根据类型初始化索引   在进行初始化索引的时候，会选择适当的数据结构进行初始化
@@ -61,6 +71,12 @@ func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
 	case BPTree:
 		return nil
 		//return NewBPlusTree(dirPath, sync)
+	case Hash:
+		return NewHashMap(true)
+	case UnorderedHash:
+		return NewHashMap(false)
+	case SkipList:
+		return NewSkipList()
 	default:
 		panic("unsupported index type")
 	}
@@ -0,0 +1,158 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// 所有内存索引共用的一致性测试，新增的索引实现只需要加入到下面的列表中即可
+
+var conformanceIndexers = []struct {
+	name       string
+	newIndexer func() Indexer
+}{
+	{"BTree", func() Indexer { return NewTree() }},
+	{"ART", func() Indexer { return NewART() }},
+	{"Hash", func() Indexer { return NewHashMap(true) }},
+	{"SkipList", func() Indexer { return NewSkipList() }},
+}
+
+func runConformance(t *testing.T, fn func(t *testing.T, indexer Indexer)) {
+	for _, c := range conformanceIndexers {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			indexer := c.newIndexer()
+			defer indexer.Close()
+			fn(t, indexer)
+		})
+	}
+}
+
+func TestIndexer_PutGet(t *testing.T) {
+	runConformance(t, func(t *testing.T, indexer Indexer) {
+		assert.Nil(t, indexer.Get([]byte("a")))
+
+		res1 := indexer.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10, Size: 5})
+		assert.Nil(t, res1)
+		pos1 := indexer.Get([]byte("a"))
+		assert.Equal(t, uint32(1), pos1.Fid)
+		assert.Equal(t, int64(10), pos1.Offset)
+		assert.Equal(t, uint32(5), pos1.Size)
+
+		//覆盖写入时返回旧的位置信息
+		res2 := indexer.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 20, Size: 6})
+		assert.NotNil(t, res2)
+		assert.Equal(t, uint32(1), res2.Fid)
+		assert.Equal(t, int64(10), res2.Offset)
+		assert.Equal(t, int64(20), indexer.Get([]byte("a")).Offset)
+		assert.Equal(t, 1, indexer.Size())
+
+		indexer.Put([]byte("b"), &data.LogRecordPos{Fid: 3, Offset: 30})
+		assert.Equal(t, 2, indexer.Size())
+		assert.Nil(t, indexer.Get([]byte("c")))
+	})
+}
+
+func TestIndexer_Delete(t *testing.T) {
+	runConformance(t, func(t *testing.T, indexer Indexer) {
+		_, ok := indexer.Delete([]byte("not-exist"))
+		assert.False(t, ok)
+
+		indexer.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+		indexer.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+		oldPos, ok := indexer.Delete([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, int64(10), oldPos.Offset)
+		assert.Nil(t, indexer.Get([]byte("a")))
+		assert.Equal(t, 1, indexer.Size())
+
+		//删除之后重新写入
+		assert.Nil(t, indexer.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30}))
+		assert.Equal(t, int64(30), indexer.Get([]byte("a")).Offset)
+	})
+}
+
+func TestIndexer_Iterator(t *testing.T) {
+	runConformance(t, func(t *testing.T, indexer Indexer) {
+		//空索引
+		iter := indexer.Iterator(false)
+		assert.False(t, iter.Valid())
+		iter.Close()
+
+		for i, key := range []string{"ccde", "aade", "eede", "bbde", "ddde"} {
+			indexer.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		indexer.Delete([]byte("ddde"))
+
+		collect := func(iter Iterator) []string {
+			var keys []string
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+				assert.NotNil(t, iter.Value())
+			}
+			return keys
+		}
+
+		//正向遍历
+		iter1 := indexer.Iterator(false)
+		iter1.Rewind()
+		assert.Equal(t, []string{"aade", "bbde", "ccde", "eede"}, collect(iter1))
+		iter1.Seek([]byte("bc"))
+		assert.Equal(t, []string{"ccde", "eede"}, collect(iter1))
+		iter1.Seek([]byte("bbde"))
+		assert.Equal(t, []string{"bbde", "ccde", "eede"}, collect(iter1))
+		iter1.Seek([]byte("zz"))
+		assert.False(t, iter1.Valid())
+		iter1.Close()
+
+		//反向遍历
+		iter2 := indexer.Iterator(true)
+		iter2.Rewind()
+		assert.Equal(t, []string{"eede", "ccde", "bbde", "aade"}, collect(iter2))
+		iter2.Seek([]byte("cc"))
+		assert.Equal(t, []string{"bbde", "aade"}, collect(iter2))
+		iter2.Seek([]byte("ccde"))
+		assert.Equal(t, []string{"ccde", "bbde", "aade"}, collect(iter2))
+		iter2.Seek([]byte("a"))
+		assert.False(t, iter2.Valid())
+		iter2.Close()
+
+		//迭代器中的位置信息和索引中的一致
+		iter3 := indexer.Iterator(false)
+		for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+			assert.Equal(t, indexer.Get(iter3.Key()).Offset, iter3.Value().Offset)
+		}
+		iter3.Close()
+	})
+}
+
+func TestIndexer_Concurrent(t *testing.T) {
+	runConformance(t, func(t *testing.T, indexer Indexer) {
+		const n = 1000
+		wg := new(sync.WaitGroup)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				indexer.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+				if i%3 == 0 {
+					indexer.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if pos := indexer.Get([]byte(fmt.Sprintf("key-%04d", i))); pos != nil {
+					assert.Equal(t, int64(i), pos.Offset)
+				}
+			}
+		}()
+		wg.Wait()
+
+		assert.Equal(t, n-(n+2)/3, indexer.Size())
+	})
+}
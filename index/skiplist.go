@@ -0,0 +1,282 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 跳表索引
+// 写操作(Put/Delete)之间通过互斥锁串行执行，读操作(Get/迭代器)完全不加锁
+// 所有的指针都通过原子操作进行读写：写操作总是先把新节点的next指针设置好，再把它挂到前驱节点上，
+// 这样读者在任何时刻看到的都是一个完整的链表；被删除的节点只是从链表中摘下来，自身的next指针保持不变，
+// 正停留在该节点上的读者仍然可以继续往后遍历，内存由gc负责回收
+const (
+	skipListMaxLevel = 20 //最大层数，概率为1/4时足够容纳上亿个key
+	skipListP        = 4  //每一层以1/skipListP的概率晋升到上一层
+)
+
+type SkipListIndex struct {
+	head   *skipListNode
+	height atomic.Int32 //当前跳表的最大层数
+	size   atomic.Int64
+	lock   *sync.Mutex //写操作之间的互斥锁
+	rand   *rand.Rand  //生成随机层数，只在持有lock的时候使用
+}
+
+type skipListNode struct {
+	key  []byte
+	pos  atomic.Pointer[data.LogRecordPos] //位置信息，为nil表示这个节点已经被删除了
+	next []atomic.Pointer[skipListNode]
+}
+
+// 初始化跳表索引
+func NewSkipList() *SkipListIndex {
+	sl := &SkipListIndex{
+		head: newSkipListNode(nil, nil, skipListMaxLevel),
+		lock: new(sync.Mutex),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	sl.height.Store(1)
+	return sl
+}
+
+func newSkipListNode(key []byte, pos *data.LogRecordPos, level int) *skipListNode {
+	node := &skipListNode{
+		key:  key,
+		next: make([]atomic.Pointer[skipListNode], level),
+	}
+	node.pos.Store(pos)
+	return node
+}
+
+// 生成一个新节点的层数
+func (sl *SkipListIndex) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && sl.rand.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
+
+// 找到第一个大于等于key的节点，如果prev不为空，会把每一层上小于key的最后一个节点记录到prev中
+func (sl *SkipListIndex) findGreaterOrEqual(key []byte, prev []*skipListNode) *skipListNode {
+	x := sl.head
+	level := int(sl.height.Load()) - 1
+	for {
+		next := x.next[level].Load()
+		if next != nil && bytes.Compare(next.key, key) < 0 {
+			x = next //继续在当前层往后走
+			continue
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+		level-- //下降一层
+	}
+}
+
+// 找到最后一个小于key的节点，如果不存在则返回nil
+func (sl *SkipListIndex) findLessThan(key []byte) *skipListNode {
+	x := sl.head
+	level := int(sl.height.Load()) - 1
+	for {
+		next := x.next[level].Load()
+		if next != nil && bytes.Compare(next.key, key) < 0 {
+			x = next
+			continue
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// 找到跳表中的最后一个节点，跳表为空时返回nil
+func (sl *SkipListIndex) findLast() *skipListNode {
+	x := sl.head
+	level := int(sl.height.Load()) - 1
+	for {
+		next := x.next[level].Load()
+		if next != nil {
+			x = next
+			continue
+		}
+		if level == 0 {
+			break
+		}
+		level--
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// 向索引中存储key对应的数据的位置
+func (sl *SkipListIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var prev [skipListMaxLevel]*skipListNode
+	node := sl.findGreaterOrEqual(key, prev[:])
+	if node != nil && bytes.Equal(node.key, key) {
+		//key已经存在，直接原子地替换位置信息
+		return node.pos.Swap(pos)
+	}
+
+	level := sl.randomLevel()
+	height := int(sl.height.Load())
+	if level > height {
+		for i := height; i < level; i++ {
+			prev[i] = sl.head
+		}
+		//先更新高度，此时新的层上还没有节点，并发的读者看到的只是一个空层
+		sl.height.Store(int32(level))
+	}
+
+	node = newSkipListNode(key, pos, level)
+	for i := 0; i < level; i++ {
+		//先设置好新节点的后继，再挂到前驱节点上，保证读者不会看到不完整的节点
+		node.next[i].Store(prev[i].next[i].Load())
+		prev[i].next[i].Store(node)
+	}
+	sl.size.Add(1)
+	return nil
+}
+
+// 根据key取出对应的索引位置信息
+func (sl *SkipListIndex) Get(key []byte) *data.LogRecordPos {
+	node := sl.findGreaterOrEqual(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+// 根据key删除对应的索引位置信息
+func (sl *SkipListIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var prev [skipListMaxLevel]*skipListNode
+	node := sl.findGreaterOrEqual(key, prev[:])
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil, false
+	}
+
+	//先标记删除，迭代器会跳过位置信息为nil的节点
+	oldPos := node.pos.Swap(nil)
+	//再从每一层中摘除该节点，节点自身的next指针保持不变
+	for i := len(node.next) - 1; i >= 0; i-- {
+		prev[i].next[i].Store(node.next[i].Load())
+	}
+	sl.size.Add(-1)
+	return oldPos, true
+}
+
+// 返回索引中的数据量
+func (sl *SkipListIndex) Size() int {
+	return int(sl.size.Load())
+}
+
+// 返回一个索引迭代器   跳表的迭代器直接在链表上移动，不需要拷贝数据
+func (sl *SkipListIndex) Iterator(reverse bool) Iterator {
+	sli := &skipListIterator{
+		list:    sl,
+		reverse: reverse,
+	}
+	sli.Rewind()
+	return sli
+}
+
+func (sl *SkipListIndex) Close() error {
+	return nil
+}
+
+// 跳表索引迭代器   对应index.go中的Iterator接口
+type skipListIterator struct {
+	list    *SkipListIndex
+	reverse bool               //是否是反向遍历
+	curr    *skipListNode      //当前遍历到的节点
+	currPos *data.LogRecordPos //定位到当前节点时读取到的位置信息
+}
+
+// 重新回到迭代器的起点，即第一个数据
+func (sli *skipListIterator) Rewind() {
+	if sli.reverse {
+		sli.setCurr(sli.list.findLast())
+	} else {
+		sli.setCurr(sli.list.head.next[0].Load())
+	}
+}
+
+// 根据传入的key查找第一个大于(或小于)等于的目标key，根据这个key开始遍历
+func (sli *skipListIterator) Seek(key []byte) {
+	node := sli.list.findGreaterOrEqual(key, nil)
+	if sli.reverse && (node == nil || !bytes.Equal(node.key, key)) {
+		node = sli.list.findLessThan(key)
+	}
+	sli.setCurr(node)
+}
+
+// 跳转到下一个key
+func (sli *skipListIterator) Next() {
+	if sli.curr == nil {
+		return
+	}
+	if sli.reverse {
+		//单向链表没有前驱指针，反向遍历时重新查找比当前key小的最后一个节点
+		sli.setCurr(sli.list.findLessThan(sli.curr.key))
+	} else {
+		sli.setCurr(sli.curr.next[0].Load())
+	}
+}
+
+// 是否有效，即是否已经遍历完所有的key，用于退出遍历
+func (sli *skipListIterator) Valid() bool {
+	return sli.curr != nil
+}
+
+// 当前遍历位置的key数据
+func (sli *skipListIterator) Key() []byte {
+	return sli.curr.key
+}
+
+// 当前遍历位置的Value数据
+func (sli *skipListIterator) Value() *data.LogRecordPos {
+	return sli.currPos
+}
+
+// 关闭迭代器
+func (sli *skipListIterator) Close() {
+	sli.curr = nil
+	sli.currPos = nil
+}
+
+// 定位到node节点，如果节点已经被删除，则继续按遍历方向跳过
+func (sli *skipListIterator) setCurr(node *skipListNode) {
+	for node != nil {
+		if pos := node.pos.Load(); pos != nil {
+			sli.curr, sli.currPos = node, pos
+			return
+		}
+		if sli.reverse {
+			node = sli.list.findLessThan(node.key)
+		} else {
+			node = node.next[0].Load()
+		}
+	}
+	sli.curr, sli.currPos = nil, nil
+}
@@ -0,0 +1,118 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_IOType(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		activeIOType IOType
+		olderIOType  IOType
+	}{
+		{"active-mmap", MMapIO, StandardIO},
+		{"older-mmap", StandardIO, MMapIO},
+		{"all-mmap", MMapIO, MMapIO},
+		{"direct-io", DirectIO, DirectIO},
+		{"direct-io-active", DirectIO, StandardIO},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptioins
+			dir, _ := os.MkdirTemp("", "bitcask-go-io-type")
+			defer os.RemoveAll(dir)
+			opts.DirPath = dir
+			opts.DataFileSize = 64 * 1024
+			opts.ActiveIOType = tc.activeIOType
+			opts.OlderIOType = tc.olderIOType
+			db, err := OpenDB(opts)
+			assert.Nil(t, err)
+
+			values := make(map[int][]byte)
+			for i := 0; i < 2000; i++ {
+				values[i] = utils.RandomValue(64)
+				assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+			}
+			assert.True(t, len(db.olderFile) > 0)
+			for i := 0; i < 2000; i += 100 {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, values[i], val)
+			}
+			assert.Nil(t, db.Close())
+
+			//关闭之后数据文件都被截断到实际写入的位置
+			for fid := uint32(0); fid <= db.activeFile.FileId; fid++ {
+				stat, err := os.Stat(data.GetDataFileName(dir, fid))
+				assert.Nil(t, err)
+				assert.True(t, stat.Size() <= opts.DataFileSize)
+			}
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			for i := 2000; i < 2100; i++ {
+				values[i] = utils.RandomValue(64)
+				assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+			}
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			assert.Nil(t, db.Close())
+		})
+	}
+}
+
+// 模拟使用mmap写入时崩溃，活跃文件的末尾留下了没有截断的预分配空间
+func TestDB_MMapIO_Recover(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap-recover")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.ActiveIOType = MMapIO
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	fileName := data.GetDataFileName(dir, 0)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	validSize := stat.Size()
+	assert.Nil(t, os.Truncate(fileName, validSize+1024*1024))
+
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, validSize, db.activeFile.WriteOff)
+	assert.Nil(t, db.Put([]byte("after-crash"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("after-crash"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Equal(t, 101, db.index.Size())
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_IOType_Unsupported(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-io-type")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.ActiveIOType = DirectIO + 1
+	_, err := OpenDB(opts)
+	assert.NotNil(t, err)
+
+	opts.ActiveIOType = StandardIO
+	opts.OlderIOType = DirectIO + 1
+	_, err = OpenDB(opts)
+	assert.NotNil(t, err)
+}
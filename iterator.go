@@ -1,27 +1,42 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/index"
 	"bytes"
+	"sync/atomic"
 )
 
 // 面向用户的迭代器
 type Iterator struct {
-	indexIter index.Iterator //索引迭代器
-	db        *DB
-	options   IteratorOptions
+	indexIter  index.Iterator //索引迭代器
+	db         *DB
+	options    IteratorOptions
+	generation uint64 //创建迭代器时merge生效的次数，之后merge生效的话索引迭代器中保存的位置信息就失效了
+	err        error  //创建迭代器时的错误，见Err
 }
 
-// 初始化一个属于db的迭代器
+// 初始化一个属于db的迭代器   当前配置的索引不支持遍历时(例如UnorderedHash)返回一个始终无效的迭代器，Err返回ErrIteratorUnsupported
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 	indexIter := db.index.Iterator(opts.Reverse)
+	var err error
+	if indexIter == nil {
+		indexIter, err = emptyIterator{}, ErrIteratorUnsupported
+	}
 	return &Iterator{
-		indexIter: indexIter,
-		db:        db,
-		options:   opts,
+		indexIter:  indexIter,
+		db:         db,
+		options:    opts,
+		generation: atomic.LoadUint64(&db.mergeGeneration),
+		err:        err,
 	}
 }
 
+// 创建迭代器时的错误   索引不支持遍历时为ErrIteratorUnsupported，此时迭代器中没有任何数据
+func (it *Iterator) Err() error {
+	return it.err
+}
+
 // 重新回到迭代器的起点，即第一个数据
 func (it *Iterator) Rewind() {
 	it.indexIter.Rewind()
@@ -56,6 +71,12 @@ func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.Lock()
 	defer it.db.mu.Unlock()
+	//创建迭代器之后merge已经生效了，重新从索引中取出最新的位置
+	if it.db.mergeGeneration != it.generation {
+		if logRecordPos = it.db.index.Get(it.Key()); logRecordPos == nil {
+			return nil, ErrKeyNotFound
+		}
+	}
 	return it.db.getValueByPosition(logRecordPos)
 }
 
@@ -77,3 +98,14 @@ func (it *Iterator) skipToNext() {
 		}
 	}
 }
+
+// 不支持遍历的索引使用的迭代器，始终无效
+type emptyIterator struct{}
+
+func (emptyIterator) Rewind()                   {}
+func (emptyIterator) Seek(key []byte)           {}
+func (emptyIterator) Next()                     {}
+func (emptyIterator) Valid() bool               { return false }
+func (emptyIterator) Key() []byte               { return nil }
+func (emptyIterator) Value() *data.LogRecordPos { return nil }
+func (emptyIterator) Close()                    {}
@@ -95,3 +95,59 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 		assert.NotNil(t, iter3.Key())
 	}
 }
+
+func TestDB_Iterator_Hash(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-hash")
+	opts.DirPath = dir
+	opts.IndexType = Hash
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"ccde", "aade", "bbde"} {
+		err = db.Put([]byte(key), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	//哈希索引在遍历时会按需排序
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"aade", "bbde", "ccde"}, keys)
+}
+
+func TestDB_Iterator_UnorderedHash(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-unordered-hash")
+	opts.DirPath = dir
+	opts.IndexType = UnorderedHash
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	//不支持遍历
+	err = db.Fold(func(key []byte, value []byte) bool { return true })
+	assert.Equal(t, ErrIteratorUnsupported, err)
+	keys, err := db.ListKeys()
+	assert.Equal(t, ErrIteratorUnsupported, err)
+	assert.Nil(t, keys)
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	defer iterator.Close()
+	assert.Equal(t, ErrIteratorUnsupported, iterator.Err())
+	iterator.Rewind()
+	assert.False(t, iterator.Valid())
+	iterator.Seek(utils.GetTestKey(1))
+	assert.False(t, iterator.Valid())
+}
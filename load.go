@@ -0,0 +1,155 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+)
+
+// 启动时并行加载索引
+// 解码数据文件(读取记录、校验crc)是加载索引时最耗时的部分，并且各个文件之间互不依赖，可以交给多个goroutine并行处理
+// 但是将记录应用到内存索引时必须按照文件id的顺序进行，跨文件的事务、同一个key的多次写入才能得到和顺序加载一样的结果
+
+// 一个数据文件解码之后的结果
+type loadResult struct {
+	records     []*data.HintRecord //文件中从startOffset开始的所有记录
+	startOffset int64              //从文件的哪个位置开始加载
+	endOffset   int64              //读取结束的位置，对于活跃文件来说就是下一次写入的位置
+	fileSize    int64              //数据文件的大小
+	fromHint    bool               //记录是否来自hint文件
+	err         error
+}
+
+// 获取启动时解码数据文件的并发数
+func (db *DB) loadConcurrency() int {
+	if db.options.LoadConcurrency < 1 {
+		return 1
+	}
+	return db.options.LoadConcurrency
+}
+
+// 并行解码dataFiles中的所有数据文件，并按照传入的顺序依次调用apply
+// 同时处于解码完成但还没有被应用状态的文件最多只有LoadConcurrency个，避免占用过多的内存
+func (db *DB) decodeDataFiles(dataFiles []*data.DataFile, cpMeta *checkpointMeta,
+	apply func(dataFile *data.DataFile, result *loadResult) error) error {
+	if len(dataFiles) == 0 {
+		return nil
+	}
+
+	concurrency := db.loadConcurrency()
+	results := make([]chan *loadResult, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *loadResult, 1)
+	}
+	tokens := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case tokens <- struct{}{}: //拿到令牌之后才开始解码下一个文件，令牌在结果被应用之后归还
+			case <-done:
+				return
+			}
+			var offset = dataFile.HeaderSize
+			if cpMeta != nil && dataFile.FileId == cpMeta.fid {
+				offset = cpMeta.offset
+			}
+			go func(i int, dataFile *data.DataFile, offset int64) {
+				results[i] <- db.decodeDataFile(dataFile, offset, dataFile != db.activeFile)
+			}(i, dataFile, offset)
+		}
+	}()
+
+	for i, dataFile := range dataFiles {
+		result := <-results[i]
+		<-tokens
+		if result.err != nil {
+			return result.err
+		}
+		if err := apply(dataFile, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 解码一个数据文件中从offset开始的所有记录   useHint为true时优先使用该文件对应的hint文件
+func (db *DB) decodeDataFile(dataFile *data.DataFile, offset int64, useHint bool) *loadResult {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return &loadResult{err: err}
+	}
+	result := &loadResult{startOffset: offset, fileSize: fileSize}
+
+	if useHint {
+		if records, err := data.ReadHintFile(db.fs, db.options.DirPath, dataFile.FileId, fileSize); err == nil {
+			for _, record := range records {
+				if record.Pos.Offset >= offset {
+					result.records = append(result.records, record)
+				}
+			}
+			result.endOffset = fileSize
+			result.fromHint = true
+			return result
+		}
+	}
+
+	//没有可用的hint文件，读取数据文件中的记录
+	result.records, result.endOffset, result.err = readHintRecords(dataFile, offset)
+	if dataFile == db.activeFile && (result.err == data.ErrInvalidCRC || result.err == nil && result.endOffset < fileSize) {
+		//活跃文件的末尾可能是崩溃时没有完整写入的记录，丢弃它以及之后的数据，启动之后会把文件截断到endOffset
+		//之后还有有效的记录的话说明是文件中间的数据损坏了，不能丢弃
+		torn, err := isTornTail(dataFile, result.endOffset, fileSize)
+		switch {
+		case err != nil:
+			result.err = err
+		case torn:
+			result.err = nil
+		default:
+			result.err = data.ErrInvalidCRC
+		}
+	}
+	return result
+}
+
+// 数据文件在offset处读取失败(crc校验失败或者记录不完整)时，判断它是不是崩溃时没有完整写入的最后一条记录
+// 只有之后再也找不到有效的记录，剩下的只有这条记录的残余以及预分配(或者对齐)补零的空间时才是
+func isTornTail(dataFile *data.DataFile, offset, fileSize int64) (bool, error) {
+	dataEnd, err := lastNonZero(dataFile, offset, fileSize)
+	if err != nil {
+		return false, err
+	}
+	for off := offset + 1; off < dataEnd; off++ {
+		_, _, err := dataFile.ReadLogRecord(off)
+		if err == nil {
+			return false, nil
+		}
+		if err != io.EOF && err != data.ErrInvalidCRC {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 找到[offset, fileSize)中最后一个不为0的字节之后的位置，全部为0时返回offset
+func lastNonZero(dataFile *data.DataFile, offset, fileSize int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for end := fileSize; end > offset; {
+		start := end - int64(len(buf))
+		if start < offset {
+			start = offset
+		}
+		chunk := buf[:end-start]
+		if _, err := dataFile.IoManager.Read(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != 0 {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return offset, nil
+}
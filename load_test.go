@@ -0,0 +1,65 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_LoadConcurrency(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-load-concurrency")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i%700), utils.RandomValue(32))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 700; i += 3 {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	//跨越多个数据文件的事务
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	for i := 700; i < 1500; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	assert.Nil(t, wb.Commit())
+	expected := make(map[string][]byte)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		expected[string(key)] = value
+		return true
+	}))
+	err = db.Close()
+	assert.Nil(t, err)
+
+	check := func(concurrency int, mmap bool) {
+		//删除hint文件，每次都完整地解码数据文件
+		hintFiles, _ := filepath.Glob(filepath.Join(dir, "*"+data.HintFileNameSuffix))
+		for _, hintFile := range hintFiles {
+			assert.Nil(t, os.Remove(hintFile))
+		}
+		opts.LoadConcurrency = concurrency
+		opts.MMapAtStartup = mmap
+		db, err := OpenDB(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), db.index.Size())
+		for key, value := range expected {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		assert.Nil(t, db.Close())
+	}
+	check(1, false)
+	check(8, false)
+	check(8, true)
+	check(3, false)
+}
@@ -0,0 +1,424 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// MANIFEST文件
+// 数据库的状态以前分散在seq-no、merge-finished、hint-index以及目录中的文件名里，启动时只能根据文件名猜测哪些文件是有效的，
+// loadMergeFile在删除旧文件和移动merge生成的文件之间崩溃的话，重启之后会把已经移动了一部分的merge结果当作没有完成的merge删除掉
+// 现在这些状态统一记录在MANIFEST中：数据格式的版本、事务序列号、merge生效的次数、有效的数据文件id，以及还没有应用完成的merge
+// MANIFEST每次都完整地写到临时文件中，sync之后再重命名替换，所以它要么是旧的状态，要么是完整的新状态
+//
+// merge生成的文件准备好之后，先在MANIFEST中记录下需要应用的merge(这一步就是merge的提交点)，然后才开始删除旧文件、移动新文件，
+// 每一步都可以重复执行；全部完成之后再写一次MANIFEST，清除掉记录的merge。期间崩溃的话，重启时根据MANIFEST继续完成剩下的步骤
+// 没有MANIFEST的旧数据目录启动时按照以前的方式处理，加载完成之后补上MANIFEST
+// 批量导入挂载的文件还记录了挂载时的提交时间，这些文件中的记录从这个时间开始才可见(见bulkload.go)
+
+const (
+	manifestKey = "manifest"
+
+	// 当前的数据格式版本
+	dataFormatVersion uint32 = 1
+)
+
+// 记录在MANIFEST中的数据库状态
+type manifest struct {
+	formatVersion   uint32           //数据格式的版本
+	seqNo           uint64           //事务序列号，只在关闭数据库时更新，B+树索引启动时不回放数据文件，从这里恢复
+	mergeGeneration uint64           //全量merge生效的次数
+	files           []uint32         //有效的数据文件id，从小到大排序
+	pendingMerge    *pendingMerge    //已经提交但是还没有应用完成的merge
+	attachTimes     map[uint32]int64 //批量导入挂载的数据文件，以及挂载时的提交时间
+}
+
+// 已经提交但是还没有应用完成的merge
+type pendingMerge struct {
+	nonMergeFileId uint32           //没有参与merge的第一个文件id，比它小的文件都会被merge生成的文件替换
+	mergePath      string           //merge生成的文件所在的目录
+	mergedFiles    map[uint32]int32 //merge生成的数据文件id，以及它应该放到哪个数据目录中(db.dataDirs中的下标)
+}
+
+// 读取MANIFEST   文件不存在时返回nil
+func (db *DB) readManifest() (*manifest, error) {
+	fileName := filepath.Join(db.options.DirPath, data.ManifestFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	manifestFile, err := data.OpenManifestFile(db.fs, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer manifestFile.Close()
+
+	logRecord, _, err := manifestFile.ReadLogRecord(0)
+	if err != nil {
+		return nil, ErrManifestCorrupted
+	}
+	if string(logRecord.Key) != manifestKey {
+		return nil, ErrManifestCorrupted
+	}
+	m, err := decodeManifest(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if m.formatVersion > dataFormatVersion {
+		return nil, ErrUnsupportedFormatVersion
+	}
+	return m, nil
+}
+
+// 写入MANIFEST，先写临时文件，sync之后再重命名
+func (db *DB) writeManifest(m *manifest) error {
+	fileName := filepath.Join(db.options.DirPath, data.ManifestFileName)
+	tmpFileName := fileName + ".tmp"
+	_ = db.fs.Remove(tmpFileName)
+
+	manifestFile, err := data.OpenManifestFile(db.fs, tmpFileName)
+	if err != nil {
+		return err
+	}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte(manifestKey),
+		Value: encodeManifest(m),
+	})
+	writeErr := manifestFile.Write(encRecord)
+	if writeErr == nil {
+		writeErr = manifestFile.Sync()
+	}
+	if err := manifestFile.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = db.fs.Remove(tmpFileName)
+		return writeErr
+	}
+	if err := db.fs.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+	//重命名之后还需要sync所在的目录，否则崩溃之后可能仍然是旧的MANIFEST
+	if err := db.fs.SyncDir(db.options.DirPath); err != nil {
+		return err
+	}
+	db.manifest = m
+	return nil
+}
+
+// 根据当前打开的数据文件更新MANIFEST   在访问此方法前必须持有互斥锁
+func (db *DB) saveManifest() error {
+	return db.writeManifest(db.newManifest(nil))
+}
+
+// 当前状态对应的MANIFEST   在访问此方法前必须持有互斥锁
+func (db *DB) newManifest(pending *pendingMerge) *manifest {
+	m := &manifest{
+		formatVersion: dataFormatVersion,
+		seqNo:         db.seqNo,
+		pendingMerge:  pending,
+	}
+	if db.manifest != nil {
+		m.mergeGeneration = db.manifest.mergeGeneration
+		if db.manifest.seqNo > m.seqNo {
+			m.seqNo = db.manifest.seqNo
+		}
+	}
+	for fid := range db.olderFile {
+		m.files = append(m.files, fid)
+	}
+	if db.activeFile != nil {
+		m.files = append(m.files, db.activeFile.FileId)
+	}
+	sort.Slice(m.files, func(i, j int) bool {
+		return m.files[i] < m.files[j]
+	})
+	for _, fid := range m.files {
+		if t, ok := db.attachTimes[fid]; ok {
+			if m.attachTimes == nil {
+				m.attachTimes = make(map[uint32]int64)
+			}
+			m.attachTimes[fid] = t
+		}
+	}
+	return m
+}
+
+// 提交merge：在MANIFEST中记录下merge生成的文件，之后即使崩溃，重启时也会继续把merge应用完成   在访问此方法前必须持有互斥锁
+func (db *DB) commitMerge(nonMergeFileId uint32, mergePath string) error {
+	pending, err := db.newPendingMerge(nonMergeFileId, mergePath)
+	if err != nil {
+		return err
+	}
+	return db.writeManifest(db.newManifest(pending))
+}
+
+// 扫描merge目录，得到merge生成的数据文件
+func (db *DB) newPendingMerge(nonMergeFileId uint32, mergePath string) (*pendingMerge, error) {
+	pending := &pendingMerge{
+		nonMergeFileId: nonMergeFileId,
+		mergePath:      mergePath,
+		mergedFiles:    make(map[uint32]int32),
+	}
+	for i, dir := range append([]string{mergePath}, db.extraMergeDirs()...) {
+		dirEntries, err := db.fs.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range dirEntries {
+			fileId, ok := parseDataFileId(entry.Name())
+			if !ok || entry.IsDir() {
+				continue
+			}
+			if fileId >= nonMergeFileId {
+				return nil, ErrDatatDirectoryCorrupted
+			}
+			pending.mergedFiles[fileId] = int32(i)
+		}
+	}
+	return pending, nil
+}
+
+// 应用已经提交的merge：删除被替换的旧文件，把merge生成的文件移动到数据目录中，最后更新MANIFEST
+// 每一步都可以重复执行：merge目录中还存在的文件就是还没有移动的文件
+func (db *DB) applyPendingMerge(pending *pendingMerge) error {
+	//旧的位置信息都失效了，检查点和保存的统计信息也需要删除
+	if err := removeCheckpoint(db.fs, db.options.DirPath); err != nil {
+		return err
+	}
+	if err := removeFileStats(db.fs, db.options.DirPath); err != nil {
+		return err
+	}
+	if err := removeVersionRemap(db.fs, db.options.DirPath); err != nil {
+		return err
+	}
+	//以前的merge生成的hint-index也已经过期了
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		if err := db.fs.Remove(filepath.Join(db.options.DirPath, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	extraMergeDirs := db.extraMergeDirs()
+	for fid := uint32(0); fid < pending.nonMergeFileId; fid++ {
+		dirIndex, merged := pending.mergedFiles[fid]
+		var srcPath string
+		if merged {
+			if int(dirIndex) >= len(db.dataDirs) {
+				return ErrManifestCorrupted
+			}
+			srcDir := pending.mergePath
+			if dirIndex > 0 {
+				srcDir = extraMergeDirs[dirIndex-1]
+			}
+			srcPath = data.GetDataFileName(srcDir, fid)
+			if _, err := db.fs.Stat(srcPath); os.IsNotExist(err) {
+				continue //已经移动过了
+			}
+		}
+
+		//删除旧的数据文件以及hint文件   数据文件可能在任意一个数据目录中，也可能被移动到了冷数据目录中
+		for _, dir := range db.allDataDirs() {
+			for _, fileName := range []string{data.GetDataFileName(dir, fid), data.GetCompressedDataFileName(dir, fid)} {
+				if err := db.fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		if err := data.RemoveHintFile(db.fs, db.options.DirPath, fid); err != nil {
+			return err
+		}
+		if !merged {
+			continue
+		}
+
+		//先移动hint文件，再移动数据文件
+		srcHint := data.GetHintFileName(pending.mergePath, fid)
+		if _, err := db.fs.Stat(srcHint); err == nil {
+			if err := db.moveMergeFile(srcHint, data.GetHintFileName(db.options.DirPath, fid)); err != nil {
+				return err
+			}
+		}
+		if err := db.moveMergeFile(srcPath, data.GetDataFileName(db.dataDirs[dirIndex], fid)); err != nil {
+			return err
+		}
+	}
+
+	//删除和移动文件都持久化之后才能清除MANIFEST中记录的merge
+	if err := db.syncDirs(append(append(db.allDataDirs(), pending.mergePath), extraMergeDirs...)...); err != nil {
+		return err
+	}
+
+	//merge已经应用完成
+	m := &manifest{
+		formatVersion: dataFormatVersion,
+		seqNo:         db.seqNo,
+	}
+	if db.manifest != nil {
+		if db.manifest.seqNo > m.seqNo {
+			m.seqNo = db.manifest.seqNo
+		}
+		m.mergeGeneration = db.manifest.mergeGeneration
+		for _, fid := range db.manifest.files {
+			if fid >= pending.nonMergeFileId {
+				m.files = append(m.files, fid)
+			}
+		}
+		//merge生成的文件中的记录已经使用了挂载的时间，只需要保留没有参与merge的文件
+		for fid, t := range db.manifest.attachTimes {
+			if fid >= pending.nonMergeFileId {
+				if m.attachTimes == nil {
+					m.attachTimes = make(map[uint32]int64)
+				}
+				m.attachTimes[fid] = t
+			}
+		}
+	}
+	m.mergeGeneration++
+	for fid := range pending.mergedFiles {
+		m.files = append(m.files, fid)
+	}
+	sort.Slice(m.files, func(i, j int) bool {
+		return m.files[i] < m.files[j]
+	})
+	return db.writeManifest(m)
+}
+
+// 按照MANIFEST过滤扫描到的数据文件   不在MANIFEST中的文件是新建之后还没有来得及记录到MANIFEST中就崩溃了，其中不会有数据，直接删除
+// 批量导入的文件也是先移动到数据目录中再记录到MANIFEST中，没有提交的导入同样在这里删除
+func (db *DB) checkManifestFiles(fileDirs map[uint32]string, compressed map[uint32]bool) error {
+	if db.manifest == nil {
+		return nil
+	}
+	files := make(map[uint32]bool)
+	for _, fid := range db.manifest.files {
+		if _, ok := fileDirs[fid]; !ok {
+			return ErrDatatDirectoryCorrupted
+		}
+		files[fid] = true
+	}
+	for fid, dir := range fileDirs {
+		if files[fid] {
+			continue
+		}
+		fileName := data.GetDataFileName(dir, fid)
+		if compressed[fid] {
+			fileName = data.GetCompressedDataFileName(dir, fid)
+		}
+		if err := db.fs.Remove(fileName); err != nil {
+			return err
+		}
+		if err := data.RemoveHintFile(db.fs, db.options.DirPath, fid); err != nil {
+			return err
+		}
+		delete(fileDirs, fid)
+		delete(compressed, fid)
+	}
+	return nil
+}
+
+// 对MANIFEST进行编码
+func encodeManifest(m *manifest) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64*(5+len(m.files)))
+	buf = binary.AppendUvarint(buf, uint64(m.formatVersion))
+	buf = binary.AppendUvarint(buf, m.seqNo)
+	buf = binary.AppendUvarint(buf, m.mergeGeneration)
+	buf = binary.AppendUvarint(buf, uint64(len(m.files)))
+	for _, fid := range m.files {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+	}
+	if m.pendingMerge == nil {
+		buf = append(buf, 0)
+	} else {
+		pending := m.pendingMerge
+		buf = append(buf, 1)
+		buf = binary.AppendUvarint(buf, uint64(pending.nonMergeFileId))
+		buf = binary.AppendUvarint(buf, uint64(len(pending.mergePath)))
+		buf = append(buf, pending.mergePath...)
+		buf = binary.AppendUvarint(buf, uint64(len(pending.mergedFiles)))
+		for fid, dirIndex := range pending.mergedFiles {
+			buf = binary.AppendUvarint(buf, uint64(fid))
+			buf = binary.AppendUvarint(buf, uint64(dirIndex))
+		}
+	}
+	//挂载时间放在最后，以前的版本写入的MANIFEST中没有这一部分
+	if len(m.attachTimes) == 0 {
+		return buf
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(m.attachTimes)))
+	for fid, t := range m.attachTimes {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+		buf = binary.AppendVarint(buf, t)
+	}
+	return buf
+}
+
+// 对MANIFEST进行解码
+func decodeManifest(buf []byte) (*manifest, error) {
+	var index = 0
+	var corrupted bool
+	readUvarint := func() uint64 {
+		if corrupted || index >= len(buf) {
+			corrupted = true
+			return 0
+		}
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			corrupted = true
+			return 0
+		}
+		index += n
+		return v
+	}
+	m := &manifest{}
+	m.formatVersion = uint32(readUvarint())
+	m.seqNo = readUvarint()
+	m.mergeGeneration = readUvarint()
+	count := readUvarint()
+	for i := uint64(0); i < count && !corrupted; i++ {
+		m.files = append(m.files, uint32(readUvarint()))
+	}
+	if readUvarint() == 1 {
+		pending := &pendingMerge{mergedFiles: make(map[uint32]int32)}
+		pending.nonMergeFileId = uint32(readUvarint())
+		pathLen := readUvarint()
+		if corrupted || uint64(len(buf)-index) < pathLen {
+			return nil, ErrManifestCorrupted
+		}
+		pending.mergePath = string(buf[index : index+int(pathLen)])
+		index += int(pathLen)
+		count = readUvarint()
+		for i := uint64(0); i < count && !corrupted; i++ {
+			fid := uint32(readUvarint())
+			pending.mergedFiles[fid] = int32(readUvarint())
+		}
+		m.pendingMerge = pending
+	}
+	if !corrupted && index < len(buf) {
+		m.attachTimes = make(map[uint32]int64)
+		count = readUvarint()
+		for i := uint64(0); i < count && !corrupted; i++ {
+			fid := uint32(readUvarint())
+			if index >= len(buf) {
+				corrupted = true
+				break
+			}
+			t, n := binary.Varint(buf[index:])
+			if n <= 0 {
+				corrupted = true
+				break
+			}
+			index += n
+			m.attachTimes[fid] = t
+		}
+	}
+	if corrupted {
+		return nil, ErrManifestCorrupted
+	}
+	return m, nil
+}
@@ -0,0 +1,156 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Manifest(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-manifest")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(0), utils.RandomValue(64)))
+	assert.Nil(t, wb.Commit())
+	checkManifest := func(db *DB) *manifest {
+		m, err := db.readManifest()
+		assert.Nil(t, err)
+		assert.NotNil(t, m)
+		assert.Nil(t, m.pendingMerge)
+		assert.Equal(t, dataFormatVersion, m.formatVersion)
+		fileDirs, _, err := db.scanDataFiles()
+		assert.Nil(t, err)
+		assert.Equal(t, len(fileDirs), len(m.files))
+		for _, fid := range m.files {
+			_, ok := fileDirs[fid]
+			assert.True(t, ok)
+		}
+		return m
+	}
+	//每次新建数据文件都会记录到MANIFEST中
+	m := checkManifest(db)
+	assert.True(t, len(m.files) > 1)
+	assert.Equal(t, db.activeFile.FileId, m.files[len(m.files)-1])
+
+	//关闭时记录事务序列号
+	seqNo := db.seqNo
+	assert.True(t, seqNo > 0)
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	m = checkManifest(db)
+	assert.Equal(t, seqNo, m.seqNo)
+	assert.Equal(t, uint64(0), m.mergeGeneration)
+
+	//merge生效之后记录新的文件，以及merge的次数
+	assert.Nil(t, db.Merge())
+	m = checkManifest(db)
+	assert.Equal(t, uint64(1), m.mergeGeneration)
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, uint64(2), checkManifest(db).mergeGeneration)
+	assert.Nil(t, db.Close())
+	m, err = db.readManifest()
+	assert.Nil(t, err)
+
+	//不在MANIFEST中的数据文件是崩溃之前刚刚新建的，启动时删除
+	strayFile := data.GetDataFileName(dir, m.files[len(m.files)-1]+1)
+	assert.Nil(t, os.WriteFile(strayFile, nil, 0644))
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(strayFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 1000, db.index.Size())
+	assert.Nil(t, db.Close())
+
+	//旧的数据目录没有MANIFEST，启动时补上
+	assert.Nil(t, os.Remove(filepath.Join(dir, data.ManifestFileName)))
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, db.index.Size())
+	checkManifest(db)
+	assert.Nil(t, db.Close())
+
+	//MANIFEST中记录的文件不存在
+	m, err = db.readManifest()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(data.GetDataFileName(dir, m.files[0])))
+	_, err = OpenDB(opts)
+	assert.Equal(t, ErrDatatDirectoryCorrupted, err)
+}
+
+func TestDB_Manifest_Invalid(t *testing.T) {
+	prepare := func(t *testing.T) (*DB, Option) {
+		opts := DefaultOptioins
+		dir, _ := os.MkdirTemp("", "bitcask-go-manifest")
+		opts.DirPath = dir
+		db, err := OpenDB(opts)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(64)))
+		assert.Nil(t, db.Close())
+		return db, opts
+	}
+
+	//更新的版本写入的数据目录
+	db, opts := prepare(t)
+	defer Destroy_DB(db)
+	m := db.newManifest(nil)
+	m.formatVersion = dataFormatVersion + 1
+	assert.Nil(t, db.writeManifest(m))
+	_, err := OpenDB(opts)
+	assert.Equal(t, ErrUnsupportedFormatVersion, err)
+
+	//损坏的MANIFEST
+	db, opts = prepare(t)
+	defer Destroy_DB(db)
+	assert.Nil(t, os.WriteFile(filepath.Join(opts.DirPath, data.ManifestFileName), []byte("corrupted manifest"), 0644))
+	_, err = OpenDB(opts)
+	assert.Equal(t, ErrManifestCorrupted, err)
+}
+
+func TestManifest_Encode(t *testing.T) {
+	m := &manifest{
+		formatVersion:   dataFormatVersion,
+		seqNo:           1024,
+		mergeGeneration: 3,
+		files:           []uint32{0, 1, 5, 6},
+		pendingMerge: &pendingMerge{
+			nonMergeFileId: 5,
+			mergePath:      filepath.Join("tmp", "bitcask_merge"),
+			mergedFiles:    map[uint32]int32{0: 0, 1: 1},
+		},
+	}
+	decoded, err := decodeManifest(encodeManifest(m))
+	assert.Nil(t, err)
+	assert.Equal(t, m, decoded)
+
+	m.pendingMerge = nil
+	buf := encodeManifest(m)
+	decoded, err = decodeManifest(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, m, decoded)
+
+	_, err = decodeManifest(buf[:len(buf)-2])
+	assert.Equal(t, ErrManifestCorrupted, err)
+
+	//批量导入挂载的文件的提交时间
+	m.attachTimes = map[uint32]int64{5: 1700000000000000000, 6: 1700000000000000001}
+	buf = encodeManifest(m)
+	decoded, err = decodeManifest(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, m, decoded)
+	_, err = decodeManifest(buf[:len(buf)-1])
+	assert.Equal(t, ErrManifestCorrupted, err)
+}
@@ -0,0 +1,112 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_InMemory(t *testing.T) {
+	opts := DefaultOptioins
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-in-memory")
+	opts.DataFileSize = 32 * 1024
+	opts.InMemory = true
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+
+	//不会在磁盘上创建任何文件
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	//每个实例使用单独的内存文件系统，相同的DirPath也互不影响
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db2.index.Size())
+	assert.Nil(t, db2.Put(utils.GetTestKey(1500), []byte("db2")))
+	val, err := db.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1500), val)
+	assert.Nil(t, db2.Close())
+
+	//merge之后数据仍然在内存文件系统中
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, 1001, db.index.Size())
+	val, err = db.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1500), val)
+	stat := db.Stat()
+	assert.True(t, stat.DiskSize > 0)
+	assert.True(t, stat.ReclaimableSize == 0)
+
+	//关闭之后内存文件系统被释放，重新打开是一个空的数据库
+	assert.Nil(t, db.Close())
+	_, err = db.fs.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.index.Size())
+}
+
+// 通过FS传入同一个内存文件系统，关闭之后重新打开仍然可以读到数据
+func TestDB_InMemory_SharedFS(t *testing.T) {
+	opts := DefaultOptioins
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-in-memory-shared")
+	opts.DataFileSize = 32 * 1024
+	opts.FS = vfs.NewMemFS()
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+
+	//同一个文件系统中的同一个目录不能被同时打开
+	_, err = OpenDB(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, db.index.Size())
+	val, err := db.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1500), val)
+	_, err = db.Get(utils.GetTestKey(500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), val)
+}
+
+func TestDB_InMemory_BPlusTree(t *testing.T) {
+	opts := DefaultOptioins
+	opts.DirPath = "bitcask-go-in-memory-bptree"
+	opts.InMemory = true
+	opts.IndexType = BPLusTree
+	_, err := OpenDB(opts)
+	assert.NotNil(t, err)
+}
@@ -3,54 +3,82 @@ package bitcask_go
 import (
 	"bitcask-go/data"
 	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"context"
+	"errors"
 	"io"
+	"math"
 	"os"
-	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 const (
-	mergeDirName     = "_merge"         //这个用来新建一个临时文件夹用于merge的
-	mergeFinishedKey = "merge.finished" //这个标识当前merge过程结束
+	mergeDirName = "_merge" //这个用来新建一个临时文件夹用于merge的
 )
 
 // 清理无效数据，生成Hint文件
 // Merge完成的操作主要就是，会在磁盘上新建一个merge的临时目录，主要将olderfile遍历，同时根据db的内存索引进行比较，将好的数据先复制粘贴过来，同时生成hint文件
 // 这个时候，不影响原来的db继续在activefile上进行读写操作，完成数据的清理之后，再将临时文件上的内容copy到原数据库文件目录中
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background(), db.defaultMergeOptions())
+}
+
+// MergeContext 全量merge，可以通过ctx取消，按照opts.BytesPerSecond限速并通过opts.Progress报告进度
+// 全量merge处理所有的旧数据文件，opts中选择文件的配置(GarbageRatio、MaxBytes、FileIds)不生效，需要选择文件时使用MergeWithOptions
+// 被取消或者失败时已经生成的文件会被删除，数据库保持merge之前的状态
+func (db *DB) MergeContext(ctx context.Context, opts MergeOptions) error {
+	return db.merge(ctx, opts, true)
+}
+
+// 没有指定限速的merge(Merge、Upgrade以及配额不足时自动触发的merge)使用的配置
+func (db *DB) defaultMergeOptions() MergeOptions {
+	return MergeOptions{BytesPerSecond: db.options.MergeBytesPerSecond}
+}
+
+// checkRatio为false时不检查可以回收的数据量是否达到了DataFileMergeRatio(配额不足时自动触发的merge)
+func (db *DB) merge(ctx context.Context, opts MergeOptions, checkRatio bool) (err error) {
 	//如果数据库为空，直接返回
 	if db.activeFile == nil {
 		return nil
 	}
+	//等待正在进行的冷数据文件移动结束，merge期间不再移动
+	db.tierLock.Lock()
+	defer db.tierLock.Unlock()
 	db.mu.Lock()
 	//如果merge正在进行当中，直接返回即可
 	if db.isMerging {
 		db.mu.Unlock()
 		return ErrMergeIsProcess
 	}
+	//导出期间数据文件不能重写
+	if atomic.LoadInt32(&db.exportPins) > 0 {
+		db.mu.Unlock()
+		return ErrExportIsProcess
+	}
 
 	//查看可以merge的数据量是否达到了阈值
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	totalSize, err := db.dirSize()
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	if checkRatio && float32(db.reclaimableSize())/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
 
-	//查看当前磁盘剩余空间是否可以容纳merge之后的数据量
-	availableDiskSize, err := utils.AvailableDiskSize()
-	if err != nil {
-		db.mu.Unlock()
-		return err
-	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
-		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+	//查看merge写入的每个目录的剩余空间是否可以容纳分到这个目录的数据，并且merge之后仍然不低于低水位   数据不在操作系统的文件系统中时不占用磁盘
+	if db.fs == vfs.OS {
+		if err := db.checkMergeSpace(uint64(totalSize - db.reclaimableSize())); err != nil {
+			db.mu.Unlock()
+			return err
+		}
 	}
 
 	db.isMerging = true
@@ -62,18 +90,23 @@ func (db *DB) Merge() error {
 	//总的merge流程：1、对当前活跃文件进行处理(持久化并转为旧的文件)，然后打开新的活跃文件;
 	//				2、取出所有需要merge的文件
 	//				3、新建一个mergeDB，用于对需要merge的文件进行处理
-	if err := db.activeFile.Sync(); err != nil {
+	//将当前活跃文件转换为旧的活跃文件，再打开一个新的活跃文件，用户将此后的操作在这个新的活跃文件上进行
+	//merge生成的文件id从0开始，新的活跃文件跳过merge最多会用到的id，生成的文件再多也不会和之后的文件冲突
+	mergeFileNum, err := db.maxMergeFileNum()
+	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	//将当前活跃文件转换为旧的活跃文件
-	db.olderFile[db.activeFile.FileId] = db.activeFile
-	//再打开一个新的活跃文件，用户将此后的操作在这个新的活跃文件上进行
-	if err := db.setActiveDataFile(); err != nil {
+	db.minFileId = mergeFileNum
+	err = db.rotateActiveDataFile()
+	db.minFileId = 0
+	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
 	nonMergeFileId := db.activeFile.FileId //记录当前活跃文件的id，标识这是最近的没有参与merge的数据文件
+	//需要保留的历史版本以在这个时间之后仍然有效为准
+	cutoff := time.Now().Add(-db.options.VersionRetention).UnixNano()
 
 	//取出所有需要的merge的文件
 	var mergeFiles []*data.DataFile
@@ -87,37 +120,68 @@ func (db *DB) Merge() error {
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
+	run := db.startMergeRun(ctx, opts, len(mergeFiles))
+	defer func() {
+		run.finish(err)
+	}()
 
 	mergePath := db.getMergePath()
 	//判断当前目录是否存在，是的话要将里面的内容删除掉    不是的话就新建这样一个目录
-	if _, err := os.Stat(mergePath); err == nil {
-		if err := os.RemoveAll(mergePath); err != nil {
+	for _, dir := range append([]string{mergePath}, db.extraMergeDirs()...) {
+		if err := db.removeMergeDir(dir); err != nil {
 			return err
 		}
 	}
 
 	//新建一个merge path目录     os.ModePerm标识777，最大的读、写、执行权限
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+	if err := db.fs.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
 	//打开一个新的临时bitcask实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
+	mergeOptions.MergeDir = ""      //临时实例不会再进行merge，使用默认的merge目录，避免和当前的merge目录相同
 	mergeOptions.SyncWrites = false //中途merge的时候万一失败了，我们直接认为本次merge失败，不需要使用sync操作
+	mergeOptions.IndexCheckpoint = false
+	mergeOptions.MaxDiskSize = 0 //merge的过程中临时多占用的空间不受配额的限制
+	mergeOptions.MaxKeys = 0
+	mergeOptions.DataDirs = db.extraMergeDirs() //merge生成的数据文件同样分散放在各个数据目录中
+	mergeOptions.ColdDir = ""                   //merge生成的文件都放在数据目录中，之后再按照策略移动到冷数据目录
 	mergeDB, err := OpenDB(mergeOptions)
 	if err != nil {
 		return err
 	}
+	//应用merge之前被取消或者失败的话，关闭临时实例并删除已经生成的文件
+	mergeClosed := false
+	defer func() {
+		if err == nil || mergeClosed {
+			return
+		}
+		_ = mergeDB.Close()
+		for _, dir := range append([]string{mergePath}, db.extraMergeDirs()...) {
+			_ = db.removeMergeDir(dir)
+		}
+	}()
+	//merge生成的文件会替换同一个id的旧文件，序号需要和所有旧文件都不同
+	mergeDB.observeFileSerial(atomic.LoadUint32(&db.fileSerial))
 
-	//打开Hint文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
-	if err != nil {
-		return err
+	//配置了历史版本的保留时长时，先找到需要保留的历史版本，并记录每条被重写的记录的新位置，用来更新版本指针
+	var retained map[versionPos]bool
+	var moved map[versionPos]*movedVersion
+	if db.options.VersionRetention > 0 {
+		inMerge := func(fid uint32) bool {
+			return fid < nonMergeFileId
+		}
+		if retained, err = db.retainedVersions(run, mergeFiles, inMerge, cutoff); err != nil {
+			return err
+		}
+		moved = make(map[versionPos]*movedVersion)
 	}
 
-	//遍历处理每个数据文件
+	//遍历处理每个数据文件   被压缩过滤器丢弃的记录在应用merge的结果时从索引中删除
+	var filtered []*filteredRecord
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		var offset = dataFile.HeaderSize
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
@@ -126,133 +190,388 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
+			if err := run.read(size); err != nil {
+				return err
+			}
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
+			oldPos := versionPos{fid: dataFile.FileId, offset: offset}
 			//这里判断文件是否有效的逻辑：位置信息不能为空    数据文件id得对得上     偏移量也得对得上    无效的话就直接跳过了
-			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
+			current := logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset
+			if current || retained[oldPos] {
+				db.mu.RLock()
+				relinked := db.relinkVersion(logRecord, moved)
+				db.mu.RUnlock()
+				if current && !db.filterRecord(realKey, logRecord) {
+					record := &filteredRecord{key: realKey, pos: logRecordPos}
+					//保留了历史版本的key需要写入墓碑值，否则重启之后历史版本会重新生效
+					if relinked {
+						if record.tombstone, err = mergeDB.appendLogRecord(&data.LogRecord{
+							Key:       logRecordKeyWithSeq(realKey, nonTransactionSeqNo),
+							Type:      data.LogRecordDeleted,
+							Timestamp: time.Now().UnixNano(),
+							Prev:      logRecord.Prev,
+						}); err != nil {
+							return err
+						}
+					}
+					filtered = append(filtered, record)
+					run.record(false)
+					offset += size
+					continue
+				}
 				//	清楚事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				//批量导入的记录写入挂载时的提交时间，merge之后不再需要单独记录
+				db.applyAttachTime(dataFile.FileId, logRecord)
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
 					return err
 				}
-				//将当前位置索引写到hint文件中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				if moved != nil {
+					moved[oldPos] = &movedVersion{
+						key:     realKey,
+						ptr:     &data.VersionPtr{Pos: *pos, Serial: mergeDB.activeFile.Header.Serial},
+						history: !current,
+					}
+				}
+				if err := run.write(int64(pos.Size)); err != nil {
 					return err
 				}
+				run.record(true)
+			} else {
+				run.record(false)
 			}
 			//递增offset
 			offset += size
 		}
+		run.fileDone()
 	}
 
 	//sync保证持久化
-	if err := hintFile.Sync(); err != nil {
+	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
-
-	if err := mergeDB.Sync(); err != nil {
+	//merge生成的数据文件也需要hint文件，写满的文件在转换时已经在后台生成了，这里补上最后一个文件
+	if mergeDB.activeFile != nil {
+		if err := mergeDB.writeHintFile(mergeDB.activeFile); err != nil {
+			return err
+		}
+	}
+	mergeSerial := atomic.LoadUint32(&mergeDB.fileSerial)
+	if err := mergeDB.Close(); err != nil {
 		return err
 	}
-	//写表示merge完成的文件   写在当前的activeFile中
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
-	if err != nil {
+	mergeClosed = true
+	db.observeFileSerial(mergeSerial)
+	//merge已经完成，直接应用到当前的数据库中，回收空间不需要等到下一次启动
+	return db.applyMerge(nonMergeFileId, filtered, moved)
+}
+
+// 将merge的结果应用到当前打开的数据库中
+// 参与merge的旧文件被替换为merge生成的数据文件，内存索引中仍然指向旧文件的位置更新为新文件中的位置
+// merge期间新写入或者删除的key在索引中指向的都是nonMergeFileId及之后的文件，不会受到影响
+// moved不为空时记录了每条被重写的记录的新位置，用来更新墓碑值的位置以及versionRemap
+func (db *DB) applyMerge(nonMergeFileId uint32, filtered []*filteredRecord, moved map[versionPos]*movedVersion) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	//后台生成hint文件的任务可能还在读取旧文件，等待它们结束，避免之后覆盖merge生成的hint文件
+	db.hintWg.Wait()
+
+	//提交merge   从这里开始即使崩溃，重启之后也会继续把merge应用完成
+	if err := db.commitMerge(nonMergeFileId, db.getMergePath()); err != nil {
 		return err
 	}
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))), //这里就记录最近没有参与merge的文件的id
+
+	//关闭参与merge的旧文件
+	var err error
+	var oldSize int64
+	oldSerials := make(map[uint32]uint32)
+	for fid, dataFile := range db.olderFile {
+		if fid >= nonMergeFileId {
+			continue
+		}
+		oldSerials[fid] = dataFile.Header.Serial
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		oldSize += size
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+		delete(db.olderFile, fid)
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
+
+	//和启动的时候一样，删除旧文件并把merge生成的文件移动到数据目录中
+	//检查点中的位置信息也会失效，移动的过程中不能有正在写入的检查点
+	db.checkpointLock.Lock()
+	err = db.loadMergeFile()
+	atomic.AddUint64(&db.mergeGeneration, 1)
+	db.checkpointLock.Unlock()
+	if err != nil {
 		return err
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
+
+	//打开merge生成的数据文件，更新内存索引   merge生成的文件id从0开始连续递增
+	fileDirs, _, err := db.scanDataFiles()
+	if err != nil {
 		return err
 	}
+	var newSize int64
+	//被压缩过滤器丢弃的key没有写入merge生成的文件，在更新索引之前删除，否则会指向新文件中错误的位置
+	db.removeFilteredKeys(filtered)
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		delete(db.fileDirs, fid)
+		delete(db.compressedFiles, fid)
+		delete(db.liveSize, fid)
+		delete(db.attachTimes, fid)
+	}
+	//作为历史版本重写的记录不能更新到索引中
+	history := make(map[versionPos]bool)
+	for _, mv := range moved {
+		if mv.history {
+			history[versionPos{fid: mv.ptr.Pos.Fid, offset: mv.ptr.Pos.Offset}] = true
+		}
+	}
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		dir, ok := fileDirs[fid]
+		if !ok {
+			continue
+		}
+		db.fileDirs[fid] = dir
+		dataFile, err := data.OpenDataFile(db.fs, dir, fid, fileIOType(db.options.OlderIOType))
+		if err != nil {
+			return err
+		}
+		db.olderFile[fid] = dataFile
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		newSize += size
+
+		records, err := data.ReadHintFile(db.fs, db.options.DirPath, fid, size)
+		if err != nil { //hint文件不可用的时候直接扫描数据文件
+			if records, _, err = readHintRecords(dataFile, dataFile.HeaderSize); err != nil {
+				return err
+			}
+		}
+		for _, record := range records {
+			if record.Type != data.LogRecordNormal || history[versionPos{fid: fid, offset: record.Pos.Offset}] {
+				continue
+			}
+			realKey, _ := parseLogRecordKey(record.Key)
+			if pos := db.index.Get(realKey); pos != nil && pos.Fid < nonMergeFileId {
+				db.index.Put(realKey, record.Pos)
+				db.liveSize[fid] += int64(record.Pos.Size)
+			}
+		}
+	}
+
+	db.dataSize += newSize - oldSize
+	db.applyMovedVersions(nonMergeFileId, filtered, moved, oldSerials)
+	//merge之后文件id被重新使用了，保存的统计信息需要更新   保存失败也没有关系，下一次启动时会重新统计
+	_ = db.writeFileStats()
+	return nil
+}
+
+// 检查merge写入的每个目录所在的磁盘是否有足够的剩余空间   liveSize是merge之后的数据量
+// merge生成的文件和数据文件一样按照DataDirPlacement分散放到各个merge目录中：轮流放置时每个目录分到其中的一份(多算一个文件，补上不能整除的部分)，
+// 按照剩余空间放置时文件总是放到剩余空间最多的目录中，只要求所有目录加起来足够
+func (db *DB) checkMergeSpace(liveSize uint64) error {
+	dirs := append([]string{db.getMergePath()}, db.extraMergeDirs()...)
+	lowWaterMark := db.options.DiskLowWaterMark
+	share := liveSize
+	if len(dirs) > 1 {
+		share = liveSize/uint64(len(dirs)) + uint64(db.options.DataFileSize)
+	}
+	var total uint64
+	for _, dir := range dirs {
+		//merge目录在开始merge之前可能还不存在，查询它所在的上级目录
+		for {
+			if _, err := db.fs.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		available, err := availableDiskSize(dir)
+		if err != nil {
+			return err
+		}
+		if db.options.DataDirPlacement != PlaceByFreeSpace && share+lowWaterMark >= available {
+			return ErrNoEnoughSpaceForMerge
+		}
+		if available > lowWaterMark {
+			total += available - lowWaterMark
+		}
+	}
+	if liveSize >= total {
+		return ErrNoEnoughSpaceForMerge
+	}
 	return nil
 }
 
+// merge最多会生成多少个数据文件   在访问此方法前必须持有互斥锁
+// 重写的记录最多比原来多出一个完整的头部(升级旧格式的文件时会加上版本信息，校验值也可能变长)，墓碑值也不会比被它替换的记录更长，
+// 每条记录至少有MinLogRecordSize字节，所以生成的数据总量不超过 原来的大小 * (1 + MaxLogRecordHeaderSize/MinLogRecordSize)
+// merge实例只在放不下下一条记录时才切换文件，相邻两个文件中的记录加起来一定超过了DataFileSize减去文件头的大小
+func (db *DB) maxMergeFileNum() (uint32, error) {
+	var size int64
+	if db.activeFile != nil {
+		size += db.activeFile.WriteOff
+	}
+	for _, dataFile := range db.olderFile {
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		size += fileSize
+	}
+	size += size / data.MinLogRecordSize * data.MaxLogRecordHeaderSize
+	capacity := db.options.DataFileSize - data.FileHeaderSize
+	if capacity < 1 {
+		capacity = 1
+	}
+	fileNum := 2*size/capacity + 2
+	if fileNum > math.MaxUint32/2 {
+		return 0, ErrNoEnoughSpaceForMerge
+	}
+	return uint32(fileNum), nil
+}
+
 // 针对当前存储引擎的目录进行merge
 // 需要的结构/tmp/bitcask
 //
 //	/tmp/bitcask_merge
+//
+// 配置了Option.MergeDir时直接使用配置的目录
 func (db *DB) getMergePath() string {
-	//dir := path.Dir(path.Clean(db.options.DirPath)) //clean表示将多余的斜杠去掉    Dir函数作用是拿到父目录
-	base := path.Base(db.options.DirPath) //拿到名字，比如 /tmp/bitcask这个目录，就会返回bitcask这个名字
-	//return filepath.Join(dir, base+mergeDirName)
-	return filepath.Join(base + mergeDirName)
+	if db.options.MergeDir != "" {
+		return db.options.MergeDir
+	}
+	//和数据目录放在同一个父目录下，不同父目录下同名的数据目录不会冲突
+	return filepath.Clean(db.options.DirPath) + mergeDirName
 }
 
-// 加载merge数据目录
-func (db *DB) loadMergeFile() error {
-	mergePath := db.getMergePath() //经过这一段得到的mergePath，输入到os.ReadDir中时，会报错 得到的字符串：.\C:\Users\16005\AppData\Local\Temp\bitcask-go-http3499270860_merge
-	//mergePath := "G:\\GO_Project\\kv_project\\tmp\\http_merge"
-	//merge目录不存在的话直接返回
-	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+// 删除merge目录   merge目录可能是用户配置的，只有里面全部是merge生成的文件时才删除，避免误删其他的数据
+func (db *DB) removeMergeDir(dir string) error {
+	dirEntries, err := db.fs.ReadDir(dir)
+	if os.IsNotExist(err) {
 		return nil
 	}
-	defer func() {
-		_ = os.RemoveAll(mergePath)
-	}() //后面还会进行移除文件的操作
-
-	//接下来就是整个merge目录都存在，需要简化merge数据读取出来
-	dirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
-	//查看表示merge完成的文件，判断merge是否完成了
-	var mergeFinished bool
-	var mergeFileNames []string
 	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName { //data.MergeFinishedFileName为"merge-finished"
-			mergeFinished = true
-		}
-		if entry.Name() == data.SeqNoFileName { //"seq-no"
-			continue
-		}
-		if entry.Name() == fileLockName {
-			continue
+		if entry.IsDir() || !isMergeFileName(entry.Name()) {
+			return ErrMergeDirNotEmpty
 		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
+	return db.fs.RemoveAll(dir)
+}
 
-	if !mergeFinished { //读完了merge目录下的文件也没读到merge完成的标识，标识上一次merge出错了，直接返回就好了
-		return nil
-	}
+// 是否是merge过程中会生成的文件
+func isMergeFileName(name string) bool {
+	switch name {
+	case data.MergeFinishedFileName, data.SeqNoFileName, data.HintFileName, data.CheckpointFileName, data.FileStatsFileName, data.ManifestFileName, fileLockName:
+		return true
+	}
+	return strings.HasSuffix(name, data.DataFileNameSuffix) ||
+		strings.HasSuffix(name, data.HintFileNameSuffix) ||
+		strings.HasSuffix(name, ".tmp")
+}
 
-	//接下来就是使用merge完成之后的文件替换掉原来的olderFile    得到最近没有进行merge操作的文件
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
-	if err != nil {
+// 把merge生成的文件移动到数据目录中
+// merge目录和数据目录在同一个文件系统上时直接重命名；不在同一个文件系统上时不能重命名，先拷贝到数据目录中的临时文件，再重命名替换
+func (db *DB) moveMergeFile(src, dest string) error {
+	err := db.fs.Rename(src, dest)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	tmp := dest + ".tmp"
+	if err := utils.CopyFile(db.fs, src, tmp); err != nil {
+		_ = db.fs.Remove(tmp)
+		return err
+	}
+	if err := db.fs.Rename(tmp, dest); err != nil {
+		return err
+	}
+	//拷贝出来的文件持久化之后才能删除源文件
+	if err := db.fs.SyncDir(filepath.Dir(dest)); err != nil {
 		return err
 	}
+	return db.fs.Remove(src)
+}
 
-	//删除旧的数据文件  只能删除id比nonMergeFileId更小的数据文件，  id比nonMergeFileId大表示这是merge发生之后新增的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
-			} //如果数据文件存在就删除掉
+// 加载merge数据目录
+// MANIFEST中记录了已经提交的merge时，继续把它应用完成；没有记录的话merge目录中的内容都是没有完成的merge留下的，直接删除
+// 没有MANIFEST的旧数据目录仍然根据merge目录中的merge-finished文件判断merge是否完成，先补上MANIFEST再应用
+func (db *DB) loadMergeFile() error {
+	mergePath := db.getMergePath()
+	var pending *pendingMerge
+	if db.manifest != nil {
+		pending = db.manifest.pendingMerge
+	} else {
+		var err error
+		if pending, err = db.legacyPendingMerge(mergePath); err != nil {
+			return err
 		}
 	}
-
-	//将新的数据文件(merge之后的文件)移动到数据目录中
-	for _, fileName := range mergeFileNames {
-		//将  /tmp/bitcask-merge   00.data  11.data改为
-		//    /tmp/bitcask         00.data  11.data
-		srcPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+	if pending != nil {
+		//应用失败的时候merge目录需要保留，重启之后继续应用
+		if err := db.applyPendingMerge(pending); err != nil {
 			return err
 		}
+		mergePath = pending.mergePath
+	}
+	//merge目录中剩下的文件要么已经移动完了，要么属于没有完成的merge，全部删除
+	_ = db.removeMergeDir(mergePath)
+	for _, dir := range db.extraMergeDirs() {
+		_ = db.removeMergeDir(dir)
 	}
 	return nil
 }
 
+// 没有MANIFEST的旧数据目录中已经完成的merge   merge没有完成的话返回nil
+func (db *DB) legacyPendingMerge(mergePath string) (*pendingMerge, error) {
+	//merge目录不存在，或者其中没有表示merge完成的文件
+	if _, err := db.fs.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+	if err == io.EOF || err == data.ErrInvalidCRC { //标识merge完成的记录没有完整写入，merge并没有完成
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pending, err := db.newPendingMerge(nonMergeFileId, mergePath)
+	if err != nil {
+		return nil, err
+	}
+
+	//先在MANIFEST中记录下当前所有的数据文件以及这次merge，之后按照新的方式应用
+	fileDirs, _, err := db.scanDataFiles()
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{formatVersion: dataFormatVersion, pendingMerge: pending}
+	for fid := range fileDirs {
+		m.files = append(m.files, fid)
+	}
+	sort.Slice(m.files, func(i, j int) bool {
+		return m.files[i] < m.files[j]
+	})
+	if err := db.writeManifest(m); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, dirPath)
 	if err != nil {
 		return 0, err
 	}
@@ -267,16 +586,16 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
-// 从hint文件中加载索引
+// 从以前的merge生成的hint-index文件中加载索引   现在merge会为每个数据文件生成单独的hint文件，这里只用于兼容旧的数据目录
 func (db *DB) loadIndexFromHint() error {
 	//首先查看hint索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) { //这里表示当前文件下没有hint文件
+	if _, err := db.fs.Stat(hintFileName); os.IsNotExist(err) { //这里表示当前文件下没有hint文件
 		return nil
 	}
 
 	//打开hint索引文件
-	hintFile, err := data.OpenHintFile(hintFileName)
+	hintFile, err := data.OpenHintFile(db.fs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -293,7 +612,7 @@ func (db *DB) loadIndexFromHint() error {
 		}
 		//解码得到实际的位置索引
 		pos := data.DecodeLogRecordPos(logRecord.Value) //这里hint文件中的value是实际的数据文件位置，所以进行解码得到pos
-		db.index.Put(logRecord.Key, pos)
+		db.updateIndex(logRecord.Key, data.LogRecordNormal, pos)
 		offset += size
 	}
 	return nil
@@ -0,0 +1,143 @@
+package bitcask_go
+
+import (
+	"context"
+	"time"
+)
+
+// merge的限速、进度和取消
+// 每一次merge对应一个mergeRun，merge读取和写入数据时都经过它：按照配置的速度限流，检查context是否已经取消，并统计进度
+// 最近一次merge的进度和结果保存在DB中，通过Stat().Merge获取
+
+const (
+	//累计读取这么多字节之后报告一次进度
+	mergeProgressInterval = 1024 * 1024
+
+	//限流时累计需要等待的时间超过这个值才真正sleep，避免每条记录都sleep一次
+	mergeThrottleMinSleep = 10 * time.Millisecond
+)
+
+// MergeProgress merge的进度
+type MergeProgress struct {
+	TotalFiles     int   //需要merge的文件数量
+	FilesDone      int   //已经处理完的文件数量
+	BytesRead      int64 //已经读取的字节数
+	BytesWritten   int64 //已经写入的字节数
+	RecordsKept    int64 //保留下来的记录数
+	RecordsDropped int64 //丢弃的无效记录数
+}
+
+// MergeStat 最近一次merge的状态
+type MergeStat struct {
+	MergeProgress
+	Running   bool      //是否正在进行
+	StartTime time.Time //开始时间
+	EndTime   time.Time //结束时间，正在进行时为零值
+	Err       error     //merge失败或者被取消的原因，成功时为nil
+}
+
+type mergeRun struct {
+	db         *DB
+	ctx        context.Context
+	rate       int64 //每秒最多读写的字节数，为0表示不限速
+	start      time.Time
+	bytes      int64 //限流统计的读写字节数
+	progress   MergeProgress
+	progressFn func(MergeProgress)
+	lastReport int64
+}
+
+// 开始一次merge   在访问此方法前必须已经把isMerging设置为true
+func (db *DB) startMergeRun(ctx context.Context, opts MergeOptions, totalFiles int) *mergeRun {
+	run := &mergeRun{
+		db:         db,
+		ctx:        ctx,
+		rate:       opts.BytesPerSecond,
+		start:      time.Now(),
+		progressFn: opts.Progress,
+	}
+	run.progress.TotalFiles = totalFiles
+	db.mergeStatLock.Lock()
+	db.mergeStat = MergeStat{
+		MergeProgress: run.progress,
+		Running:       true,
+		StartTime:     run.start,
+	}
+	db.mergeStatLock.Unlock()
+	return run
+}
+
+// 读取了n字节的数据
+func (run *mergeRun) read(n int64) error {
+	run.progress.BytesRead += n
+	if run.progress.BytesRead-run.lastReport >= mergeProgressInterval {
+		run.report()
+	}
+	return run.throttle(n)
+}
+
+// 写入了n字节的数据
+func (run *mergeRun) write(n int64) error {
+	run.progress.BytesWritten += n
+	return run.throttle(n)
+}
+
+// 处理完一条记录
+func (run *mergeRun) record(kept bool) {
+	if kept {
+		run.progress.RecordsKept++
+	} else {
+		run.progress.RecordsDropped++
+	}
+}
+
+// 处理完一个文件
+func (run *mergeRun) fileDone() {
+	run.progress.FilesDone++
+	run.report()
+}
+
+// merge结束，记录结果
+func (run *mergeRun) finish(err error) {
+	run.report()
+	run.db.mergeStatLock.Lock()
+	run.db.mergeStat.Running = false
+	run.db.mergeStat.EndTime = time.Now()
+	run.db.mergeStat.Err = err
+	run.db.mergeStatLock.Unlock()
+}
+
+// 检查是否已经取消，并按照配置的速度限流
+func (run *mergeRun) throttle(n int64) error {
+	if err := run.ctx.Err(); err != nil {
+		return err
+	}
+	if run.rate <= 0 {
+		return nil
+	}
+	run.bytes += n
+	expected := time.Duration(float64(run.bytes) / float64(run.rate) * float64(time.Second))
+	wait := expected - time.Since(run.start)
+	if wait < mergeThrottleMinSleep {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-run.ctx.Done():
+		return run.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 报告当前的进度
+func (run *mergeRun) report() {
+	run.lastReport = run.progress.BytesRead
+	run.db.mergeStatLock.Lock()
+	run.db.mergeStat.MergeProgress = run.progress
+	run.db.mergeStatLock.Unlock()
+	if run.progressFn != nil {
+		run.progressFn(run.progress)
+	}
+}
@@ -0,0 +1,202 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// merge完成之后直接在当前的数据库中生效，不需要重新打开
+func TestDB_Merge_ApplyOnline(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexCheckpoint = true
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for version := 0; version < 3; version++ {
+		for i := 0; i < 1000; i++ {
+			values[i] = utils.RandomValue(64)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+	}
+	for i := 0; i < 1000; i += 5 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(values, i)
+	}
+	before := db.Stat()
+	iter := db.NewIterator(DefaultIteratorOptions)
+	defer iter.Close()
+	keyIndex := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		keyIndex[string(utils.GetTestKey(i))] = i
+	}
+
+	//merge的同时还有新的写入和删除
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < 1000; i += 5 {
+			value := utils.RandomValue(64)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			mu.Lock()
+			values[i] = value
+			mu.Unlock()
+		}
+		for i := 2; i < 1000; i += 5 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			mu.Lock()
+			delete(values, i)
+			mu.Unlock()
+		}
+	}()
+	assert.Nil(t, db.Merge())
+	wg.Wait()
+
+	after := db.Stat()
+	assert.True(t, after.DataSize < before.DataSize)
+	assert.True(t, after.DataFileNum < before.DataFileNum)
+	dataSize, err := db.computeDataSize()
+	assert.Nil(t, err)
+	assert.Equal(t, dataSize, after.DataSize)
+	check := func(db *DB) {
+		assert.Equal(t, len(values), db.index.Size())
+		for i, value := range values {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check(db)
+
+	//merge之前创建的迭代器仍然可以读取到正确的数据
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		if err == ErrKeyNotFound { //迭代器创建之后被删除的key
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, values[keyIndex[string(iter.Key())]], val)
+		count++
+	}
+	assert.Equal(t, len(values), count)
+
+	//重新打开之后数据不变
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+// 模拟merge目录和数据目录不在同一个文件系统上，跨目录的重命名都会失败
+type crossDeviceFS struct {
+	vfs.FS
+}
+
+func (fs crossDeviceFS) Rename(oldPath, newPath string) error {
+	if filepath.Dir(oldPath) != filepath.Dir(newPath) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+	}
+	return fs.FS.Rename(oldPath, newPath)
+}
+
+func TestDB_Merge_MergeDir(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mergeDir bool
+		fs       vfs.FS
+	}{
+		{"default", false, vfs.OS},
+		{"merge-dir", true, vfs.OS},
+		{"cross-device", true, crossDeviceFS{vfs.OS}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptioins
+			dir, _ := os.MkdirTemp("", "bitcask-go-merge-dir")
+			opts.DirPath = dir
+			opts.DataFileSize = 32 * 1024
+			opts.DataFileMergeRatio = 0
+			opts.FS = tc.fs
+			mergePath := dir + mergeDirName //默认和数据目录在同一个父目录下
+			if tc.mergeDir {
+				mergeDir, _ := os.MkdirTemp("", "bitcask-go-merge-dir-staging")
+				defer os.RemoveAll(mergeDir)
+				mergePath = filepath.Join(mergeDir, "staging")
+				opts.MergeDir = mergePath
+			}
+			db, err := OpenDB(opts)
+			defer Destroy_DB(db)
+			assert.Nil(t, err)
+			assert.Equal(t, mergePath, db.getMergePath())
+
+			values := make(map[int][]byte)
+			for version := 0; version < 2; version++ {
+				for i := 0; i < 1000; i++ {
+					values[i] = utils.RandomValue(64)
+					assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+				}
+			}
+			assert.Nil(t, db.Merge())
+			_, err = os.Stat(mergePath)
+			assert.True(t, os.IsNotExist(err))
+			tmpFiles, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+			assert.Equal(t, 0, len(tmpFiles))
+			assert.Nil(t, db.Close())
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, len(values), db.index.Size())
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+		})
+	}
+}
+
+// 崩溃的merge留下的临时目录
+func TestDB_Merge_LeftoverStaging(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-dir")
+	mergeDir, _ := os.MkdirTemp("", "bitcask-go-merge-dir-staging")
+	defer os.RemoveAll(mergeDir)
+	opts.DirPath = dir
+	opts.MergeDir = mergeDir
+	opts.DataFileMergeRatio = 0
+
+	//没有完成的merge留下的目录在启动时删除
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(mergeDir, 0), []byte("unfinished"), 0644))
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	_, err = os.Stat(mergeDir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+
+	//merge目录中有不是merge生成的文件时不会删除
+	assert.Nil(t, os.MkdirAll(mergeDir, os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(mergeDir, "user-file"), []byte("user data"), 0644))
+	assert.Equal(t, ErrMergeDirNotEmpty, db.Merge())
+	_, err = os.Stat(filepath.Join(mergeDir, "user-file"))
+	assert.Nil(t, err)
+
+	//merge目录不能是数据目录
+	opts.MergeDir = dir
+	_, err = OpenDB(opts)
+	assert.Equal(t, ErrInvalidMergeDir, err)
+}
@@ -1,5 +1,12 @@
 package bitcask_go
 
+import (
+	"bitcask-go/data"
+	"bitcask-go/vfs"
+	"runtime"
+	"time"
+)
+
 // 用户在初始化数据库的时候的一些配置文件
 type Option struct {
 	DirPath string //数据路数据目录
@@ -10,11 +17,59 @@ type Option struct {
 
 	BytesPerSync uint //累计写到多少字节后进行持久化
 
-	IndexType IndexerType //指定内存索引的实现方式(btree or art)
+	IndexType IndexerType //指定内存索引的实现方式(btree、art、hash等)
 
 	MMapAtStartup bool //配置项，是否在启动的时候使用mmap加载数据
 
 	DataFileMergeRatio float32 //数据文件合并阈值
+
+	IndexCheckpoint bool //是否开启索引检查点，开启后关闭数据库时会把内存索引保存到磁盘上，启动时加载检查点并只回放之后的数据
+
+	CheckpointInterval time.Duration //后台定期保存检查点的时间间隔，为0表示只在关闭数据库时保存
+
+	LoadConcurrency int //启动时并行解码数据文件的goroutine数量，小于等于1表示依次加载
+
+	ActiveIOType IOType //活跃文件使用的IO类型
+
+	OlderIOType IOType //旧数据文件使用的IO类型
+
+	DiskLowWaterMark uint64 //磁盘剩余空间的低水位(字节)，剩余空间低于它的时候拒绝写入并返回ErrDiskFull，为0表示不检查
+
+	DiskHighWaterMark uint64 //磁盘剩余空间的高水位(字节)，拒绝写入之后剩余空间恢复到它以上才重新允许写入，小于低水位时按照低水位处理
+
+	MaxDiskSize int64 //数据文件总大小的上限(字节)，写入之后会超出时返回QuotaExceededError，为0表示不限制
+
+	MaxKeys uint //key数量的上限，新增key之后会超出时返回QuotaExceededError，为0表示不限制
+
+	QuotaBackpressure bool //写入超出MaxDiskSize时先阻塞写入，自动merge回收空间之后重试，仍然超出才返回错误
+
+	DataDirs []string //额外的数据目录，可以分布在不同的磁盘上，新的数据文件会按照DataDirPlacement分散放到DirPath和这些目录中；文件锁、seq-no、hint文件等仍然只保存在DirPath中
+
+	DataDirPlacement DataDirPlacement //新建数据文件时选择数据目录的策略
+
+	MergeDir string //merge时存放临时文件的目录，merge完成之后再移动到数据目录中，为空时使用和DirPath同一个父目录下的DirPath+"_merge"。不在同一个文件系统上时会先拷贝再替换
+
+	MergeBytesPerSecond int64 //没有单独指定限速的merge(Merge、Upgrade以及配额不足时自动触发的merge)读写数据的速度上限(字节/秒)，为0表示不限速
+
+	ColdDir string //冷数据目录，不常访问的旧数据文件按照ColdFileNum、ColdFileAge移动到这个目录中(例如容量大但是比较慢的磁盘)，为空表示不开启
+
+	ColdFileNum uint //旧数据文件之后又有超过这么多个更新的数据文件时移动到冷数据目录，为0表示不按照文件id判断
+
+	ColdFileAge time.Duration //旧数据文件超过这么长时间没有修改时移动到冷数据目录，为0表示不按照时间判断
+
+	ColdCompression bool //移动到冷数据目录时是否把文件压缩，压缩的文件分块读取，内存中只保留最近读取的一个块
+
+	Preallocate bool //是否在新建活跃文件的时候使用fallocate预分配DataFileSize大小的空间，避免每次追加写入都要修改文件大小等元数据，转换为旧文件或者关闭时会截断到实际写入的大小
+
+	InMemory bool //内存模式，数据文件、hint文件、merge目录等全部保存在这个实例单独的内存文件系统中，不会访问磁盘，关闭之后数据就丢失了。需要关闭之后重新打开时，通过FS传入同一个vfs.MemFS
+
+	FS vfs.FS //数据目录所在的文件系统，所有的文件操作都通过它进行。为空时根据InMemory选择内存文件系统或者操作系统的文件系统
+
+	CompactionFilter CompactionFilter //merge时对每一条有效记录调用，可以保留、丢弃或者替换value，为空表示全部保留
+
+	Checksum ChecksumType //新建的数据文件中记录使用的校验算法，记录在文件头中，已有的文件仍然使用写入时的算法
+
+	VersionRetention time.Duration //merge时历史版本保留的时长，在这段时间内仍然有效过的版本都会保留下来，可以通过GetAt、History读取；为0表示merge只保留最新的版本，并且不记录被删除的key的墓碑值
 }
 
 // 索引迭代器配置项
@@ -31,6 +86,45 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// 批量导入的配置项
+type BulkLoaderOptions struct {
+	//写入数据文件的缓冲区大小，攒够这么多数据之后才写入一次
+	BufferSize int
+
+	//导入生成的单个数据文件的大小，为0时使用Option.DataFileSize
+	DataFileSize int64
+}
+
+// 导入的配置项
+type ImportOptions struct {
+	//每一批原子提交的记录数
+	BatchSize uint
+
+	//每一批提交时是否sync持久化
+	SyncWrites bool
+
+	//从上一次没有完成的导入保存的进度继续，跳过已经提交的记录   需要使用同一份数据和格式，跳过的记录和保存进度时的不一致时返回ErrImportProgressMismatch
+	Resume bool
+}
+
+// 选择性merge的配置项，只重写选中的旧数据文件，其他文件保持不变
+type MergeOptions struct {
+	//只选择无效数据占比不低于这个值的文件，为0表示不按照比例过滤
+	GarbageRatio float32
+
+	//最多重写多少字节的有效数据，无效数据占比高的文件优先，为0表示不限制
+	MaxBytes int64
+
+	//只从这些文件中选择，为空表示从所有的旧数据文件中选择
+	FileIds []uint32
+
+	//merge读写数据的速度上限(字节/秒)，读取和写入的字节数合并计算，为0表示不限速
+	BytesPerSecond int64
+
+	//merge的进度回调，每处理完一个文件以及每读取一段数据之后调用，在merge的goroutine中同步执行
+	Progress func(MergeProgress)
+}
+
 type IndexerType = int8
 
 const (
@@ -42,6 +136,51 @@ const (
 
 	//BPlusTree   B+树索引，将索引存储在磁盘上
 	BPLusTree
+
+	//哈希表索引，适合只做点查的场景，遍历时按需排序
+	Hash
+
+	//哈希表索引，不支持遍历，迭代相关的接口会返回ErrIteratorUnsupported
+	UnorderedHash
+
+	//跳表索引，读操作不加锁，迭代器不需要拷贝数据
+	SkipList
+)
+
+type IOType = byte
+
+const (
+	//标准文件IO
+	StandardIO IOType = iota
+
+	//可读写的mmap，写入直接拷贝到映射的内存中，文件按块预先分配空间
+	MMapIO
+
+	//Direct IO，读写绕过操作系统的页缓存，适合value比较大、不希望挤占页缓存的场景
+	DirectIO
+)
+
+type ChecksumType = data.ChecksumType
+
+const (
+	//CRC32C，在amd64、arm64上使用硬件指令计算
+	ChecksumCRC32C = data.ChecksumCRC32C
+
+	//IEEE crc32，以前的版本使用的算法
+	ChecksumIEEE = data.ChecksumIEEE
+
+	//64位的xxhash，检测错误的能力更强，每条记录多占用4个字节
+	ChecksumXXHash64 = data.ChecksumXXHash64
+)
+
+type DataDirPlacement = byte
+
+const (
+	//按照文件id依次轮流放到各个数据目录中
+	PlaceRoundRobin DataDirPlacement = iota
+
+	//放到剩余空间最多的数据目录中
+	PlaceByFreeSpace
 )
 
 var DefaultOptioins = Option{
@@ -52,6 +191,27 @@ var DefaultOptioins = Option{
 	IndexType:          BTree,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5, //这里默认设置无效数据站总数据一半，我们就进行merge处理
+	IndexCheckpoint:    false,
+	CheckpointInterval: 0,
+	LoadConcurrency:    runtime.NumCPU(),
+	ActiveIOType:       StandardIO,
+	OlderIOType:        StandardIO,
+	Preallocate:        false,
+	DiskLowWaterMark:   0,
+	DiskHighWaterMark:  0,
+	MaxDiskSize:        0,
+	MaxKeys:            0,
+	QuotaBackpressure:  false,
+	DataDirs:           nil,
+	DataDirPlacement:   PlaceRoundRobin,
+	MergeDir:           "",
+	ColdDir:            "",
+	ColdFileNum:        0,
+	ColdFileAge:        0,
+	ColdCompression:    false,
+	CompactionFilter:   nil,
+	Checksum:           ChecksumCRC32C,
+	VersionRetention:   0,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -59,7 +219,26 @@ var DefaultIteratorOptions = IteratorOptions{
 	Reverse: false,
 }
 
+var DefaultMergeOptions = MergeOptions{
+	GarbageRatio:   0.5,
+	MaxBytes:       0,
+	FileIds:        nil,
+	BytesPerSecond: 0,
+	Progress:       nil,
+}
+
 var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
 }
+
+var DefaultImportOptions = ImportOptions{
+	BatchSize:  1000,
+	SyncWrites: true,
+	Resume:     false,
+}
+
+var DefaultBulkLoaderOptions = BulkLoaderOptions{
+	BufferSize:   4 * 1024 * 1024,
+	DataFileSize: 0,
+}
@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Preallocate(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, ART} {
+		opts := DefaultOptioins
+		dir, _ := os.MkdirTemp("", "bitcask-go-preallocate")
+		opts.DirPath = dir
+		opts.DataFileSize = 64 * 1024
+		opts.IndexType = indexType
+		opts.Preallocate = true
+		db, err := OpenDB(opts)
+		assert.Nil(t, err)
+
+		values := make(map[int][]byte)
+		for i := 0; i < 1000; i++ {
+			values[i] = utils.RandomValue(64)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+		assert.True(t, len(db.olderFile) > 0)
+
+		//活跃文件预分配到DataFileSize，旧文件截断到实际写入的大小
+		size, err := db.activeFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, opts.DataFileSize, size)
+		for _, file := range db.olderFile {
+			size, err := file.IoManager.Size()
+			assert.Nil(t, err)
+			assert.True(t, size < opts.DataFileSize)
+		}
+
+		//模拟崩溃：不关闭数据库，把数据目录拷贝出来打开，活跃文件的末尾是预分配的空间
+		crashDir, _ := os.MkdirTemp("", "bitcask-go-preallocate-crash")
+		assert.Nil(t, utils.CopyDir(vfs.OS, dir, crashDir, []string{fileLockName, "*.tmp"}))
+		crashOpts := opts
+		crashOpts.DirPath = crashDir
+		crashDB, err := OpenDB(crashOpts)
+		assert.Nil(t, err)
+
+		for i := 1000; i < 1100; i++ {
+			values[i] = utils.RandomValue(64)
+			assert.Nil(t, crashDB.Put(utils.GetTestKey(i), values[i]))
+		}
+		for i, value := range values {
+			val, err := crashDB.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		assert.Nil(t, crashDB.Close())
+		_ = os.RemoveAll(crashDir)
+
+		//关闭之后活跃文件也截断到实际写入的大小
+		activeFileId := db.activeFile.FileId
+		writeOff := db.activeFile.WriteOff
+		assert.Nil(t, db.Close())
+		stat, err := os.Stat(data.GetDataFileName(dir, activeFileId))
+		assert.Nil(t, err)
+		assert.Equal(t, writeOff, stat.Size())
+
+		db, err = OpenDB(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+		assert.Nil(t, db.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestCrash_Preallocate(t *testing.T) {
+	fs := vfs.NewFaultFS(vfs.NewMemFS())
+	opts := crashTestOptions(fs)
+	opts.SyncWrites = true
+	opts.Preallocate = true
+	db, err := OpenDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), crashTestValue(i, 0)))
+	}
+	//崩溃时最后一条记录只写入了一半，后面是预分配的零
+	fs.FailWrite(1, true)
+	assert.NotNil(t, db.Put(utils.GetTestKey(100), crashTestValue(100, 0)))
+	assert.Nil(t, fs.Crash(0))
+
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, db.index.Size())
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, crashTestValue(i, 0), val)
+	}
+	assert.Nil(t, db.Close())
+}
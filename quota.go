@@ -0,0 +1,96 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+)
+
+// 配额
+// MaxDiskSize限制所有数据文件的总大小(活跃文件只统计已经写入的部分)，MaxKeys限制key的数量，超出时写入返回QuotaExceededError
+// 删除操作写入的墓碑值不受MaxDiskSize的限制，保证配额用完之后仍然可以通过删除数据、merge来回收空间
+// 开启QuotaBackpressure之后，超出MaxDiskSize的写入会先阻塞，自动merge回收空间之后再重试，仍然超出才返回错误
+
+// 统计所有数据文件的大小
+func (db *DB) computeDataSize() (int64, error) {
+	var size int64
+	for _, dataFile := range db.olderFile {
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		size += fileSize
+	}
+	if db.activeFile != nil {
+		size += db.activeFile.WriteOff
+	}
+	return size, nil
+}
+
+// 写入size字节之前检查是否超出了MaxDiskSize   在访问此方法前必须持有互斥锁
+func (db *DB) checkDiskQuota(size int64) error {
+	if db.options.MaxDiskSize > 0 && db.dataSize+size > db.options.MaxDiskSize {
+		return &QuotaExceededError{Quota: ErrMaxDiskSizeExceeded, Limit: db.options.MaxDiskSize, Usage: db.dataSize + size}
+	}
+	return nil
+}
+
+// 新增newKeys个key之前检查是否超出了MaxKeys   在访问此方法前必须持有互斥锁
+func (db *DB) checkKeysQuota(newKeys int) error {
+	if db.options.MaxKeys == 0 || newKeys <= 0 {
+		return nil
+	}
+	keyNum := int64(db.index.Size()) + int64(newKeys)
+	if keyNum > int64(db.options.MaxKeys) {
+		return &QuotaExceededError{Quota: ErrMaxKeysExceeded, Limit: int64(db.options.MaxKeys), Usage: keyNum}
+	}
+	return nil
+}
+
+// 写入超出了MaxDiskSize时，开启了QuotaBackpressure的话阻塞当前的写入，自动merge回收空间
+// 返回true表示已经回收了空间，可以重试一次写入
+func (db *DB) reclaimForQuota(err error) bool {
+	if !db.options.QuotaBackpressure || !errors.Is(err, ErrMaxDiskSizeExceeded) {
+		return false
+	}
+	generation := atomic.LoadUint64(&db.mergeGeneration)
+	db.quotaMergeLock.Lock()
+	defer db.quotaMergeLock.Unlock()
+	//等待的时候其他的写入已经完成了merge
+	if atomic.LoadUint64(&db.mergeGeneration) != generation {
+		return true
+	}
+	db.mu.RLock()
+	reclaimSize := db.reclaimableSize()
+	db.mu.RUnlock()
+	if reclaimSize == 0 { //没有可以回收的数据
+		return false
+	}
+	return db.merge(context.Background(), db.defaultMergeOptions(), false) == nil
+}
+
+// 计算一个批次的数据写入之后新增的key的数量，以及需要受到MaxDiskSize限制的数据量
+func (wb *WriteBatch) quotaUsage() (int, int64) {
+	var newKeys int
+	var size int64
+	for _, record := range wb.pendingWrites {
+		exist := wb.db.index.Get(record.Key) != nil
+		if record.Type == data.LogRecordDeleted {
+			if exist {
+				newKeys--
+			}
+			continue
+		}
+		if !exist {
+			newKeys++
+		}
+		if wb.db.options.MaxDiskSize > 0 {
+			//写入的时候key前面还会加上事务序列号，记录中还有版本信息，这里按照最长的长度估计
+			_, recordSize := data.EncodeLogRecordWithChecksum(record, wb.db.options.Checksum)
+			size += recordSize + binary.MaxVarintLen64 + data.MaxVersionInfoSize
+		}
+	}
+	return newKeys, size
+}
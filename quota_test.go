@@ -0,0 +1,181 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDB_MaxKeys(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-quota")
+	opts.DirPath = dir
+	opts.MaxKeys = 10
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	err = db.Put(utils.GetTestKey(10), utils.RandomValue(24))
+	assert.True(t, errors.Is(err, ErrMaxKeysExceeded))
+	var quotaErr *QuotaExceededError
+	assert.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, int64(10), quotaErr.Limit)
+	assert.Equal(t, int64(11), quotaErr.Usage)
+
+	//更新已有的key不会增加key的数量
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	//删除之后又可以写入新的key
+	assert.Nil(t, db.Delete(utils.GetTestKey(2)))
+	assert.Nil(t, db.Put(utils.GetTestKey(10), utils.RandomValue(24)))
+
+	//批次中新增的key超出配额时整个批次都不会写入
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(3)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(11), utils.RandomValue(24)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(12), utils.RandomValue(24)))
+	assert.True(t, errors.Is(wb.Commit(), ErrMaxKeysExceeded))
+	_, err = db.Get(utils.GetTestKey(11))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+
+	assert.Nil(t, wb.Delete(utils.GetTestKey(4)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint(10), db.Stat().KeyNum)
+	assert.Equal(t, uint(10), db.Stat().MaxKeys)
+}
+
+// 并发写入新的key时MaxKeys仍然是严格的上限
+func TestDB_MaxKeys_Concurrent(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-quota")
+	opts.DirPath = dir
+	opts.MaxKeys = 100
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	var written int64
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				err := db.Put(utils.GetTestKey(g*1000+i), []byte("value"))
+				if err == nil {
+					atomic.AddInt64(&written, 1)
+					continue
+				}
+				assert.True(t, errors.Is(err, ErrMaxKeysExceeded))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(100), written)
+	assert.Equal(t, 100, db.index.Size())
+}
+
+func TestDB_MaxDiskSize(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-quota")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.MaxDiskSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	var count int
+	for ; count < 10000; count++ {
+		err = db.Put(utils.GetTestKey(count), utils.RandomValue(128))
+		if err != nil {
+			break
+		}
+	}
+	assert.True(t, errors.Is(err, ErrMaxDiskSizeExceeded))
+	assert.True(t, count > 0)
+	stat := db.Stat()
+	assert.Equal(t, opts.MaxDiskSize, stat.MaxDiskSize)
+	assert.True(t, stat.DataSize <= opts.MaxDiskSize)
+
+	//批次超出配额时整个批次都不会写入
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(count), utils.RandomValue(128)))
+	assert.True(t, errors.Is(wb.Commit(), ErrMaxDiskSizeExceeded))
+	assert.Equal(t, stat.DataSize, db.Stat().DataSize)
+
+	//超出配额之后仍然可以删除数据，merge之后回收空间
+	for i := 0; i < count/2; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.True(t, db.Stat().DataSize < stat.DataSize)
+	assert.Nil(t, db.Put(utils.GetTestKey(count), utils.RandomValue(128)))
+
+	//重新打开之后用量保持一致
+	dataSize := db.Stat().DataSize
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, dataSize, db.Stat().DataSize)
+}
+
+func TestDB_QuotaBackpressure(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-quota")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	opts.MaxDiskSize = 64 * 1024
+	opts.QuotaBackpressure = true
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	//反复覆盖写入同一批key，超出配额时自动merge回收空间
+	values := make(map[int][]byte)
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 100; i++ {
+			values[i] = utils.RandomValue(128)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+		wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+		for i := 100; i < 120; i++ {
+			values[i] = utils.RandomValue(128)
+			assert.Nil(t, wb.Put(utils.GetTestKey(i), values[i]))
+		}
+		assert.Nil(t, wb.Commit())
+	}
+	assert.True(t, db.Stat().DataSize <= opts.MaxDiskSize)
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	//有效数据本身超出了配额，merge也无法回收，返回错误
+	var err2 error
+	for i := 1000; i < 2000; i++ {
+		if err2 = db.Put(utils.GetTestKey(i), utils.RandomValue(128)); err2 != nil {
+			break
+		}
+	}
+	assert.True(t, errors.Is(err2, ErrMaxDiskSizeExceeded))
+
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
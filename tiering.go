@@ -0,0 +1,142 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 冷热分层存储
+// 不常访问的旧数据文件可以移动到冷数据目录(Option.ColdDir)中，可以选择把整个文件压缩
+// 移动之后数据文件的IOManager在新的路径上重新打开，读取的时候不需要关心文件在哪一层
+// 移动的过程：先拷贝(或者压缩)到冷数据目录的临时文件，重命名之后再切换IOManager，最后删除原来的文件
+// 中途崩溃的话，启动时以数据目录中的文件为准，冷数据目录中多余的文件会被删除
+
+// MoveColdFiles 把满足ColdFileNum或者ColdFileAge条件的旧数据文件移动到冷数据目录
+// 旧数据文件轮换的时候会在后台自动调用，也可以定期手动调用(例如只配置了ColdFileAge的时候)
+func (db *DB) MoveColdFiles() error {
+	if db.options.ColdDir == "" {
+		return nil
+	}
+	db.tierLock.Lock()
+	defer db.tierLock.Unlock()
+
+	//找出需要移动的文件
+	db.mu.RLock()
+	if db.activeFile == nil {
+		db.mu.RUnlock()
+		return nil
+	}
+	activeFileId := db.activeFile.FileId
+	var fileIds []uint32
+	fileDirs := make(map[uint32]string)
+	for fid := range db.olderFile {
+		if dir := db.dataFileDir(fid); dir != db.options.ColdDir && !db.compressedFiles[fid] {
+			fileIds = append(fileIds, fid)
+			fileDirs[fid] = dir
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	for _, fid := range fileIds {
+		isCold, err := db.isColdFile(fileDirs[fid], fid, activeFileId)
+		if err != nil {
+			return err
+		}
+		if !isCold {
+			continue
+		}
+		if err := db.moveColdFile(fileDirs[fid], fid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 判断旧数据文件是否应该移动到冷数据目录
+func (db *DB) isColdFile(dir string, fileId, activeFileId uint32) (bool, error) {
+	if db.options.ColdFileNum > 0 && uint(activeFileId-fileId) > db.options.ColdFileNum {
+		return true, nil
+	}
+	if db.options.ColdFileAge > 0 {
+		info, err := db.fs.Stat(data.GetDataFileName(dir, fileId))
+		if err != nil {
+			return false, err
+		}
+		return time.Since(info.ModTime()) > db.options.ColdFileAge, nil
+	}
+	return false, nil
+}
+
+// 把一个旧数据文件移动到冷数据目录   在访问此方法前必须持有tierLock，保证文件不会被merge删除
+func (db *DB) moveColdFile(srcDir string, fileId uint32) error {
+	srcName := data.GetDataFileName(srcDir, fileId)
+	ioType := fileIOType(db.options.OlderIOType)
+	destName := data.GetDataFileName(db.options.ColdDir, fileId)
+	if db.options.ColdCompression {
+		ioType = fio.Compressed
+		destName = data.GetCompressedDataFileName(db.options.ColdDir, fileId)
+	}
+
+	//旧数据文件不会再改变，不需要持有互斥锁就可以拷贝
+	tmpName := destName + ".tmp"
+	var err error
+	if db.options.ColdCompression {
+		err = fio.WriteCompressedFile(db.fs, srcName, tmpName)
+	} else {
+		err = utils.CopyFile(db.fs, srcName, tmpName)
+	}
+	if err != nil {
+		_ = db.fs.Remove(tmpName)
+		return err
+	}
+	if err := db.fs.Rename(tmpName, destName); err != nil {
+		return err
+	}
+	//冷数据目录中的文件持久化之后才能删除源文件
+	if err := db.fs.SyncDir(db.options.ColdDir); err != nil {
+		return err
+	}
+
+	//切换到冷数据目录中的文件
+	db.mu.Lock()
+	//后台生成hint文件的任务可能还在读取这个文件
+	db.hintWg.Wait()
+	dataFile, ok := db.olderFile[fileId]
+	if !ok {
+		db.mu.Unlock()
+		return db.fs.Remove(destName)
+	}
+	if err := dataFile.SetIOManager(db.fs, db.options.ColdDir, ioType); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	db.fileDirs[fileId] = db.options.ColdDir
+	db.compressedFiles[fileId] = db.options.ColdCompression
+	db.mu.Unlock()
+
+	return db.fs.Remove(srcName)
+}
+
+// 在后台移动冷数据文件   已经有任务在进行的时候不再重复启动   在访问此方法前必须持有互斥锁
+func (db *DB) moveColdFilesAsync() {
+	if db.options.ColdDir == "" || (db.options.ColdFileNum == 0 && db.options.ColdFileAge == 0) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&db.tierRunning, 0, 1) {
+		return
+	}
+	db.tierWg.Add(1)
+	go func() {
+		defer db.tierWg.Done()
+		defer atomic.StoreInt32(&db.tierRunning, 0)
+		//移动失败也没有关系，下一次轮换的时候会重试
+		_ = db.MoveColdFiles()
+	}()
+}
@@ -0,0 +1,178 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_ColdDir(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		name := "plain"
+		if compression {
+			name = "compressed"
+		}
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptioins
+			dir, _ := os.MkdirTemp("", "bitcask-go-cold")
+			coldDir, _ := os.MkdirTemp("", "bitcask-go-cold-archive")
+			defer os.RemoveAll(coldDir)
+			opts.DirPath = dir
+			opts.ColdDir = coldDir
+			opts.ColdFileNum = 2
+			opts.ColdCompression = compression
+			opts.DataFileSize = 32 * 1024
+			opts.DataFileMergeRatio = 0
+			db, err := OpenDB(opts)
+			defer Destroy_DB(db)
+			assert.Nil(t, err)
+
+			values := make(map[int][]byte)
+			for version := 0; version < 2; version++ {
+				for i := 0; i < 1000; i++ {
+					values[i] = utils.RandomValue(64)
+					assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+				}
+			}
+			db.tierWg.Wait()
+			assert.Nil(t, db.MoveColdFiles())
+
+			//只保留最新的两个旧数据文件和活跃文件在数据目录中
+			coldName := data.GetDataFileName
+			if compression {
+				coldName = data.GetCompressedDataFileName
+			}
+			activeFileId := db.activeFile.FileId
+			for fid := uint32(0); fid < activeFileId; fid++ {
+				_, err := os.Stat(coldName(coldDir, fid))
+				_, hotErr := os.Stat(data.GetDataFileName(dir, fid))
+				if activeFileId-fid > 2 {
+					assert.Nil(t, err)
+					assert.True(t, os.IsNotExist(hotErr))
+				} else {
+					assert.True(t, os.IsNotExist(err))
+					assert.Nil(t, hotErr)
+				}
+			}
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			assert.Nil(t, db.Close())
+
+			//重新打开之后仍然可以读取冷数据目录中的文件
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, coldDir, db.dataFileDir(0))
+			assert.Equal(t, compression, db.compressedFiles[0])
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+
+			//备份包含两层中的数据文件
+			backupDir, _ := os.MkdirTemp("", "bitcask-go-cold-backup")
+			defer os.RemoveAll(backupDir)
+			assert.Nil(t, db.BackUp(backupDir))
+			backupOpts := DefaultOptioins
+			backupOpts.DirPath = backupDir
+			backupDB, err := OpenDB(backupOpts)
+			assert.Nil(t, err)
+			for i, value := range values {
+				val, err := backupDB.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			assert.Nil(t, backupDB.Close())
+
+			//merge会删除两层中的旧文件
+			assert.Nil(t, db.Merge())
+			db.tierWg.Wait()
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			assert.Nil(t, db.Close())
+
+			db, err = OpenDB(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, len(values), db.index.Size())
+			for i, value := range values {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+		})
+	}
+}
+
+func TestDB_ColdDir_Age(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-cold")
+	coldDir, _ := os.MkdirTemp("", "bitcask-go-cold-archive")
+	defer os.RemoveAll(coldDir)
+	opts.DirPath = dir
+	opts.ColdDir = coldDir
+	opts.ColdFileAge = time.Hour
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	db.tierWg.Wait()
+	assert.Nil(t, db.MoveColdFiles())
+	files, _ := filepath.Glob(filepath.Join(coldDir, "*"+data.DataFileNameSuffix))
+	assert.Equal(t, 0, len(files))
+
+	//修改时间超过ColdFileAge的文件才会被移动
+	old := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(data.GetDataFileName(dir, 0), old, old))
+	assert.Nil(t, db.MoveColdFiles())
+	_, err = os.Stat(data.GetDataFileName(coldDir, 0))
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(coldDir, 1))
+	assert.True(t, os.IsNotExist(err))
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+// 移动到冷数据目录的过程中崩溃，两个目录中都有同一个数据文件
+func TestDB_ColdDir_Recover(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-cold")
+	coldDir, _ := os.MkdirTemp("", "bitcask-go-cold-archive")
+	defer os.RemoveAll(coldDir)
+	opts.DirPath = dir
+	opts.ColdDir = coldDir
+	opts.DataFileSize = 32 * 1024
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	assert.Nil(t, utils.CopyFile(db.fs, data.GetDataFileName(dir, 0), data.GetDataFileName(coldDir, 0)))
+
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, dir, db.dataFileDir(0))
+	_, err = os.Stat(data.GetDataFileName(coldDir, 0))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 1000, db.index.Size())
+
+	//冷数据目录不能是数据目录
+	opts.ColdDir = dir
+	_, err = OpenDB(opts)
+	assert.Equal(t, ErrInvalidColdDir, err)
+}
@@ -0,0 +1,57 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"context"
+)
+
+// 数据目录格式升级
+// 以前的版本写入的数据文件和hint文件没有文件头(见data/file_header.go)，仍然可以正常读取，但是之后依赖文件头的新特性无法在这些文件上使用
+// 升级通过全量merge完成：活跃文件先转换为旧文件，所有旧文件中的有效数据重新写入新格式的数据文件，同时生成新格式的hint文件
+// 修改了Option.Checksum之后，也可以通过升级把已有的文件换成新的校验算法
+
+// Upgrade 把数据库中所有的数据文件重写为最新的格式，并使用配置的校验算法   已经满足时什么都不做
+func (db *DB) Upgrade() error {
+	return db.UpgradeContext(context.Background(), db.defaultMergeOptions())
+}
+
+// UpgradeContext 和Upgrade相同，升级使用的全量merge可以通过ctx取消，并按照opts限速、报告进度(见MergeContext)
+func (db *DB) UpgradeContext(ctx context.Context, opts MergeOptions) error {
+	db.mu.RLock()
+	upToDate := db.isFormatUpToDate()
+	db.mu.RUnlock()
+	if upToDate {
+		return nil
+	}
+	return db.merge(ctx, opts, false)
+}
+
+// Upgrade 打开options.DirPath中的数据库，升级到最新的格式之后关闭
+func Upgrade(options Option) error {
+	db, err := OpenDB(options)
+	if err != nil {
+		return err
+	}
+	if err := db.Upgrade(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// 是否所有的数据文件都已经是最新的格式，并且使用配置的校验算法   在访问此方法前必须持有互斥锁
+func (db *DB) isFormatUpToDate() bool {
+	upToDate := func(dataFile *data.DataFile) bool {
+		return dataFile.Header.Version == data.CurrentFormatVersion && dataFile.Header.Checksum == db.options.Checksum &&
+			dataFile.Header.Versioned()
+	}
+	if db.activeFile != nil && !upToDate(db.activeFile) {
+		return false
+	}
+	for _, dataFile := range db.olderFile {
+		if !upToDate(dataFile) {
+			return false
+		}
+	}
+	return true
+}
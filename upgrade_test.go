@@ -0,0 +1,194 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"bitcask-go/vfs"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// 按照没有文件头的旧格式写入数据文件   value为nil的记录写入墓碑值
+func writeLegacyDataFile(t *testing.T, dir string, fid uint32, keys []int, values [][]byte) {
+	dataFile, err := data.OpenDataFile(vfs.OS, dir, fid, fio.StanderdFIO)
+	assert.Nil(t, err)
+	for i, key := range keys {
+		record := &data.LogRecord{Key: logRecordKeyWithSeq(utils.GetTestKey(key), nonTransactionSeqNo), Value: values[i]}
+		if values[i] == nil {
+			record.Type = data.LogRecordDeleted
+		}
+		encRecord, _ := data.EncodeLogRecord(record)
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	assert.Nil(t, dataFile.Sync())
+	assert.Nil(t, dataFile.Close())
+}
+
+// 生成一个旧格式的数据目录，返回其中的数据
+func makeLegacyDir(t *testing.T, dir string) map[int][]byte {
+	expected := make(map[int][]byte)
+	for fid := 0; fid < 3; fid++ {
+		var keys []int
+		var values [][]byte
+		for i := fid * 50; i < fid*50+100; i++ {
+			value := []byte(fmt.Sprintf("legacy-value-%d-%d", i, fid))
+			keys, values = append(keys, i), append(values, value)
+			expected[i] = value
+		}
+		//删除一部分之前写入的key
+		for i := fid * 50; i < fid*50+10 && fid > 0; i++ {
+			keys, values = append(keys, i), append(values, nil)
+			delete(expected, i)
+		}
+		writeLegacyDataFile(t, dir, uint32(fid), keys, values)
+	}
+	return expected
+}
+
+func checkFileVersion(t *testing.T, db *DB, version uint16) {
+	assert.Equal(t, version, db.activeFile.Header.Version)
+	for _, dataFile := range db.olderFile {
+		assert.Equal(t, version, dataFile.Header.Version, "file %d", dataFile.FileId)
+	}
+}
+
+func TestDB_Upgrade(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade")
+	opts.DirPath = dir
+	expected := makeLegacyDir(t, dir)
+
+	//旧格式的数据文件仍然可以读取，活跃文件继续按照旧格式追加写入
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	checkFileVersion(t, db, data.FormatVersionLegacy)
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("new-value")))
+	expected[1000] = []byte("new-value")
+	check := func(db *DB) {
+		assert.Equal(t, len(expected), db.index.Size())
+		for i, value := range expected {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkFileVersion(t, db, data.FormatVersionLegacy)
+	check(db)
+
+	//取消的升级不会修改已有的文件
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.UpgradeContext(ctx, MergeOptions{}))
+	for _, dataFile := range db.olderFile {
+		assert.Equal(t, data.FormatVersionLegacy, dataFile.Header.Version)
+	}
+	check(db)
+
+	//升级之后所有的文件都是新的格式
+	assert.Nil(t, db.Upgrade())
+	checkFileVersion(t, db, data.CurrentFormatVersion)
+	check(db)
+	dataSize, err := db.computeDataSize()
+	assert.Nil(t, err)
+	assert.Equal(t, dataSize, db.Stat().DataSize)
+	for fid := range db.olderFile {
+		hintFile, err := os.ReadFile(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+		header, err := data.DecodeFileHeader(hintFile)
+		assert.Nil(t, err)
+		assert.NotNil(t, header)
+	}
+
+	//已经是最新的格式时什么都不做
+	activeFileId := db.activeFile.FileId
+	assert.Nil(t, db.Upgrade())
+	assert.Equal(t, activeFileId, db.activeFile.FileId)
+
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	checkFileVersion(t, db, data.CurrentFormatVersion)
+	check(db)
+}
+
+func TestUpgrade(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade")
+	opts.DirPath = dir
+	expected := makeLegacyDir(t, dir)
+
+	assert.Nil(t, Upgrade(opts))
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	checkFileVersion(t, db, data.CurrentFormatVersion)
+	assert.Equal(t, len(expected), db.index.Size())
+	for i, value := range expected {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+// 写满的旧格式文件升级之后每条记录都多了版本信息，生成的文件比原来的多，id不能和升级时新的活跃文件冲突
+func TestUpgrade_FullLegacyFiles(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade")
+	opts.DirPath = dir
+	opts.DataFileSize = 4096
+	expected := make(map[int][]byte)
+	for fid := 0; fid < 2; fid++ {
+		var keys []int
+		var values [][]byte
+		for i := fid * 110; i < fid*110+110; i++ {
+			value := []byte(fmt.Sprintf("%04d", i))
+			keys, values = append(keys, i), append(values, value)
+			expected[i] = value
+		}
+		writeLegacyDataFile(t, dir, uint32(fid), keys, values)
+		stat, err := os.Stat(data.GetDataFileName(dir, uint32(fid)))
+		assert.Nil(t, err)
+		assert.True(t, stat.Size() > opts.DataFileSize*9/10 && stat.Size() <= opts.DataFileSize)
+	}
+
+	assert.Nil(t, Upgrade(opts))
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+	checkFileVersion(t, db, data.CurrentFormatVersion)
+	assert.True(t, len(db.olderFile) > 2)
+	assert.Equal(t, len(expected), db.index.Size())
+	for i, value := range expected {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("new-value")))
+	assert.Nil(t, db.Close())
+	db, err = OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected)+1, db.index.Size())
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_UnsupportedFileFormat(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+
+	//更新的版本写入的数据文件无法读取
+	header := data.EncodeFileHeader(&data.FileHeader{Version: data.CurrentFormatVersion + 1})
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, 0), header, 0644))
+	_, err := OpenDB(opts)
+	assert.Equal(t, ErrUnsupportedFileFormat, err)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package utils
+
+import "errors"
+
+// 其他平台上暂时无法获取磁盘的剩余空间
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	return 0, errors.New("available disk size is not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// 获取dirPath所在磁盘对调用者剩余的可用空间大小
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dirPath, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,17 @@
+package utils
+
+import "golang.org/x/sys/windows"
+
+// 获取dirPath所在磁盘对调用者剩余的可用空间大小
+func AvailableDiskSize(dirPath string) (uint64, error) {
+	dirPtr, err := windows.UTF16PtrFromString(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytesAvailableToCaller, totalNumberOfBytes, totalNumberOfFreeBytes uint64
+	err = windows.GetDiskFreeSpaceEx(dirPtr, &freeBytesAvailableToCaller, &totalNumberOfBytes, &totalNumberOfFreeBytes)
+	if err != nil {
+		return 0, err
+	}
+	return freeBytesAvailableToCaller, nil
+}
@@ -1,84 +1,114 @@
 package utils
 
 import (
-	"golang.org/x/sys/windows"
-	"io/fs"
+	"bitcask-go/vfs"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
-	"unsafe"
 )
 
-// 获取一个目录的大小 用于计算是否达到了满足merge的阈值
-func DirSize(dirPath string) (int64, error) {
+// 获取一个目录的大小 用于计算是否达到了满足merge的阈值   目录位于fs文件系统中
+func DirSize(fs vfs.FS, dirPath string) (int64, error) {
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
 	var size int64
-	err := filepath.Walk(dirPath, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subSize, err := DirSize(fs, filepath.Join(dirPath, entry.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += subSize
+			continue
 		}
-		if !info.IsDir() {
-			size += info.Size()
+		info, err := entry.Info()
+		if os.IsNotExist(err) { //后台生成hint文件等任务可能刚好把临时文件重命名或者删除了
+			continue
 		}
-		return nil
-	})
-	return size, err
-}
-
-// 获取磁盘剩余可用空间大小
-func AvailableDiskSize() (uint64, error) {
-	wd, err := os.Getwd() //获得当前工作目录
-	var freeBytesAvailableToCaller, totalNumberOfBytes, totalNumberOfFreeBytes uint64
-
-	err = windows.GetDiskFreeSpaceEx(
-		windows.StringToUTF16Ptr(wd),
-		(*uint64)(unsafe.Pointer(&freeBytesAvailableToCaller)),
-		(*uint64)(unsafe.Pointer(&totalNumberOfBytes)),
-		(*uint64)(unsafe.Pointer(&totalNumberOfFreeBytes)),
-	)
-
-	if err != nil {
-		return 0, err
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
 	}
-
-	return uint64(freeBytesAvailableToCaller), nil
+	return size, nil
 }
 
-// 拷贝数据目录
-func CopyDir(src, dest string, exclude []string) error {
+// 拷贝数据目录   源目录和目标目录都位于fs文件系统中
+func CopyDir(fs vfs.FS, src, dest string, exclude []string) error {
 	//传入的参数：源路径，目标路径    排除的路径(文件锁的路径)
 	//如果目标文件夹不存在的话，直接创建对应的目录
-	if _, err := os.Stat(dest); os.IsNotExist(err) {
+	if _, err := fs.Stat(dest); os.IsNotExist(err) {
 		//目标路径不存在，直接创建
-		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		if err := fs.MkdirAll(dest, os.ModePerm); err != nil {
 			return err
 		}
 	}
 
-	//filepath.Walk用于递归遍历指定目录下的所有文件和子目录
-	//其中第二个参数是一个匿名函数，表示对每一个文件或者目录的进行的处理
-	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
-		fileName := strings.Replace(path, src, "", 1)
-		if fileName == "" {
-			return nil
-		}
+	entries, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		//跳过需要排除的文件
+		var excluded bool
 		for _, e := range exclude {
-			matched, err := filepath.Match(e, info.Name())
+			matched, err := filepath.Match(e, entry.Name())
 			if err != nil {
 				return err
 			}
 			if matched {
-				return nil
+				excluded = true
+				break
 			}
 		}
-		if info.IsDir() {
-			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
+		if excluded {
+			continue
 		}
 
-		data, err := os.ReadFile(filepath.Join(src, fileName))
-		if err != nil {
-			return nil
+		srcPath, destPath := filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())
+		if entry.IsDir() { //递归拷贝子目录
+			if err := CopyDir(fs, srcPath, destPath, exclude); err != nil {
+				return err
+			}
+			continue
 		}
-		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
+		//拷贝的过程中文件可能被删除或者重命名了(例如后台生成的临时文件)，直接跳过
+		if err := CopyFile(fs, srcPath, destPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 拷贝单个文件，目标文件已经存在的话会被覆盖   文件位于fs文件系统中
+func CopyFile(fs vfs.FS, src, dest string) error {
+	srcFile, err := fs.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, info.Size())
+	if _, err := srcFile.ReadAt(data, 0); err != nil && err != io.EOF {
+		return err
+	}
 
-	})
+	destFile, err := fs.OpenFile(dest, os.O_CREATE|os.O_RDWR|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := destFile.Write(data); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	if err := destFile.Sync(); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	return destFile.Close()
 }
@@ -2,12 +2,16 @@ package utils
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
 func TestAvailableDiskSize(t *testing.T) {
-	size, err := AvailableDiskSize()
+	size, err := AvailableDiskSize(os.TempDir())
 	assert.Nil(t, err)
 	t.Log("对调用者的空闲空间", size/1024/1024/1024)
 	assert.True(t, size > 0)
+
+	_, err = AvailableDiskSize("not-exist-dir")
+	assert.NotNil(t, err)
 }
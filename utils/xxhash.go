@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// XXH64哈希算法(seed为0)，用于数据文件中可选的64位校验值
+// 算法说明见 https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+
+// 使用变量而不是常量，初始化累加器时的溢出是算法的一部分
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// 计算buf的XXH64哈希值
+func XXHash64(buf []byte) uint64 {
+	n := len(buf)
+	var h uint64
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for len(buf) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(buf[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(buf[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(buf[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(buf[24:32]))
+			buf = buf[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	//处理剩下不足32字节的部分
+	for ; len(buf) >= 8; buf = buf[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(buf[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(buf) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(buf[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		buf = buf[4:]
+	}
+	for _, b := range buf {
+		h ^= uint64(b) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
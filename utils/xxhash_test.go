@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	assert.Equal(t, uint64(0xef46db3751d8e999), XXHash64(nil))
+	assert.Equal(t, uint64(0xd24ec4f1a98c6e5b), XXHash64([]byte("a")))
+	assert.Equal(t, uint64(0x44bc2cf5ad770999), XXHash64([]byte("abc")))
+	//超过32字节，经过完整的4路累加
+	assert.Equal(t, uint64(0xfbcea83c8a378bf1), XXHash64([]byte("Nobody inspects the spammish repetition")))
+}
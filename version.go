@@ -0,0 +1,566 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/vfs"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// 多版本读取
+// 数据文件中的每条记录都带有提交时间，以及指向同一个key上一个版本的指针(见data/log_record.go)，从key的最新版本出发沿着指针就能依次找到更早的版本
+// 被删除的key不在内存索引中，配置了VersionRetention时db.tombstones记录了它们最新的墓碑值的位置，同样可以从这里找到删除之前的版本
+// 没有配置VersionRetention时不记录墓碑值，删除不会在内存中留下任何数据，key的历史版本在删除之后就读不到了，删除之后重新写入的版本也不再指向删除之前的版本
+//
+// merge会重写数据文件，重写之后文件的序号(FileHeader.Serial)改变，指向旧文件的指针通过序号就能识别出已经失效
+// 被保留下来的版本如果还被没有重写的记录指向着，它在新文件中的位置记录在db.versionRemap中，通过失效的指针仍然可以找到
+// Option.VersionRetention决定merge时保留多长时间之内的版本：在这段时间内仍然是最新版本的记录都会保留，为0时merge只保留最新的版本
+//
+// 限制：
+// 提交时间使用本机的时钟，同一个key的版本在同一个进程中保证递增，重启前后时钟回拨的话GetAt的结果可能不准确
+// 以前的版本写入的记录没有提交时间，当作比所有带有时间的版本都早
+// 使用B+树索引时启动不会回放数据文件，重启之前被删除的key无法再找到它的墓碑值，也就读不到删除之前的版本
+// merge提交之后、保存versionRemap之前崩溃的话，merge期间写入的记录指向的旧版本可能就找不到了
+
+// Version key的一个版本
+type Version struct {
+	Value     []byte    //这个版本的value，删除时为nil
+	Timestamp time.Time //提交时间，以前的版本写入的记录没有提交时间，为零值
+	Deleted   bool      //这个版本是否是删除
+}
+
+// 数据文件中一条记录的位置
+type versionPos struct {
+	fid    uint32
+	offset int64
+}
+
+// 失效的版本指针在db.versionRemap中的key   加上文件的序号，同一个位置在不同的文件中不会混淆
+type versionRef struct {
+	fid    uint32
+	serial uint32
+	offset int64
+}
+
+func refOfVersionPtr(ptr *data.VersionPtr) versionRef {
+	return versionRef{fid: ptr.Pos.Fid, serial: ptr.Serial, offset: ptr.Pos.Offset}
+}
+
+// GetAt 读取key在t时刻的value
+// t时刻key还不存在或者已经被删除时返回ErrKeyNotFound，t时刻的版本已经被merge回收时返回ErrVersionNotRetained
+func (db *DB) GetAt(key []byte, t time.Time) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyisEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	timestamp := t.UnixNano()
+	var found *data.LogRecord
+	truncated, err := db.walkVersions(key, func(logRecord *data.LogRecord) bool {
+		if logRecord.Timestamp <= timestamp {
+			found = logRecord
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		if truncated {
+			return nil, ErrVersionNotRetained
+		}
+		return nil, ErrKeyNotFound
+	}
+	if found.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	return found.Value, nil
+}
+
+// History 从新到旧返回key最多limit个版本，包括删除，limit小于等于0表示返回所有还保留着的版本
+func (db *DB) History(key []byte, limit int) ([]*Version, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyisEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var versions []*Version
+	_, err := db.walkVersions(key, func(logRecord *data.LogRecord) bool {
+		version := &Version{Deleted: logRecord.Type == data.LogRecordDeleted}
+		if !version.Deleted {
+			version.Value = logRecord.Value
+		}
+		if logRecord.Timestamp != 0 {
+			version.Timestamp = time.Unix(0, logRecord.Timestamp)
+		}
+		versions = append(versions, version)
+		return limit <= 0 || len(versions) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return versions, nil
+}
+
+// 从新到旧依次读取key的每个版本，fn返回false时停止   更早的版本已经找不到(被merge回收了)时返回true
+// 在访问此方法前必须持有读锁
+func (db *DB) walkVersions(key []byte, fn func(logRecord *data.LogRecord) bool) (bool, error) {
+	pos := db.versionHead(key)
+	for pos != nil {
+		logRecord, err := db.readLogRecordAt(pos)
+		if err != nil {
+			return false, err
+		}
+		if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, key) {
+			return true, nil
+		}
+		if !fn(logRecord) || logRecord.Prev == nil {
+			return false, nil
+		}
+		prev := db.resolveVersion(logRecord.Prev)
+		//上一个版本一定写在更前面，否则说明指针已经失效
+		if prev == nil || !versionBefore(prev, pos) {
+			return true, nil
+		}
+		pos = prev
+	}
+	return false, nil
+}
+
+// key最新的版本：内存索引中的位置，已经被删除的key是最新的墓碑值的位置   在访问此方法前必须持有读锁
+func (db *DB) versionHead(key []byte) *data.LogRecordPos {
+	if pos := db.index.Get(key); pos != nil {
+		return pos
+	}
+	return db.tombstones[string(key)]
+}
+
+// 找到版本指针当前指向的位置，已经找不到时返回nil   在访问此方法前必须持有读锁
+// 指针所在的文件被重写过的话，依次在versionRemap中查找这个版本被移动到的位置
+func (db *DB) resolveVersion(ptr *data.VersionPtr) *data.LogRecordPos {
+	for i := 0; ptr != nil && i <= len(db.versionRemap); i++ {
+		if dataFile := db.getDataFile(ptr.Pos.Fid); dataFile != nil && dataFile.Header.Serial == ptr.Serial {
+			pos := ptr.Pos
+			return &pos
+		}
+		ptr = db.versionRemap[refOfVersionPtr(ptr)]
+	}
+	return nil
+}
+
+// a是否写在b之前
+func versionBefore(a, b *data.LogRecordPos) bool {
+	return a.Fid < b.Fid || (a.Fid == b.Fid && a.Offset < b.Offset)
+}
+
+// 根据文件id找到数据文件   在访问此方法前必须持有读锁
+func (db *DB) getDataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.olderFile[fid]
+}
+
+// 读取pos位置上的记录   在访问此方法前必须持有读锁
+func (db *DB) readLogRecordAt(pos *data.LogRecordPos) (*data.LogRecord, error) {
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	db.applyAttachTime(pos.Fid, logRecord)
+	return logRecord, nil
+}
+
+// 批量导入挂载的文件中的记录使用挂载时的提交时间，在这之前都不可见
+// 在访问此方法前必须持有读锁或者tierLock，挂载时间只在同时持有两者的时候修改
+func (db *DB) applyAttachTime(fid uint32, logRecord *data.LogRecord) {
+	if t, ok := db.attachTimes[fid]; ok {
+		logRecord.Timestamp = t
+	}
+}
+
+// 指向pos位置上的版本的指针   在访问此方法前必须持有读锁
+func (db *DB) versionPtr(pos *data.LogRecordPos) *data.VersionPtr {
+	if pos == nil {
+		return nil
+	}
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return nil
+	}
+	return &data.VersionPtr{Pos: *pos, Serial: dataFile.Header.Serial}
+}
+
+// 为即将写入的记录设置提交时间以及指向key当前版本的指针   在访问此方法前必须持有互斥锁
+func (db *DB) setVersion(key []byte, logRecord *data.LogRecord, timestamp int64) {
+	logRecord.Timestamp = timestamp
+	logRecord.Prev = db.versionPtr(db.versionHead(key))
+}
+
+// 新的提交时间   时钟回拨的时候也保证递增，同一个key的版本按照时间排序   在访问此方法前必须持有互斥锁
+func (db *DB) commitTimestamp() int64 {
+	now := time.Now().UnixNano()
+	if now <= db.lastTimestamp {
+		now = db.lastTimestamp + 1
+	}
+	db.lastTimestamp = now
+	return now
+}
+
+// 分配一个新的数据文件序号   merge的goroutine中也会调用，不需要持有锁
+func (db *DB) nextFileSerial() uint32 {
+	for {
+		if serial := atomic.AddUint32(&db.fileSerial, 1) & data.MaxFileSerial; serial != 0 {
+			return serial
+		}
+	}
+}
+
+// 已经分配过的序号，之后分配的序号都比它大
+func (db *DB) observeFileSerial(serial uint32) {
+	for {
+		current := atomic.LoadUint32(&db.fileSerial)
+		if current >= serial || atomic.CompareAndSwapUint32(&db.fileSerial, current, serial) {
+			return
+		}
+	}
+}
+
+// 启动时从已有的数据文件中找到最大的序号
+func (db *DB) loadFileSerial() {
+	var serial uint32
+	for _, dataFile := range db.olderFile {
+		if dataFile.Header.Serial > serial {
+			serial = dataFile.Header.Serial
+		}
+	}
+	if db.activeFile != nil && db.activeFile.Header.Serial > serial {
+		serial = db.activeFile.Header.Serial
+	}
+	db.observeFileSerial(serial)
+}
+
+// 新建的数据文件使用的文件头
+func (db *DB) newDataFileHeader() *data.FileHeader {
+	return data.NewDataFileHeader(db.options.Checksum, db.nextFileSerial())
+}
+
+// 更新内存索引的同时维护被删除的key最新的墓碑值   在访问此方法前必须持有互斥锁(启动加载索引的时候除外)
+// 没有配置VersionRetention时不记录，避免被删除的key一直占用内存
+func (db *DB) updateTombstone(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	if db.options.VersionRetention <= 0 {
+		return
+	}
+	if typ == data.LogRecordDeleted {
+		db.tombstones[string(key)] = pos
+	} else {
+		delete(db.tombstones, string(key))
+	}
+}
+
+// 找到需要保留的历史版本在files中的位置   从files中出现的每个key的最新版本出发，沿着指针找到cutoff之后仍然是最新版本的记录
+// inMerge判断文件是否参与了这一次merge
+func (db *DB) retainedVersions(run *mergeRun, files []*data.DataFile, inMerge func(fid uint32) bool, cutoff int64) (map[versionPos]bool, error) {
+	retained := make(map[versionPos]bool)
+	walked := make(map[string]bool) //最新版本不在参与merge的文件中的key，只需要处理一次
+	for _, dataFile := range files {
+		var offset = dataFile.HeaderSize
+		for {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			if err := run.throttle(size); err != nil {
+				return nil, err
+			}
+			if logRecord.Type != data.LogRecordTxnFinished {
+				realKey, _ := parseLogRecordKey(logRecord.Key)
+				db.mu.RLock()
+				head := db.versionHead(realKey)
+				if head != nil && (inMerge(head.Fid) && head.Fid == dataFile.FileId && head.Offset == offset ||
+					!inMerge(head.Fid) && !walked[string(realKey)]) {
+					if !inMerge(head.Fid) {
+						walked[string(realKey)] = true
+					}
+					err = db.markRetained(retained, inMerge, head, cutoff)
+				}
+				db.mu.RUnlock()
+				if err != nil {
+					return nil, err
+				}
+			}
+			offset += size
+		}
+	}
+	return retained, nil
+}
+
+// 从最新版本head出发标记需要保留的版本   一个版本被覆盖的时间就是下一个版本的提交时间，在cutoff之前被覆盖的版本以及更早的版本都不需要保留
+// 在访问此方法前必须持有读锁
+func (db *DB) markRetained(retained map[versionPos]bool, inMerge func(fid uint32) bool, head *data.LogRecordPos, cutoff int64) error {
+	pos := head
+	logRecord, err := db.readLogRecordAt(pos)
+	if err != nil {
+		return err
+	}
+	//在cutoff之前就已经被删除的key，整个历史都不需要保留
+	if logRecord.Type == data.LogRecordDeleted && logRecord.Timestamp < cutoff {
+		return nil
+	}
+	for {
+		if inMerge(pos.Fid) {
+			retained[versionPos{fid: pos.Fid, offset: pos.Offset}] = true
+		}
+		if logRecord.Prev == nil || logRecord.Timestamp < cutoff {
+			return nil
+		}
+		prev := db.resolveVersion(logRecord.Prev)
+		if prev == nil || !versionBefore(prev, pos) {
+			return nil
+		}
+		pos = prev
+		if logRecord, err = db.readLogRecordAt(pos); err != nil {
+			return err
+		}
+	}
+}
+
+// merge重写的一条记录
+type movedVersion struct {
+	key        []byte
+	ptr        *data.VersionPtr //在新文件中的位置
+	history    bool             //重写的时候已经不是最新的版本
+	referenced bool             //重写之后的下一个版本已经指向了新的位置
+}
+
+// 重写记录之前更新它的版本指针：上一个版本也被重写了的话指向新的位置，否则保持不变(已经失效的指针读取时会识别出来)
+// 返回上一个版本是否也被重写了   在访问此方法前必须持有读锁
+func (db *DB) relinkVersion(logRecord *data.LogRecord, moved map[versionPos]*movedVersion) bool {
+	if logRecord.Prev == nil || len(moved) == 0 {
+		return false
+	}
+	prev := db.resolveVersion(logRecord.Prev)
+	if prev == nil {
+		return false
+	}
+	mv, ok := moved[versionPos{fid: prev.Fid, offset: prev.Offset}]
+	if !ok {
+		return false
+	}
+	mv.referenced = true
+	logRecord.Prev = mv.ptr
+	return true
+}
+
+// 旧文件中被重写的记录是否还需要通过versionRemap找到   在访问此方法前必须持有互斥锁
+// 最新的版本由索引和墓碑值直接指向，下一个版本已经指向了新位置的也不需要
+func (db *DB) needRemap(mv *movedVersion) bool {
+	if mv.referenced {
+		return false
+	}
+	head := db.versionHead(mv.key)
+	return head == nil || head.Fid != mv.ptr.Pos.Fid || head.Offset != mv.ptr.Pos.Offset
+}
+
+// 全量merge生效之后更新墓碑值的位置以及versionRemap   在访问此方法前必须持有互斥锁
+func (db *DB) applyMovedVersions(nonMergeFileId uint32, filtered []*filteredRecord, moved map[versionPos]*movedVersion, oldSerials map[uint32]uint32) {
+	//参与merge的文件中的墓碑值：被重写了的更新到新的位置，没有保留的删除
+	for key, pos := range db.tombstones {
+		if pos.Fid >= nonMergeFileId {
+			continue
+		}
+		if mv, ok := moved[versionPos{fid: pos.Fid, offset: pos.Offset}]; ok {
+			newPos := mv.ptr.Pos
+			db.tombstones[key] = &newPos
+		} else {
+			delete(db.tombstones, key)
+		}
+	}
+	//被压缩过滤器丢弃的key，最新的版本是merge写入的墓碑值
+	for _, record := range filtered {
+		if db.options.VersionRetention > 0 && record.tombstone != nil && db.index.Get(record.key) == nil && db.tombstones[string(record.key)] == nil {
+			db.tombstones[string(record.key)] = record.tombstone
+		}
+	}
+
+	//参与merge的记录都已经重写了，以前的versionRemap不再需要，只保留这一次被移动的、仍然被旧的指针指向着的版本
+	remap := make(map[versionRef]*data.VersionPtr)
+	for oldPos, mv := range moved {
+		if db.needRemap(mv) {
+			remap[versionRef{fid: oldPos.fid, serial: oldSerials[oldPos.fid], offset: oldPos.offset}] = mv.ptr
+		}
+	}
+	db.versionRemap = remap
+	//保存失败的话重启之后只是更早的版本找不到了
+	_ = db.writeVersionRemap()
+}
+
+// 选择性merge替换了fileId的文件之后更新墓碑值的位置以及versionRemap   在访问此方法前必须持有互斥锁
+// 其他文件中的记录仍然可能指向这个文件中原来的位置，被移动的历史版本都加入versionRemap
+func (db *DB) applyCompactedVersions(fileId, oldSerial uint32, moved map[versionPos]*movedVersion) {
+	for key, pos := range db.tombstones {
+		if pos.Fid != fileId {
+			continue
+		}
+		if mv, ok := moved[versionPos{fid: pos.Fid, offset: pos.Offset}]; ok {
+			newPos := mv.ptr.Pos
+			db.tombstones[key] = &newPos
+		} else {
+			delete(db.tombstones, key)
+		}
+	}
+	var changed bool
+	for oldPos, mv := range moved {
+		if db.needRemap(mv) {
+			db.versionRemap[versionRef{fid: fileId, serial: oldSerial, offset: oldPos.offset}] = mv.ptr
+			changed = true
+		}
+	}
+	if changed {
+		_ = db.writeVersionRemap()
+	}
+}
+
+// 把versionRemap保存到version-remap文件中，先写临时文件再重命名，没有内容时删除文件   在访问此方法前必须持有互斥锁
+func (db *DB) writeVersionRemap() error {
+	fileName := filepath.Join(db.options.DirPath, data.VersionRemapFileName)
+	if len(db.versionRemap) == 0 {
+		if err := db.fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmpFileName := fileName + ".tmp"
+	_ = db.fs.Remove(tmpFileName)
+
+	remapFile, err := data.OpenVersionRemapFile(db.fs, tmpFileName)
+	if err != nil {
+		return err
+	}
+	writeErr := func() error {
+		for ref, ptr := range db.versionRemap {
+			if err := remapFile.Write(encodeVersionRemap(ref, ptr)); err != nil {
+				return err
+			}
+		}
+		return remapFile.Sync()
+	}()
+	if err := remapFile.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		_ = db.fs.Remove(tmpFileName)
+		return writeErr
+	}
+	return db.fs.Rename(tmpFileName, fileName)
+}
+
+// 加载version-remap文件   文件损坏的时候直接丢弃，只是更早的版本找不到了
+func (db *DB) loadVersionRemap() error {
+	fileName := filepath.Join(db.options.DirPath, data.VersionRemapFileName)
+	if _, err := db.fs.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	remapFile, err := data.OpenVersionRemapFile(db.fs, fileName)
+	if err != nil {
+		return err
+	}
+	defer remapFile.Close()
+
+	remap := make(map[versionRef]*data.VersionPtr)
+	var offset int64 = 0
+	for {
+		logRecord, size, err := remapFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil
+		}
+		ref, ptr, ok := decodeVersionRemap(logRecord)
+		if !ok {
+			return nil
+		}
+		remap[ref] = ptr
+		offset += size
+	}
+	db.versionRemap = remap
+	return nil
+}
+
+// 删除version-remap文件   merge生效之后以前的记录都已经重写了
+func removeVersionRemap(fs vfs.FS, dirPath string) error {
+	fileName := filepath.Join(dirPath, data.VersionRemapFileName)
+	if err := fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 对versionRemap中的一项进行编码   key是失效的指针，value是版本现在的位置以及所在文件的序号
+func encodeVersionRemap(ref versionRef, ptr *data.VersionPtr) []byte {
+	key := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	var n = 0
+	n += binary.PutUvarint(key[n:], uint64(ref.fid))
+	n += binary.PutUvarint(key[n:], uint64(ref.serial))
+	n += binary.PutVarint(key[n:], ref.offset)
+	value := data.EncodeLogRecordPos(&ptr.Pos)
+	value = binary.AppendUvarint(value, uint64(ptr.Serial))
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: key[:n], Value: value})
+	return encRecord
+}
+
+// 对versionRemap中的一项进行解码
+func decodeVersionRemap(logRecord *data.LogRecord) (versionRef, *data.VersionPtr, bool) {
+	var ref versionRef
+	var index = 0
+	fid, n := binary.Uvarint(logRecord.Key)
+	if n <= 0 {
+		return ref, nil, false
+	}
+	index += n
+	serial, n := binary.Uvarint(logRecord.Key[index:])
+	if n <= 0 {
+		return ref, nil, false
+	}
+	index += n
+	offset, n := binary.Varint(logRecord.Key[index:])
+	if n <= 0 {
+		return ref, nil, false
+	}
+	ref = versionRef{fid: uint32(fid), serial: uint32(serial), offset: offset}
+
+	//位置信息的三个字段之后是序号
+	index = 0
+	for i := 0; i < 3; i++ {
+		_, n := binary.Varint(logRecord.Value[index:])
+		if n <= 0 {
+			return ref, nil, false
+		}
+		index += n
+	}
+	ptrSerial, n := binary.Uvarint(logRecord.Value[index:])
+	if n <= 0 {
+		return ref, nil, false
+	}
+	ptr := &data.VersionPtr{Pos: *data.DecodeLogRecordPos(logRecord.Value[:index]), Serial: uint32(ptrSerial)}
+	return ref, ptr, true
+}
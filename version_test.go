@@ -0,0 +1,331 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 每写入key的一个版本之后再写入一些其他数据，让key的版本分布在多个数据文件中
+func putVersions(t *testing.T, db *DB, key []byte, n int) [][]byte {
+	var values [][]byte
+	for i := 0; i < n; i++ {
+		value := utils.RandomValue(32)
+		assert.Nil(t, db.Put(key, value))
+		values = append(values, value)
+		for j := 0; j < 200; j++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(j), utils.RandomValue(64)))
+		}
+	}
+	return values
+}
+
+// 检查key的历史版本和values从新到旧一一对应
+func assertHistory(t *testing.T, db *DB, key []byte, values [][]byte) {
+	versions, err := db.History(key, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(versions))
+	for i, version := range versions {
+		if i < len(values) {
+			assert.Equal(t, values[len(values)-1-i], version.Value)
+		}
+	}
+}
+
+func TestDB_History(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.VersionRetention = time.Hour
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	key := []byte("name")
+	var times []time.Time
+	for _, value := range []string{"v1", "v2", "v3"} {
+		assert.Nil(t, db.Put(key, []byte(value)))
+		times = append(times, time.Now())
+	}
+	assert.Nil(t, db.Delete(key))
+	deletedAt := time.Now()
+	assert.Nil(t, db.Put(key, []byte("v4")))
+
+	versions, err := db.History(key, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(versions))
+	assert.Equal(t, []byte("v4"), versions[0].Value)
+	assert.True(t, versions[1].Deleted)
+	assert.Nil(t, versions[1].Value)
+	assert.Equal(t, []byte("v3"), versions[2].Value)
+	assert.Equal(t, []byte("v1"), versions[4].Value)
+	for i := 1; i < len(versions); i++ {
+		assert.True(t, versions[i].Timestamp.Before(versions[i-1].Timestamp))
+	}
+
+	versions, err = db.History(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+
+	//每个时刻读到的都是当时的value
+	for i, value := range []string{"v1", "v2", "v3"} {
+		val, err := db.GetAt(key, times[i])
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(value), val)
+	}
+	_, err = db.GetAt(key, deletedAt)
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.GetAt(key, versions[1].Timestamp.Add(-time.Hour))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.GetAt(key, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v4"), val)
+
+	_, err = db.History([]byte("unknown"), 0)
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.GetAt(nil, time.Now())
+	assert.Equal(t, ErrKeyisEmpty, err)
+}
+
+func TestDB_History_Deleted(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.IndexCheckpoint = true
+	opts.VersionRetention = time.Hour
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	//被删除的key仍然可以读到删除之前的版本，重启之后也一样
+	key := []byte("name")
+	assert.Nil(t, db.Put(key, []byte("v1")))
+	putAt := time.Now()
+	assert.Nil(t, db.Delete(key))
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	check := func(db *DB) {
+		versions, err := db.History(key, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(versions))
+		assert.True(t, versions[0].Deleted)
+		assert.Equal(t, []byte("v1"), versions[1].Value)
+		val, err := db.GetAt(key, putAt)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+	}
+	check(db)
+
+	//关闭时保存了检查点，墓碑值从检查点中加载
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	check(db2)
+
+	//检查点之后的删除从数据文件中回放
+	assert.Nil(t, db2.Put([]byte("age"), []byte("18")))
+	assert.Nil(t, db2.Delete([]byte("age")))
+	db2.options.IndexCheckpoint = false
+	assert.Nil(t, db2.Close())
+	db3, err := OpenDB(opts)
+	assert.Nil(t, err)
+	check(db3)
+	versions, err := db3.History([]byte("age"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	assert.Nil(t, db3.Close())
+}
+
+// 没有配置保留时长时不记录墓碑值，被删除的key不会一直占用内存
+func TestDB_History_DeletedWithoutRetention(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.IndexCheckpoint = true
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, 0, len(db.tombstones))
+	_, err = db.History(utils.GetTestKey(1), 0)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	//重新写入之后只有删除之后的版本
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("v2")))
+	versions, err := db.History(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(versions))
+
+	//重启之后从检查点和数据文件中加载也不会记录
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db2.tombstones))
+	assert.Nil(t, db2.Close())
+}
+
+// 批量写入的记录使用同一个提交时间
+func TestDB_History_Batch(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("a1")))
+	wb := db.NewWrietBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("a"), []byte("a2")))
+	assert.Nil(t, wb.Put([]byte("b"), []byte("b1")))
+	assert.Nil(t, wb.Commit())
+
+	historyA, err := db.History([]byte("a"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(historyA))
+	assert.Equal(t, []byte("a1"), historyA[1].Value)
+	historyB, err := db.History([]byte("b"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(historyB))
+	assert.Equal(t, historyA[0].Timestamp, historyB[0].Timestamp)
+}
+
+// 没有配置保留时长时merge只保留最新的版本
+func TestDB_History_MergeWithoutRetention(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	key := []byte("name")
+	values := putVersions(t, db, key, 5)
+	deleted := []byte("deleted")
+	assert.Nil(t, db.Put(deleted, []byte("v1")))
+	assert.Nil(t, db.Delete(deleted))
+	assertHistory(t, db, key, values)
+
+	assert.Nil(t, db.Merge())
+	assertHistory(t, db, key, values[len(values)-1:])
+	_, err = db.History(deleted, 0)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	//更早的版本已经被回收了
+	versions, err := db.History(key, 0)
+	assert.Nil(t, err)
+	_, err = db.GetAt(key, versions[0].Timestamp.Add(-time.Nanosecond))
+	assert.Equal(t, ErrVersionNotRetained, err)
+
+	//merge之后写入的新版本接在保留下来的版本之后
+	assert.Nil(t, db.Put(key, []byte("new")))
+	assertHistory(t, db, key, append(values[len(values)-1:], []byte("new")))
+}
+
+func TestDB_History_MergeWithRetention(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.VersionRetention = time.Hour
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	key := []byte("name")
+	values := putVersions(t, db, key, 5)
+	deleted := []byte("deleted")
+	assert.Nil(t, db.Put(deleted, []byte("v1")))
+	assert.Nil(t, db.Delete(deleted))
+
+	//保留时长之内的版本都被保留下来
+	assert.Nil(t, db.Merge())
+	assertHistory(t, db, key, values)
+	versions, err := db.History(deleted, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	assert.True(t, versions[0].Deleted)
+
+	assert.Nil(t, db.Put(key, []byte("new")))
+	values = append(values, []byte("new"))
+	assertHistory(t, db, key, values)
+
+	//merge之后重启
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assertHistory(t, db2, key, values)
+	versions, err = db2.History(deleted, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	assert.Nil(t, db2.Close())
+}
+
+// 超出保留时长的版本在merge时回收
+func TestDB_History_RetentionExpired(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.VersionRetention = 500 * time.Millisecond
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	key := []byte("name")
+	old := putVersions(t, db, key, 2)
+	assert.Nil(t, db.Put([]byte("deleted"), []byte("v1")))
+	assert.Nil(t, db.Delete([]byte("deleted")))
+	time.Sleep(time.Second)
+	recent := putVersions(t, db, key, 2)
+
+	//old[1]在保留时长之内才被覆盖，仍然可以读到；old[0]在保留时长之前就被覆盖了
+	assert.Nil(t, db.Merge())
+	assertHistory(t, db, key, append(old[1:], recent...))
+	_, err = db.History([]byte("deleted"), 0)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 选择性merge重写了部分文件，其他文件中指向这些文件的版本指针仍然可以找到移动之后的版本
+func TestDB_History_MergeWithOptions(t *testing.T) {
+	opts := DefaultOptioins
+	dir, _ := os.MkdirTemp("", "bitcask-go-version")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.VersionRetention = time.Hour
+	db, err := OpenDB(opts)
+	defer Destroy_DB(db)
+	assert.Nil(t, err)
+
+	key := []byte("name")
+	values := putVersions(t, db, key, 6)
+	stats, err := db.DataFileStats()
+	assert.Nil(t, err)
+	assert.Greater(t, len(stats), 3)
+
+	//只压缩第一个和第三个文件，key后面的版本在没有压缩的文件中
+	assert.Nil(t, db.MergeWithOptions(context.Background(), MergeOptions{FileIds: []uint32{stats[0].FileId, stats[2].FileId}}))
+	assertHistory(t, db, key, values)
+
+	assert.Nil(t, db.Close())
+	db2, err := OpenDB(opts)
+	assert.Nil(t, err)
+	assertHistory(t, db2, key, values)
+
+	//全量merge之后仍然完整
+	assert.Nil(t, db2.Merge())
+	assertHistory(t, db2, key, values)
+	assert.Nil(t, db2.Close())
+}
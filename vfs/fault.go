@@ -0,0 +1,522 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 可以注入故障的文件系统，用于测试存储引擎在各种异常情况下的一致性
+// 包装另外一个文件系统(通常是MemFS)，支持：
+//   - 让之后的第n次写入失败，或者只写入一半的数据(short write)
+//   - 让之后的第n次重命名失败
+//   - 模拟崩溃：所有没有sync的数据都会丢失，之前打开的文件全部失效，文件锁全部释放
+//   - 篡改文件中的数据
+//
+// 文件的内容只有sync之后才会持久化；目录中文件的创建、删除和重命名只有对所在的目录调用SyncDir之后才会持久化，
+// 重命名涉及的两个目录都sync之后才算持久化，崩溃时没有持久化的目录操作按照相反的顺序撤销
+// 创建目录(MkdirAll)和删除整个目录(RemoveAll)简化为立即持久化，被删除的目录中还没有持久化的操作也随之失效
+var (
+	ErrInjected = errors.New("vfs: injected fault")
+	ErrCrashed  = errors.New("vfs: the file system has crashed")
+)
+
+type FaultFS struct {
+	fs         FS
+	lock       *sync.Mutex
+	synced     map[string]int64 //每个文件已经持久化的大小，没有记录的文件视为已经全部持久化
+	writes     int              //还需要经过多少次写入才注入故障，为0表示不注入
+	renames    int              //还需要经过多少次重命名才注入故障，为0表示不注入
+	shortWrite bool             //注入故障的时候是否写入一半的数据
+	generation int              //每次崩溃之后递增，之前打开的文件全部失效
+	locks      []io.Closer      //当前持有的文件锁
+	dirOps     []*dirOp         //还没有持久化的目录操作
+}
+
+// 目录操作的类型
+const (
+	dirOpCreate = iota
+	dirOpRemove
+	dirOpRename
+)
+
+// 还没有持久化的目录操作，崩溃时撤销
+type dirOp struct {
+	kind     int
+	dirs     []string //涉及到的目录，全部sync之后这个操作才持久化
+	name     string   //创建或者删除的文件，重命名的目标
+	oldName  string   //重命名的源文件
+	isDir    bool     //删除的是空目录
+	replaced bool     //是否替换了已经存在的文件(删除、重命名)
+	content  []byte   //被删除或者替换的文件已经持久化的内容
+}
+
+// 包装一个文件系统
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{
+		fs:     fs,
+		lock:   new(sync.Mutex),
+		synced: make(map[string]int64),
+	}
+}
+
+// 让之后的第n次写入(Write或者WriteAt)返回ErrInjected，short为true时这次写入会先写入一半的数据
+// n小于等于0表示取消还没有触发的故障
+func (f *FaultFS) FailWrite(n int, short bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.writes = n
+	f.shortWrite = short
+}
+
+// 让之后的第n次重命名返回ErrInjected，文件保持不变   n小于等于0表示取消还没有触发的故障
+func (f *FaultFS) FailRename(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.renames = n
+}
+
+// 模拟崩溃   所有文件中没有sync的数据都会丢失，torn大于0时每个文件最多保留torn字节没有sync的数据，模拟写了一半的记录
+// 崩溃之前打开的文件之后的所有操作都会返回ErrCrashed
+func (f *FaultFS) Crash(torn int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.generation++
+	f.writes = 0
+	f.renames = 0
+	for _, l := range f.locks {
+		_ = l.Close()
+	}
+	f.locks = nil
+	for i := len(f.dirOps) - 1; i >= 0; i-- {
+		if err := f.undo(f.dirOps[i]); err != nil {
+			return err
+		}
+	}
+	f.dirOps = nil
+	for name, syncedSize := range f.synced {
+		info, err := f.fs.Stat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if size := syncedSize + torn; info.Size() > size {
+			if err := f.truncate(name, size); err != nil {
+				return err
+			}
+		}
+	}
+	f.synced = make(map[string]int64)
+	return nil
+}
+
+// 将文件offset处的一个字节取反
+func (f *FaultFS) Corrupt(name string, offset int64) error {
+	file, err := f.fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		return err
+	}
+	b[0] = ^b[0]
+	_, err = file.WriteAt(b, offset)
+	return err
+}
+
+func (f *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	name = filepath.Clean(name)
+	_, statErr := f.fs.Stat(name)
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if os.IsNotExist(statErr) {
+		f.dirOps = append(f.dirOps, &dirOp{kind: dirOpCreate, dirs: []string{filepath.Dir(name)}, name: name})
+	}
+	if _, ok := f.synced[name]; !ok {
+		if os.IsNotExist(statErr) || flag&os.O_TRUNC != 0 {
+			f.synced[name] = 0 //新创建的文件，还没有任何数据被持久化
+		} else if info, err := file.Stat(); err == nil {
+			f.synced[name] = info.Size()
+		}
+	}
+	return &faultFile{fs: f, name: name, file: file, generation: f.generation}, nil
+}
+
+func (f *FaultFS) Stat(name string) (os.FileInfo, error) {
+	return f.fs.Stat(name)
+}
+
+func (f *FaultFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return f.fs.ReadDir(name)
+}
+
+func (f *FaultFS) MkdirAll(path string, perm os.FileMode) error {
+	return f.fs.MkdirAll(path, perm)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	name = filepath.Clean(name)
+	op := &dirOp{kind: dirOpRemove, dirs: []string{filepath.Dir(name)}, name: name}
+	if info, err := f.fs.Stat(name); err == nil && info.IsDir() {
+		op.isDir = true
+	} else if err == nil {
+		if op.content, err = f.readSynced(name); err != nil {
+			return err
+		}
+		op.replaced = true
+	}
+	if err := f.fs.Remove(name); err != nil {
+		return err
+	}
+	delete(f.synced, name)
+	f.dirOps = append(f.dirOps, op)
+	return nil
+}
+
+func (f *FaultFS) RemoveAll(path string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path = filepath.Clean(path)
+	if err := f.fs.RemoveAll(path); err != nil {
+		return err
+	}
+	for name := range f.synced {
+		if isUnder(name, path) {
+			delete(f.synced, name)
+		}
+	}
+	//被删除的目录中的操作不需要再撤销，从这个目录中移出去的文件在崩溃之后只能丢失
+	ops := f.dirOps[:0]
+	for _, op := range f.dirOps {
+		switch {
+		case op.kind != dirOpRename && isUnder(op.name, path):
+		case op.kind == dirOpRename && isUnder(op.name, path):
+		case op.kind == dirOpRename && isUnder(op.oldName, path):
+			op.kind = dirOpCreate
+			op.dirs = []string{filepath.Dir(op.name)}
+			ops = append(ops, op)
+		default:
+			ops = append(ops, op)
+		}
+	}
+	f.dirOps = ops
+	return nil
+}
+
+func (f *FaultFS) Rename(oldPath, newPath string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	if f.renames > 0 {
+		f.renames--
+		if f.renames == 0 {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: ErrInjected}
+		}
+	}
+	op := &dirOp{kind: dirOpRename, dirs: []string{filepath.Dir(oldPath), filepath.Dir(newPath)}, name: newPath, oldName: oldPath}
+	if info, err := f.fs.Stat(newPath); err == nil && !info.IsDir() {
+		if op.content, err = f.readSynced(newPath); err != nil {
+			return err
+		}
+		op.replaced = true
+	}
+	if err := f.fs.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	f.moveSynced(oldPath, newPath)
+	f.dirOps = append(f.dirOps, op)
+	return nil
+}
+
+// 持久化目录中的文件的创建、删除和重命名
+func (f *FaultFS) SyncDir(dir string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	dir = filepath.Clean(dir)
+	if err := f.fs.SyncDir(dir); err != nil {
+		return err
+	}
+	ops := f.dirOps[:0]
+	for _, op := range f.dirOps {
+		dirs := op.dirs[:0]
+		for _, d := range op.dirs {
+			if d != dir {
+				dirs = append(dirs, d)
+			}
+		}
+		op.dirs = dirs
+		if len(dirs) > 0 {
+			ops = append(ops, op)
+		}
+	}
+	f.dirOps = ops
+	return nil
+}
+
+func (f *FaultFS) Lock(name string) (io.Closer, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	l, err := f.fs.Lock(name)
+	if err != nil {
+		return nil, err
+	}
+	f.locks = append(f.locks, l)
+	return &faultLock{fs: f, lock: l, generation: f.generation}, nil
+}
+
+// 检查是否需要对这一次写入注入故障   返回实际应该写入的长度
+func (f *FaultFS) beforeWrite(generation int, n int) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if generation != f.generation {
+		return 0, ErrCrashed
+	}
+	if f.writes <= 0 {
+		return n, nil
+	}
+	f.writes--
+	if f.writes > 0 {
+		return n, nil
+	}
+	if f.shortWrite {
+		return n / 2, ErrInjected
+	}
+	return 0, ErrInjected
+}
+
+// 撤销一个没有持久化的目录操作   调用时必须持有f.lock
+func (f *FaultFS) undo(op *dirOp) error {
+	switch op.kind {
+	case dirOpCreate:
+		if err := f.fs.Remove(op.name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(f.synced, op.name)
+	case dirOpRemove:
+		if _, err := f.fs.Stat(op.name); err == nil {
+			return nil
+		}
+		if op.isDir {
+			return f.fs.MkdirAll(op.name, os.ModePerm)
+		}
+	case dirOpRename:
+		if _, err := f.fs.Stat(op.name); err != nil {
+			break
+		}
+		if _, err := f.fs.Stat(op.oldName); err == nil {
+			break
+		}
+		if err := f.fs.MkdirAll(filepath.Dir(op.oldName), os.ModePerm); err != nil {
+			return err
+		}
+		if err := f.fs.Rename(op.name, op.oldName); err != nil {
+			return err
+		}
+		f.moveSynced(op.name, op.oldName)
+	}
+	if !op.replaced {
+		return nil
+	}
+	//恢复被删除或者替换的文件
+	if err := f.fs.MkdirAll(filepath.Dir(op.name), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := f.fs.OpenFile(op.name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(op.content, 0); err != nil {
+		return err
+	}
+	f.synced[op.name] = int64(len(op.content))
+	return nil
+}
+
+// 读取文件已经持久化的内容   调用时必须持有f.lock
+func (f *FaultFS) readSynced(name string) ([]byte, error) {
+	file, err := f.fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if synced, ok := f.synced[name]; ok && synced < size {
+		size = synced
+	}
+	content := make([]byte, size)
+	if _, err := file.ReadAt(content, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return content, nil
+}
+
+// 持久化的状态跟着文件(或者目录中的文件)一起移动   调用时必须持有f.lock
+func (f *FaultFS) moveSynced(oldPath, newPath string) {
+	delete(f.synced, newPath)
+	oldPrefix := oldPath + string(filepath.Separator)
+	for name, size := range f.synced {
+		if name == oldPath {
+			delete(f.synced, name)
+			f.synced[newPath] = size
+		} else if strings.HasPrefix(name, oldPrefix) {
+			delete(f.synced, name)
+			f.synced[filepath.Join(newPath, strings.TrimPrefix(name, oldPrefix))] = size
+		}
+	}
+}
+
+// name是否是path或者path中的文件
+func isUnder(name, path string) bool {
+	return name == path || strings.HasPrefix(name, path+string(filepath.Separator))
+}
+
+// 将文件截断到指定的大小   调用时必须持有f.lock
+func (f *FaultFS) truncate(name string, size int64) error {
+	file, err := f.fs.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Truncate(size)
+}
+
+func (f *FaultFS) checkGeneration(generation int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if generation != f.generation {
+		return ErrCrashed
+	}
+	return nil
+}
+
+// 故障文件系统中打开的文件
+type faultFile struct {
+	fs         *FaultFS
+	name       string
+	file       File
+	generation int
+}
+
+func (ff *faultFile) ReadAt(b []byte, offset int64) (int, error) {
+	if err := ff.fs.checkGeneration(ff.generation); err != nil {
+		return 0, err
+	}
+	return ff.file.ReadAt(b, offset)
+}
+
+func (ff *faultFile) Write(b []byte) (int, error) {
+	n, injectErr := ff.fs.beforeWrite(ff.generation, len(b))
+	if n > 0 {
+		written, err := ff.file.Write(b[:n])
+		if err != nil {
+			return written, err
+		}
+	}
+	if injectErr != nil {
+		return n, injectErr
+	}
+	return n, nil
+}
+
+func (ff *faultFile) WriteAt(b []byte, offset int64) (int, error) {
+	n, injectErr := ff.fs.beforeWrite(ff.generation, len(b))
+	if n > 0 {
+		written, err := ff.file.WriteAt(b[:n], offset)
+		if err != nil {
+			return written, err
+		}
+	}
+	if injectErr != nil {
+		return n, injectErr
+	}
+	return n, nil
+}
+
+// sync之后文件当前的所有数据都持久化了
+func (ff *faultFile) Sync() error {
+	ff.fs.lock.Lock()
+	defer ff.fs.lock.Unlock()
+	if ff.generation != ff.fs.generation {
+		return ErrCrashed
+	}
+	if err := ff.file.Sync(); err != nil {
+		return err
+	}
+	info, err := ff.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := ff.fs.fs.Stat(ff.name); err == nil { //文件已经被删除的话不需要再记录
+		ff.fs.synced[ff.name] = info.Size()
+	}
+	return nil
+}
+
+func (ff *faultFile) Close() error {
+	if err := ff.fs.checkGeneration(ff.generation); err != nil {
+		return err
+	}
+	return ff.file.Close()
+}
+
+func (ff *faultFile) Stat() (os.FileInfo, error) {
+	if err := ff.fs.checkGeneration(ff.generation); err != nil {
+		return nil, err
+	}
+	return ff.file.Stat()
+}
+
+func (ff *faultFile) Truncate(size int64) error {
+	ff.fs.lock.Lock()
+	defer ff.fs.lock.Unlock()
+	if ff.generation != ff.fs.generation {
+		return ErrCrashed
+	}
+	if err := ff.file.Truncate(size); err != nil {
+		return err
+	}
+	if synced, ok := ff.fs.synced[ff.name]; ok && synced > size {
+		ff.fs.synced[ff.name] = size
+	}
+	return nil
+}
+
+type faultLock struct {
+	fs         *FaultFS
+	lock       io.Closer
+	generation int
+}
+
+// 崩溃的时候锁已经被释放了，这里不需要再释放
+func (l *faultLock) Close() error {
+	l.fs.lock.Lock()
+	defer l.fs.lock.Unlock()
+	if l.generation != l.fs.generation {
+		return nil
+	}
+	for i, lock := range l.fs.locks {
+		if lock == l.lock {
+			l.fs.locks = append(l.fs.locks[:i], l.fs.locks[i+1:]...)
+			break
+		}
+	}
+	return l.lock.Close()
+}
@@ -0,0 +1,155 @@
+package vfs
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFaultFS_FailWrite(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	assert.Nil(t, fs.MkdirAll("bitcask", os.ModePerm))
+	name := filepath.Join("bitcask", "a.data")
+	file, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+
+	//第2次写入失败，什么都不写
+	fs.FailWrite(2, false)
+	_, err = file.Write([]byte("aaaa"))
+	assert.Nil(t, err)
+	n, err := file.Write([]byte("bbbb"))
+	assert.Equal(t, ErrInjected, err)
+	assert.Equal(t, 0, n)
+	stat, _ := file.Stat()
+	assert.Equal(t, int64(4), stat.Size())
+
+	//第1次写入只写入一半
+	fs.FailWrite(1, true)
+	n, err = file.Write([]byte("cccc"))
+	assert.Equal(t, ErrInjected, err)
+	assert.Equal(t, 2, n)
+	stat, _ = file.Stat()
+	assert.Equal(t, int64(6), stat.Size())
+
+	//注入的故障只触发一次
+	_, err = file.Write([]byte("dddd"))
+	assert.Nil(t, err)
+}
+
+func TestFaultFS_Crash(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	assert.Nil(t, fs.MkdirAll("bitcask", os.ModePerm))
+	name := filepath.Join("bitcask", "a.data")
+	file, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Sync())
+	assert.Nil(t, fs.SyncDir("bitcask"))
+	_, err = file.Write([]byte("-unsynced"))
+	assert.Nil(t, err)
+
+	lock, err := fs.Lock(filepath.Join("bitcask", "flock"))
+	assert.Nil(t, err)
+	assert.NotNil(t, lock)
+
+	//崩溃之后没有sync的数据只保留torn个字节，之前打开的文件都不能再使用，锁也被释放
+	assert.Nil(t, fs.Crash(2))
+	stat, err := fs.Stat(name)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), stat.Size())
+	_, err = file.Write([]byte("x"))
+	assert.Equal(t, ErrCrashed, err)
+	lock, err = fs.Lock(filepath.Join("bitcask", "flock"))
+	assert.Nil(t, err)
+	assert.Nil(t, lock.Close())
+
+	//篡改数据
+	assert.Nil(t, fs.Corrupt(name, 0))
+	file, err = fs.OpenFile(name, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	b := make([]byte, 8)
+	_, err = file.ReadAt(b, 0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, []byte("synced-u"), b)
+	assert.Equal(t, []byte("ynced-u"), b[1:])
+}
+
+func TestFaultFS_FailRename(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	assert.Nil(t, fs.MkdirAll("bitcask", os.ModePerm))
+	for _, name := range []string{"a", "b"} {
+		file, err := fs.OpenFile(filepath.Join("bitcask", name), os.O_CREATE|os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+
+	//第2次重命名失败，文件保持不变
+	fs.FailRename(2)
+	assert.Nil(t, fs.Rename(filepath.Join("bitcask", "a"), filepath.Join("bitcask", "c")))
+	err := fs.Rename(filepath.Join("bitcask", "b"), filepath.Join("bitcask", "d"))
+	assert.True(t, errors.Is(err, ErrInjected))
+	_, err = fs.Stat(filepath.Join("bitcask", "b"))
+	assert.Nil(t, err)
+
+	//注入的故障只触发一次
+	assert.Nil(t, fs.Rename(filepath.Join("bitcask", "b"), filepath.Join("bitcask", "d")))
+}
+
+func TestFaultFS_SyncDir(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	assert.Nil(t, fs.MkdirAll("bitcask", os.ModePerm))
+	writeFile := func(name, content string) {
+		file, err := fs.OpenFile(filepath.Join("bitcask", name), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(content))
+		assert.Nil(t, err)
+		assert.Nil(t, file.Sync())
+		assert.Nil(t, file.Close())
+	}
+	readFile := func(name string) string {
+		file, err := fs.OpenFile(filepath.Join("bitcask", name), os.O_RDONLY, 0)
+		if err != nil {
+			return ""
+		}
+		defer file.Close()
+		stat, _ := file.Stat()
+		b := make([]byte, stat.Size())
+		_, _ = file.ReadAt(b, 0)
+		return string(b)
+	}
+	writeFile("a", "aaaa")
+	writeFile("b", "bbbb")
+	assert.Nil(t, fs.SyncDir("bitcask"))
+
+	//没有sync目录的创建、删除和重命名在崩溃之后都被撤销
+	writeFile("c", "cccc")
+	assert.Nil(t, fs.Remove(filepath.Join("bitcask", "a")))
+	assert.Nil(t, fs.Rename(filepath.Join("bitcask", "c"), filepath.Join("bitcask", "b")))
+	assert.Equal(t, "cccc", readFile("b"))
+	assert.Nil(t, fs.Crash(0))
+	assert.Equal(t, "aaaa", readFile("a"))
+	assert.Equal(t, "bbbb", readFile("b"))
+	_, err := fs.Stat(filepath.Join("bitcask", "c"))
+	assert.True(t, os.IsNotExist(err))
+
+	//sync目录之后是持久的
+	writeFile("c", "cccc")
+	assert.Nil(t, fs.Rename(filepath.Join("bitcask", "c"), filepath.Join("bitcask", "b")))
+	assert.Nil(t, fs.SyncDir("bitcask"))
+	assert.Nil(t, fs.Crash(0))
+	assert.Equal(t, "cccc", readFile("b"))
+	_, err = fs.Stat(filepath.Join("bitcask", "c"))
+	assert.True(t, os.IsNotExist(err))
+
+	//跨目录的重命名需要两个目录都sync
+	assert.Nil(t, fs.MkdirAll("other", os.ModePerm))
+	assert.Nil(t, fs.Rename(filepath.Join("bitcask", "b"), filepath.Join("other", "b")))
+	assert.Nil(t, fs.SyncDir("other"))
+	assert.Nil(t, fs.Crash(0))
+	assert.Equal(t, "cccc", readFile("b"))
+	_, err = fs.Stat(filepath.Join("other", "b"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,424 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内存文件系统
+// 所有的文件和目录都保存在进程的内存中，语义和操作系统的文件系统保持一致：
+// 文件被删除或者重命名之后，已经打开的文件仍然可以继续读写；文件锁只在同一个MemFS内部互斥
+// 适合用于单元测试以及不需要持久化的缓存场景
+type MemFS struct {
+	lock  *sync.Mutex
+	files map[string]*memFileData //key为清理之后的文件路径
+	dirs  map[string]bool
+	locks map[string]bool //当前被持有的文件锁
+}
+
+// 文件的数据，可能同时被多个打开的文件共享
+type memFileData struct {
+	lock    *sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+// 初始化一个空的内存文件系统
+func NewMemFS() *MemFS {
+	return &MemFS{
+		lock:  new(sync.Mutex),
+		files: make(map[string]*memFileData),
+		dirs:  map[string]bool{".": true, string(filepath.Separator): true},
+		locks: make(map[string]bool),
+	}
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name = filepath.Clean(name)
+	if m.dirs[name] {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+		}
+	}
+	file, ok := m.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if !m.dirs[filepath.Dir(name)] {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		file = &memFileData{lock: new(sync.RWMutex), modTime: time.Now()}
+		m.files[name] = file
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	if flag&os.O_TRUNC != 0 {
+		file.lock.Lock()
+		file.data = nil
+		file.lock.Unlock()
+	}
+	return &MemFile{name: name, file: file, flag: flag}, nil
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name = filepath.Clean(name)
+	if m.dirs[name] {
+		return &memFileInfo{name: filepath.Base(name), isDir: true}, nil
+	}
+	file, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return file.stat(name), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]os.DirEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name = filepath.Clean(name)
+	if !m.dirs[name] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	var entries []os.DirEntry
+	for dir := range m.dirs {
+		if dir != name && filepath.Dir(dir) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(&memFileInfo{name: filepath.Base(dir), isDir: true}))
+		}
+	}
+	for fileName, file := range m.files {
+		if filepath.Dir(fileName) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(file.stat(fileName)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	path = filepath.Clean(path)
+	for dir := path; !m.dirs[dir]; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		m.dirs[dir] = true
+		if filepath.Dir(dir) == dir { //已经到了根目录，比如windows上的盘符
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if m.dirs[name] {
+		if m.hasChildren(name) {
+			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		delete(m.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *MemFS) RemoveAll(path string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	path = filepath.Clean(path)
+	delete(m.files, path)
+	delete(m.dirs, path)
+	prefix := path + string(filepath.Separator)
+	for fileName := range m.files {
+		if strings.HasPrefix(fileName, prefix) {
+			delete(m.files, fileName)
+		}
+	}
+	for dir := range m.dirs {
+		if strings.HasPrefix(dir, prefix) {
+			delete(m.dirs, dir)
+		}
+	}
+	return nil
+}
+
+// 删除内存文件系统中所有的文件和目录，释放占用的内存   已经打开的文件仍然可以继续读写
+func (m *MemFS) Clear() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.files = make(map[string]*memFileData)
+	m.dirs = map[string]bool{".": true, string(filepath.Separator): true}
+}
+
+func (m *MemFS) Rename(oldPath, newPath string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	if !m.dirs[filepath.Dir(newPath)] {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrNotExist}
+	}
+	if file, ok := m.files[oldPath]; ok {
+		if m.dirs[newPath] {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: errors.New("file exists")}
+		}
+		delete(m.files, oldPath)
+		m.files[newPath] = file
+		return nil
+	}
+	if !m.dirs[oldPath] {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrNotExist}
+	}
+	//重命名目录，目标目录必须不存在或者为空
+	if _, ok := m.files[newPath]; ok || (m.dirs[newPath] && m.hasChildren(newPath)) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: errors.New("file exists")}
+	}
+	oldPrefix := oldPath + string(filepath.Separator)
+	for fileName, file := range m.files {
+		if strings.HasPrefix(fileName, oldPrefix) {
+			delete(m.files, fileName)
+			m.files[filepath.Join(newPath, strings.TrimPrefix(fileName, oldPrefix))] = file
+		}
+	}
+	for dir := range m.dirs {
+		if strings.HasPrefix(dir, oldPrefix) {
+			delete(m.dirs, dir)
+			m.dirs[filepath.Join(newPath, strings.TrimPrefix(dir, oldPrefix))] = true
+		}
+	}
+	delete(m.dirs, oldPath)
+	m.dirs[newPath] = true
+	return nil
+}
+
+// 内存中的目录不需要持久化
+func (m *MemFS) SyncDir(dir string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.dirs[filepath.Clean(dir)] {
+		return &os.PathError{Op: "sync", Path: dir, Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (m *MemFS) Lock(name string) (io.Closer, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	name = filepath.Clean(name)
+	if m.locks[name] {
+		return nil, ErrLocked
+	}
+	if !m.dirs[filepath.Dir(name)] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	//和flock一样，锁对应的文件会留在目录中
+	if _, ok := m.files[name]; !ok {
+		m.files[name] = &memFileData{lock: new(sync.RWMutex), modTime: time.Now()}
+	}
+	m.locks[name] = true
+	return &memLock{fs: m, name: name}, nil
+}
+
+// 目录下是否还有文件或者子目录   调用时必须持有m.lock
+func (m *MemFS) hasChildren(dir string) bool {
+	prefix := dir + string(filepath.Separator)
+	for fileName := range m.files {
+		if strings.HasPrefix(fileName, prefix) {
+			return true
+		}
+	}
+	for d := range m.dirs {
+		if strings.HasPrefix(d, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *memFileData) stat(name string) *memFileInfo {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return &memFileInfo{name: filepath.Base(name), size: int64(len(f.data)), modTime: f.modTime}
+}
+
+// 内存文件系统中打开的文件
+type MemFile struct {
+	name   string
+	file   *memFileData
+	flag   int
+	offset int64 //Write写入的位置，以O_APPEND打开时总是写到文件末尾
+	closed bool
+}
+
+func (f *MemFile) ReadAt(b []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	f.file.lock.RLock()
+	defer f.file.lock.RUnlock()
+	if offset >= int64(len(f.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.file.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *MemFile) Write(b []byte) (int, error) {
+	if err := f.checkWritable(); err != nil {
+		return 0, err
+	}
+	f.file.lock.Lock()
+	defer f.file.lock.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.file.data))
+	}
+	f.file.writeAt(b, f.offset)
+	f.offset += int64(len(b))
+	return len(b), nil
+}
+
+func (f *MemFile) WriteAt(b []byte, offset int64) (int, error) {
+	if err := f.checkWritable(); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, errors.New("vfs: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	f.file.lock.Lock()
+	defer f.file.lock.Unlock()
+	f.file.writeAt(b, offset)
+	return len(b), nil
+}
+
+// 内存中的数据不需要持久化
+func (f *MemFile) Sync() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (f *MemFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *MemFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.file.stat(f.name), nil
+}
+
+func (f *MemFile) Truncate(size int64) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	f.file.lock.Lock()
+	defer f.file.lock.Unlock()
+	if size <= int64(len(f.file.data)) {
+		f.file.data = f.file.data[:size]
+	} else {
+		f.file.data = append(f.file.data, make([]byte, size-int64(len(f.file.data)))...)
+	}
+	f.file.modTime = time.Now()
+	return nil
+}
+
+// 获取文件的大小   和Stat相比不需要分配文件信息
+func (f *MemFile) Size() int64 {
+	f.file.lock.RLock()
+	defer f.file.lock.RUnlock()
+	return int64(len(f.file.data))
+}
+
+func (f *MemFile) checkWritable() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// 在offset处写入数据，超出文件末尾的部分会自动扩展   调用时必须持有写锁
+func (f *memFileData) writeAt(b []byte, offset int64) {
+	if end, oldLen := offset+int64(len(b)), int64(len(f.data)); end > oldLen {
+		if end > int64(cap(f.data)) {
+			newData := make([]byte, end, end*2)
+			copy(newData, f.data)
+			f.data = newData
+		} else {
+			f.data = f.data[:end]
+			//截断之后底层数组中可能还留着旧的数据，写入位置之前空出来的部分需要补零
+			for i := oldLen; i < offset; i++ {
+				f.data[i] = 0
+			}
+		}
+	}
+	copy(f.data[offset:], b)
+	f.modTime = time.Now()
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *memFileInfo) Name() string { return fi.name }
+
+func (fi *memFileInfo) Size() int64 { return fi.size }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | os.ModePerm
+	}
+	return 0644
+}
+
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+
+func (fi *memFileInfo) IsDir() bool { return fi.isDir }
+
+func (fi *memFileInfo) Sys() interface{} { return nil }
+
+// 内存文件系统中的文件锁
+type memLock struct {
+	fs   *MemFS
+	name string
+}
+
+func (l *memLock) Close() error {
+	l.fs.lock.Lock()
+	defer l.fs.lock.Unlock()
+	delete(l.fs.locks, l.name)
+	return nil
+}
@@ -0,0 +1,129 @@
+package vfs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemFS_File(t *testing.T) {
+	fs := NewMemFS()
+	_, err := fs.OpenFile(filepath.Join("no-dir", "a.data"), os.O_CREATE|os.O_RDWR, 0644)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, fs.MkdirAll(filepath.Join("tmp", "bitcask"), os.ModePerm))
+	name := filepath.Join("tmp", "bitcask", "a.data")
+	file, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	_, err = file.Write([]byte(" storage"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 7)
+	n, err := file.ReadAt(b, 11)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, []byte("storage"), b)
+	n, err = file.ReadAt(b, 15)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 3, n)
+
+	stat, err := fs.Stat(name)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(18), stat.Size())
+
+	//截断之后再扩展，空出来的部分是零
+	assert.Nil(t, file.Truncate(3))
+	_, err = file.Write([]byte("!"))
+	assert.Nil(t, err)
+	b = make([]byte, 4)
+	_, err = file.ReadAt(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bit!"), b)
+	assert.Nil(t, file.Truncate(2))
+	assert.Nil(t, file.Truncate(4))
+	_, err = file.ReadAt(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'b', 'i', 0, 0}, b)
+	assert.Nil(t, file.Close())
+
+	//只读打开的文件不能写入
+	file, err = fs.OpenFile(name, os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("x"))
+	assert.NotNil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+func TestMemFS_Dir(t *testing.T) {
+	fs := NewMemFS()
+	dir := filepath.Join("tmp", "bitcask")
+	assert.Nil(t, fs.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm))
+	for _, name := range []string{"b.data", "a.data"} {
+		file, err := fs.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR, 0644)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+	entries, err := fs.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "a.data", entries[0].Name())
+	assert.Equal(t, "sub", entries[2].Name())
+	assert.True(t, entries[2].IsDir())
+
+	//非空的目录不能直接删除
+	assert.NotNil(t, fs.Remove(dir))
+
+	//重命名文件和目录
+	assert.Nil(t, fs.Rename(filepath.Join(dir, "a.data"), filepath.Join(dir, "sub", "c.data")))
+	assert.Nil(t, fs.Rename(filepath.Join(dir, "sub"), filepath.Join("tmp", "moved")))
+	_, err = fs.Stat(filepath.Join("tmp", "moved", "c.data"))
+	assert.Nil(t, err)
+	_, err = fs.Stat(filepath.Join(dir, "a.data"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, fs.RemoveAll("tmp"))
+	_, err = fs.Stat(filepath.Join("tmp", "moved", "c.data"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = fs.ReadDir(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemFS_Lock(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll("bitcask", os.ModePerm))
+	name := filepath.Join("bitcask", "flock")
+	lock, err := fs.Lock(name)
+	assert.Nil(t, err)
+	_, err = fs.Lock(name)
+	assert.Equal(t, ErrLocked, err)
+
+	assert.Nil(t, lock.Close())
+	lock, err = fs.Lock(name)
+	assert.Nil(t, err)
+	assert.Nil(t, lock.Close())
+}
+
+func TestMemFS_Clear(t *testing.T) {
+	fs := NewMemFS()
+	assert.Nil(t, fs.MkdirAll(filepath.Join("a", "b"), os.ModePerm))
+	file, err := fs.OpenFile(filepath.Join("a", "b", "c"), os.O_CREATE|os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("data"))
+	assert.Nil(t, err)
+
+	fs.Clear()
+	_, err = fs.Stat(filepath.Join("a", "b", "c"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = fs.Stat("a")
+	assert.True(t, os.IsNotExist(err))
+	//已经打开的文件仍然可以读写
+	buf := make([]byte, 4)
+	_, err = file.ReadAt(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), buf)
+	assert.Nil(t, file.Close())
+}
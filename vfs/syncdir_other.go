@@ -0,0 +1,18 @@
+//go:build !windows
+
+package vfs
+
+import "os"
+
+// 打开目录并调用fsync，目录中的文件的创建、删除和重命名在这之后才是持久的
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
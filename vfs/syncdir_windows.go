@@ -0,0 +1,9 @@
+package vfs
+
+import "os"
+
+// windows上不能对目录调用FlushFileBuffers，NTFS的元数据操作由文件系统的日志保证，只检查目录是否存在
+func syncDir(dir string) error {
+	_, err := os.Stat(dir)
+	return err
+}
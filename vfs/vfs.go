@@ -0,0 +1,111 @@
+package vfs
+
+import (
+	"errors"
+	"github.com/gofrs/flock"
+	"io"
+	"os"
+)
+
+// 文件系统的抽象
+// 存储引擎对目录、文件的所有操作都通过FS接口完成，默认使用操作系统的文件系统(OS)
+// 也可以替换成内存文件系统(MemFS)，这样整个存储引擎都不需要访问磁盘
+
+var ErrLocked = errors.New("the file is locked by another process")
+
+// 打开的文件   *os.File已经实现了这个接口
+type File interface {
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+
+	//将文件的数据持久化到磁盘中
+	Sync() error
+
+	//关闭文件
+	Close() error
+
+	//获取文件信息
+	Stat() (os.FileInfo, error)
+
+	//将文件截断到指定的大小
+	Truncate(size int64) error
+}
+
+type FS interface {
+	//按照指定的模式打开文件，语义和os.OpenFile一致
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	//获取文件或者目录的信息，不存在时返回的错误满足os.IsNotExist
+	Stat(name string) (os.FileInfo, error)
+
+	//读取目录下的所有文件，按照文件名排序
+	ReadDir(name string) ([]os.DirEntry, error)
+
+	//创建目录以及所有不存在的上级目录
+	MkdirAll(path string, perm os.FileMode) error
+
+	//删除文件或者空目录
+	Remove(name string) error
+
+	//删除目录以及其中的所有内容，目录不存在时不返回错误
+	RemoveAll(path string) error
+
+	//重命名文件或者目录，目标文件已经存在时会被替换
+	Rename(oldPath, newPath string) error
+
+	//将目录的内容(其中文件的创建、删除以及重命名)持久化到磁盘中
+	SyncDir(dir string) error
+
+	//获取一把文件锁，保证同一时刻只有一个存储引擎实例打开数据目录   已经被其他实例持有时返回ErrLocked，关闭返回值即可释放锁
+	Lock(name string) (io.Closer, error)
+}
+
+// 操作系统的文件系统
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFS) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (osFS) SyncDir(dir string) error {
+	return syncDir(dir)
+}
+
+func (osFS) Lock(name string) (io.Closer, error) {
+	fileLock := flock.New(name)
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrLocked
+	}
+	return fileLock, nil
+}